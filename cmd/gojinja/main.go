@@ -0,0 +1,182 @@
+// Command gojinja provides tools for working with gojinja templates.
+//
+// Usage:
+//
+//	gojinja extract [flags] paths...
+//
+// The extract command walks the templates and writes the translatable strings
+// as a gettext `.pot` file. Directories are searched recursively for files
+// with one of the extensions given by -ext.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/ext"
+	"github.com/gojinja/gojinja/src/lexer"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "extract":
+		if err := extract(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "gojinja extract: %v\n", err)
+			os.Exit(1)
+		}
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "gojinja: unknown command %q\n", os.Args[1])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: gojinja <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	fmt.Fprintln(w, "  extract    extract translatable strings from templates into a .pot file")
+}
+
+// listFlag is a flag that can be given multiple times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func extract(args []string, stdout io.Writer) error {
+	info := *lexer.DefaultEnvLexerInformation()
+	var keywords, commentTags listFlag
+	var lineStatementPrefix, lineCommentPrefix string
+
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	output := flags.String("o", "", "write the .pot file to `file` instead of stdout")
+	exts := flags.String("ext", ".html,.htm,.xml,.txt,.jinja,.jinja2,.j2", "comma separated `extensions` of the templates in directories")
+	project := flags.String("project", "", "project `name` written to the header")
+	version := flags.String("version", "", "project `version` written to the header")
+	trimmed := flags.Bool("trimmed", false, "trim whitespace in trans blocks (the ext.i18n.trimmed policy)")
+	noDefaultKeywords := flags.Bool("no-default-keywords", false, "do not extract the default gettext functions")
+	flags.Var(&keywords, "k", "extract calls of the function `name` (can be repeated)")
+	flags.Var(&commentTags, "c", "extract comments starting with `tag` as translator comments (can be repeated)")
+	flags.StringVar(&info.BlockStartString, "block-start", info.BlockStartString, "block start `delimiter`")
+	flags.StringVar(&info.BlockEndString, "block-end", info.BlockEndString, "block end `delimiter`")
+	flags.StringVar(&info.VariableStartString, "variable-start", info.VariableStartString, "variable start `delimiter`")
+	flags.StringVar(&info.VariableEndString, "variable-end", info.VariableEndString, "variable end `delimiter`")
+	flags.StringVar(&info.CommentStartString, "comment-start", info.CommentStartString, "comment start `delimiter`")
+	flags.StringVar(&info.CommentEndString, "comment-end", info.CommentEndString, "comment end `delimiter`")
+	flags.StringVar(&lineStatementPrefix, "line-statement-prefix", "", "line statement `prefix`")
+	flags.StringVar(&lineCommentPrefix, "line-comment-prefix", "", "line comment `prefix`")
+	flags.BoolVar(&info.TrimBlocks, "trim-blocks", info.TrimBlocks, "remove the first newline after a block")
+	flags.BoolVar(&info.LStripBlocks, "lstrip-blocks", info.LStripBlocks, "strip whitespace before a block")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no input paths given")
+	}
+	if lineStatementPrefix != "" {
+		info.LineStatementPrefix = &lineStatementPrefix
+	}
+	if lineCommentPrefix != "" {
+		info.LineCommentPrefix = &lineCommentPrefix
+	}
+
+	opts := &ext.ExtractOptions{
+		EnvLexerInformation: &info,
+		CommentTags:         commentTags,
+		Trimmed:             *trimmed,
+	}
+	if !*noDefaultKeywords {
+		opts.Keywords = append(opts.Keywords, ext.GettextFunctions...)
+	}
+	opts.Keywords = append(opts.Keywords, keywords...)
+	if len(opts.Keywords) == 0 {
+		return fmt.Errorf("no keywords to extract")
+	}
+
+	files, err := collectFiles(flags.Args(), strings.Split(*exts, ","))
+	if err != nil {
+		return err
+	}
+
+	pot := ext.NewPot()
+	pot.Project = *project
+	pot.Version = *version
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		messages, err := ext.Extract(string(source), file, opts)
+		if err != nil {
+			return err
+		}
+		pot.Add(filepath.ToSlash(file), messages)
+	}
+
+	if *output == "" {
+		_, err = pot.WriteTo(stdout)
+		return err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if _, err = pot.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// collectFiles returns the files given as paths and the files with one of the
+// extensions found in the given directories.
+func collectFiles(paths []string, exts []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			for _, suffix := range exts {
+				if suffix = strings.TrimSpace(suffix); suffix != "" && strings.HasSuffix(file, suffix) {
+					files = append(files, file)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":        "[# TRANSLATORS: site title #][[ _('Welcome') ]]",
+		"nested/page.jinja": "[% trans %]Page[% endtrans %]",
+		"static/logo.svg":   "[[ _('Not a template') ]]",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	err := extract([]string{
+		"-c", "TRANSLATORS:",
+		"-block-start", "[%", "-block-end", "%]",
+		"-variable-start", "[[", "-variable-end", "]]",
+		"-comment-start", "[#", "-comment-end", "#]",
+		dir,
+	}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := out.String()
+	for _, expected := range []string{
+		"#. site title\n#: " + filepath.ToSlash(filepath.Join(dir, "index.html")) + ":1\nmsgid \"Welcome\"\n",
+		"msgid \"Page\"\n",
+	} {
+		if !strings.Contains(res, expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, res)
+		}
+	}
+	if strings.Contains(res, "Not a template") {
+		t.Errorf("files with other extensions should be skipped, got:\n%s", res)
+	}
+
+	if err := extract(nil, &out); err == nil {
+		t.Fatal("expected error without input paths")
+	}
+}
@@ -1,5 +1,12 @@
 package defaults
 
+import (
+	"fmt"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
 const BlockStartString = "{%"
 const BlockEndString = "%}"
 const VariableStartString = "{{"
@@ -15,7 +22,12 @@ var LineStatementPrefix *string = nil
 var LineCommentPrefix *string = nil
 
 var DefaultNamespace = map[string]any{
-	// TODO fill
+	"range":     runtime.ContextFunction(runtime.ContextRange),
+	"dict":      runtime.Dict,
+	"lipsum":    runtime.Lipsum,
+	"cycler":    cycler,
+	"joiner":    joiner,
+	"namespace": namespace,
 }
 var DefaultPolicies = map[string]any{
 	"compiler.ascii_str":   true,
@@ -27,3 +39,51 @@ var DefaultPolicies = map[string]any{
 	"json.dumps_kwargs":    map[string]any{"sort_keys": true},
 	"ext.i18n.trimmed":     false,
 }
+
+// cycler is the `cycler(*items)` global.
+func cycler(args []any, kwargs map[string]any) (any, error) {
+	if len(kwargs) > 0 {
+		return nil, errors.NewTemplateRuntimeError("cycler() takes no keyword arguments")
+	}
+	return runtime.NewCycler(args...)
+}
+
+// joiner is the `joiner(sep=", ")` global.
+func joiner(args []any, kwargs map[string]any) (any, error) {
+	var sep any = ", "
+	if len(args) > 1 {
+		return nil, errors.NewTemplateRuntimeError("joiner() takes at most 1 argument")
+	}
+	if len(args) == 1 {
+		sep = args[0]
+	}
+	for k, v := range kwargs {
+		if k != "sep" || len(args) == 1 {
+			return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("joiner() got an unexpected keyword argument '%s'", k))
+		}
+		sep = v
+	}
+	s, err := runtime.ToString(sep)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.NewJoiner(s), nil
+}
+
+// namespace is the `namespace(*args, **kwargs)` global, the arguments are
+// handled like the ones of `dict`.
+func namespace(args []any, kwargs map[string]any) (any, error) {
+	d, err := runtime.Dict(args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(map[string]any)
+	for k, v := range d.(map[any]any) {
+		name, ok := k.(string)
+		if !ok {
+			return nil, errors.NewTemplateRuntimeError("namespace attribute names must be strings")
+		}
+		attrs[name] = v
+	}
+	return runtime.NewNamespace(attrs), nil
+}
@@ -1,17 +1,22 @@
 package environment
 
 import (
+	stdErrors "errors"
 	"fmt"
 	"github.com/gojinja/gojinja/src/defaults"
+	"github.com/gojinja/gojinja/src/errors"
 	"github.com/gojinja/gojinja/src/extensions"
 	"github.com/gojinja/gojinja/src/filters"
 	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/parser"
 	"github.com/gojinja/gojinja/src/runtime"
 	"github.com/gojinja/gojinja/src/utils/maps"
 	"github.com/gojinja/gojinja/src/utils/slices"
 	lru "github.com/hashicorp/golang-lru"
-	"log"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type ExtensionsMap map[string]extensions.IExtension
@@ -23,7 +28,10 @@ type ExtensionsMap map[string]extensions.IExtension
 // Modifications on environments after the first template was loaded
 // will lead to surprising effects and undefined behavior.
 type Environment struct {
+	// Sandboxed environments check attribute accesses and calls with the
+	// `Sandbox` policy.
 	Sandboxed     bool
+	Sandbox       *Sandbox
 	Overlayed     bool
 	LinkedTo      *Environment
 	Shared        bool
@@ -34,6 +42,8 @@ type Environment struct {
 	Optimized  bool
 	Extensions ExtensionsMap
 	Undefined  UndefinedConstructor
+	// Finalize converts the values of expressions before they are printed.
+	// It's nil if `EnvOpts.Finalize` is passed a context or the environment.
 	Finalize   func(...any) any
 	AutoEscape func(name string) bool
 	Loader     *Loader
@@ -43,28 +53,77 @@ type Environment struct {
 	Tests      map[string]Test
 	Globals    map[string]any
 	Policies   map[string]any
+	Attributes map[string]any
+	Limits     Limits
+	// AttrLookup maps the attribute names of templates to the methods and
+	// fields of Go values.
+	AttrLookup runtime.AttrLookup
+	watcher    *watcher
+	// contextFinalize is the finalize function passed a context or the
+	// environment, it's used instead of `Finalize` if set.
+	contextFinalize func(ctx *runtime.Context, value any) any
 }
 
 type Cache interface {
 	Add(key, value interface{}) (evicted bool)
 	Get(key interface{}) (value interface{}, ok bool)
+	Peek(key interface{}) (value interface{}, ok bool)
+	Remove(key interface{}) (present bool)
+	Keys() []interface{}
 }
 
-type mapCache map[interface{}]interface{}
+type mapCache struct {
+	mu sync.RWMutex
+	m  map[interface{}]interface{}
+}
 
-func (m mapCache) Add(key, value interface{}) (evicted bool) {
-	m[key] = value
+func (c *mapCache) Add(key, value interface{}) (evicted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[key] = value
 	return false
 }
-func (m mapCache) Get(key interface{}) (value interface{}, ok bool) {
-	v, ok := m[key]
+
+func (c *mapCache) Get(key interface{}) (value interface{}, ok bool) {
+	return c.Peek(key)
+}
+
+func (c *mapCache) Peek(key interface{}) (value interface{}, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.m[key]
 	return v, ok
 }
 
+func (c *mapCache) Remove(key interface{}) (present bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, present = c.m[key]
+	delete(c.m, key)
+	return present
+}
+
+func (c *mapCache) Keys() []interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]interface{}, 0, len(c.m))
+	for k := range c.m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// cacheKey identifies a template in the cache, it mirrors jinja's `(weakref(loader), name)` tuple.
+type cacheKey struct {
+	loader *Loader
+	name   string
+}
+
 func New(opts *EnvOpts) (*Environment, error) {
 	var err error
 	env := &Environment{
-		Sandboxed:           false,
+		Sandboxed:           opts.Sandbox != nil,
+		Sandbox:             opts.Sandbox,
 		Overlayed:           false,
 		LinkedTo:            nil,
 		Shared:              false,
@@ -74,18 +133,28 @@ func New(opts *EnvOpts) (*Environment, error) {
 		EnvLexerInformation: opts.EnvLexerInformation,
 		Optimized:           opts.Optimized,
 		Undefined:           opts.Undefined,
-		Finalize:            opts.Finalize,
 		Loader:              opts.Loader,
 		AutoReload:          opts.AutoReload,
+		watcher:             newWatcher(),
 		Filters:             maps.Copy(filters.Default),
 		Tests:               maps.Copy(Default),
 		Globals:             maps.Copy(defaults.DefaultNamespace),
 		Policies:            maps.Copy(defaults.DefaultPolicies),
+		Attributes:          make(map[string]any),
+		Limits:              opts.Limits,
+		AttrLookup:          runtime.DefaultAttrLookup,
+	}
+	if opts.AttrLookup != nil {
+		env.AttrLookup = *opts.AttrLookup
 	}
 	env.AutoEscape, err = convertAutoEscape(opts.AutoEscape)
 	if err != nil {
 		return nil, err
 	}
+	env.Finalize, env.contextFinalize, err = convertFinalize(env, opts.Finalize)
+	if err != nil {
+		return nil, err
+	}
 
 	env.Cache, err = createCache(opts.CacheSize)
 	if err != nil {
@@ -110,6 +179,41 @@ func convertAutoEscape(ae any) (func(name string) bool, error) {
 	}
 }
 
+// ContextFinalize is a finalize function that is passed the active context.
+type ContextFinalize func(ctx *runtime.Context, value any) any
+
+// EvalContextFinalize is a finalize function that is passed the active eval
+// context.
+type EvalContextFinalize func(evalCtx *runtime.EvalContext, value any) any
+
+// EnvironmentFinalize is a finalize function that is passed the environment.
+type EnvironmentFinalize func(env *Environment, value any) any
+
+func convertFinalize(env *Environment, finalize any) (func(...any) any, func(ctx *runtime.Context, value any) any, error) {
+	switch v := finalize.(type) {
+	case nil:
+		return nil, nil, nil
+	case func(...any) any:
+		return v, nil, nil
+	case func(any) any:
+		return func(args ...any) any { return v(args[0]) }, nil, nil
+	case ContextFinalize:
+		return nil, v, nil
+	case func(*runtime.Context, any) any:
+		return nil, v, nil
+	case EvalContextFinalize:
+		return nil, func(ctx *runtime.Context, value any) any { return v(ctx.EvalCtx, value) }, nil
+	case func(*runtime.EvalContext, any) any:
+		return nil, func(ctx *runtime.Context, value any) any { return v(ctx.EvalCtx, value) }, nil
+	case EnvironmentFinalize:
+		return nil, func(_ *runtime.Context, value any) any { return v(env, value) }, nil
+	case func(*Environment, any) any:
+		return nil, func(_ *runtime.Context, value any) any { return v(env, value) }, nil
+	default:
+		return nil, nil, fmt.Errorf("unexpected type of Finalize")
+	}
+}
+
 func configCheck(env *Environment) error {
 	if env.BlockStartString == env.VariableStartString || env.BlockStartString == env.CommentStartString || env.CommentStartString == env.VariableStartString {
 		return fmt.Errorf("block, variable and comment start strings must be different")
@@ -133,7 +237,7 @@ func createCache(cacheSize int) (Cache, error) {
 		return lru.New(cacheSize)
 	}
 	if cacheSize < 0 {
-		return make(mapCache), nil
+		return &mapCache{m: make(map[interface{}]interface{})}, nil
 	}
 	return nil, nil
 }
@@ -143,28 +247,39 @@ type EnvOpts struct {
 	Optimized  bool
 	Extensions map[string]func(*Environment) extensions.IExtension // TODO jinja accepts also extensions names but it's python import magic I don't know how to do it in golang.
 	Undefined  UndefinedConstructor
-	Finalize   func(...any) any
+	// Finalize converts the values of expressions before they are printed,
+	// returning an error fails the render. It's a func(...any) any or
+	// func(any) any passed the value, or a `ContextFinalize`,
+	// `EvalContextFinalize` or `EnvironmentFinalize`, also as unnamed func
+	// types, that is additionally passed the active context, eval context or
+	// the environment.
+	Finalize   any
 	AutoEscape any // bool or func(string)bool
 	Loader     *Loader
 	CacheSize  int
 	AutoReload bool
+	Sandbox    *Sandbox // sandboxes the environment if set, see `NewSandboxed`
+	Limits     Limits
+	AttrLookup *runtime.AttrLookup // `runtime.DefaultAttrLookup` if nil
 }
 
-type UndefinedConstructor func(hint *string, obj any, name *string, exc func(msg string) error, logger *log.Logger) runtime.IUndefined
+// UndefinedConstructor creates the undefined values of the environment, e.g.
+// `runtime.NewStrictUndefined`, `runtime.MakeLoggingUndefined(logger, nil)` or
+// a custom constructor.
+type UndefinedConstructor = runtime.UndefinedConstructor
 
 func DefaultEnvOpts() *EnvOpts {
 	return &EnvOpts{
 		Optimized:           true,
 		Extensions:          nil,
 		EnvLexerInformation: lexer.DefaultEnvLexerInformation(),
-		Undefined: func(hint *string, obj any, name *string, exc func(msg string) error, logger *log.Logger) runtime.IUndefined {
-			return runtime.NewUndefined(hint, obj, name, exc, logger)
-		},
-		Finalize:   nil,
-		AutoEscape: false,
-		Loader:     nil,
-		CacheSize:  400,
-		AutoReload: true,
+		Undefined:           runtime.NewUndefined,
+		Finalize:            nil,
+		AutoEscape:          false,
+		Loader:              nil,
+		CacheSize:           400,
+		AutoReload:          true,
+		Limits:              Limits{MaxRecursionDepth: DefaultMaxRecursionDepth},
 	}
 }
 
@@ -184,6 +299,47 @@ func (env *Environment) GetTemplate(name any, parent *string, globals map[string
 	}
 }
 
+// SelectTemplate works like `GetTemplate` but tries the names in order and
+// returns the first template that exists. Undefined names are skipped. If
+// none of the templates exist a `TemplatesNotFound` error is raised.
+func (env *Environment) SelectTemplate(names []any, parent *string, globals map[string]any) (ITemplate, error) {
+	if len(names) == 0 {
+		return nil, errors.NewTemplatesNotFound(nil, "Tried to select from an empty list of templates.")
+	}
+	for _, name := range names {
+		if _, ok := name.(runtime.IUndefined); ok {
+			continue
+		}
+		tmpl, err := env.GetTemplate(name, parent, globals)
+		if err == nil {
+			return tmpl, nil
+		}
+		var notFound *errors.TemplateNotFoundError
+		if !stdErrors.As(err, &notFound) {
+			return nil, err
+		}
+	}
+	strNames := make([]string, len(names))
+	for i, name := range names {
+		strNames[i], _ = runtime.ToString(name)
+	}
+	return nil, errors.NewTemplatesNotFound(strNames, "")
+}
+
+// GetOrSelectTemplate calls `GetTemplate` for a template name or template
+// and `SelectTemplate` for an iterable of names.
+func (env *Environment) GetOrSelectTemplate(nameOrList any, parent *string, globals map[string]any) (ITemplate, error) {
+	switch nameOrList.(type) {
+	case string, ITemplate:
+		return env.GetTemplate(nameOrList, parent, globals)
+	}
+	names, err := runtime.Iterate(nameOrList)
+	if err != nil {
+		return nil, err
+	}
+	return env.SelectTemplate(names, parent, globals)
+}
+
 // JoinPath joins a template with the parent. By default, all the lookups are
 // relative to the loader root so this method returns the `template`
 // parameter unchanged, but if the paths should be relative to the
@@ -201,28 +357,111 @@ func (env *Environment) loadTemplate(name string, globals map[string]any) (ITemp
 	if env.Loader == nil {
 		return nil, fmt.Errorf("no loader for this environment specified")
 	}
-	cacheKey := fmt.Sprint(env.Loader, name)
+	key := cacheKey{env.Loader, name}
 
 	if env.Cache != nil {
-		template, ok := env.Cache.Get(cacheKey)
+		template, ok := env.Cache.Get(key)
 		if ok {
 			tmpl := template.(ITemplate)
-			if !env.AutoReload && tmpl.IsUpToDate() {
+			// When templates are watched, stale entries are evicted by the watcher.
+			if !env.AutoReload || env.watcher.running() || tmpl.IsUpToDate() {
 				maps.Update(tmpl.Globals(), globals)
+				return tmpl, nil
 			}
-			return tmpl, nil
 		}
 	}
-	template, err := (*env.Loader).Load(env, name, env.MakeGlobals(globals))
+	template, err := env.Loader.Load(env, name, env.MakeGlobals(globals))
 	if err != nil {
 		return nil, err
 	}
 	if env.Cache != nil {
-		env.Cache.Add(cacheKey, template)
+		env.Cache.Add(key, template)
 	}
+	env.watcher.trackDependencies(name, template)
 	return template, nil
 }
 
+// iterExtensions returns the extensions of the environment sorted by their
+// priorities. Extensions with equal priorities are sorted by their names.
+func (env *Environment) iterExtensions() []extensions.IExtension {
+	res := make([]extensions.IExtension, 0, len(env.Extensions))
+	for _, k := range maps.SortedKeys(env.Extensions) {
+		res = append(res, env.Extensions[k])
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Priority() < res[j].Priority()
+	})
+	return res
+}
+
+// Extend adds the attributes to the environment if they do not exist yet.
+// This is used by extensions to register callbacks and configuration values.
+func (env *Environment) Extend(attributes map[string]any) {
+	for k, v := range attributes {
+		if _, ok := env.Attributes[k]; !ok {
+			env.Attributes[k] = v
+		}
+	}
+}
+
+// Lex lexes the given source code and returns the raw tokens. This can be
+// useful for extension development and debugging templates.
+//
+// This does not perform preprocessing. If you want the preprocessing
+// of the extensions to be applied you have to filter source through
+// the `Preprocess` method.
+func (env *Environment) Lex(source string, name *string, filename *string) ([]lexer.RawToken, error) {
+	return lexer.GetLexer(env.EnvLexerInformation).Tokeniter(source, name, filename, nil)
+}
+
+// Parse parses the source code and returns the abstract syntax tree. This
+// tree of nodes is used by the renderer to evaluate the template, and it can
+// also be used by developers to inspect templates.
+func (env *Environment) Parse(source string, name *string, filename *string) (*nodes.Template, error) {
+	return env.parse(source, name, filename)
+}
+
+// Preprocess preprocesses the source with all extensions. This is
+// automatically called for all parsing and compiling methods but *not*
+// for `Lex` because there you usually only want the actual source tokenized.
+func (env *Environment) Preprocess(source string, name *string, filename *string) string {
+	for _, ext := range env.iterExtensions() {
+		source = ext.Preprocess(source, name, filename)
+	}
+	return source
+}
+
+// tokenize preprocesses the source with the extensions, tokenizes it and
+// passes the token stream through the stream filters of the extensions.
+func (env *Environment) tokenize(source string, name *string, filename *string, state *string) (*lexer.TokenStream, error) {
+	source = env.Preprocess(source, name, filename)
+	stream, err := lexer.GetLexer(env.EnvLexerInformation).Tokenize(source, name, filename, state)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range env.iterExtensions() {
+		if stream, err = ext.FilterStream(stream); err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+// parse parses the source code into the abstract syntax tree of the template.
+func (env *Environment) parse(source string, name *string, filename *string) (*nodes.Template, error) {
+	stream, err := env.tokenize(source, name, filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parser.NewParser(stream, env.iterExtensions(), name, filename, nil).Parse()
+}
+
 func (env *Environment) MakeGlobals(globals map[string]any) map[string]any {
 	return maps.Chain(globals, env.Globals)
 }
+
+// FromString loads a template from a source string without using the loader.
+// The template has no name, error messages refer to it as "<template>".
+func (env *Environment) FromString(source string, globals map[string]any) (*Template, error) {
+	return env.TemplateClass.FromSource(env, source, nil, nil, env.MakeGlobals(globals), nil)
+}
@@ -1,7 +1,143 @@
 package environment
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/nodes"
+)
 
 func TestCompile(t *testing.T) {
 	// Just to compile this module
 }
+
+type upperExtension struct {
+	extensions.Extension
+}
+
+func (upperExtension) Tags() []string {
+	return []string{"shout"}
+}
+
+// Parse parses `{% shout expr %}` into `{% set fi1 = expr %}{{ fi1 }}!`.
+func (upperExtension) Parse(p extensions.IParser) ([]nodes.Node, error) {
+	lineno := p.Stream().Next().Lineno
+	expr, err := p.ParseExpression(true)
+	if err != nil {
+		return nil, err
+	}
+	if p.Stream().Current().Test("comma") {
+		return nil, p.Fail("shout takes a single expression", nil)
+	}
+	target := p.FreeIdentifier(&lineno)
+	return []nodes.Node{
+		&nodes.Assign{Target: target, Node: expr, StmtCommon: nodes.StmtCommon{Lineno: lineno}},
+		&nodes.Output{
+			Nodes: []nodes.Expr{
+				&nodes.InternalName{Name: target.Name, ExprCommon: nodes.ExprCommon{Lineno: lineno}},
+				&nodes.TemplateData{Data: "!", LiteralCommon: nodes.LiteralCommon{Lineno: lineno}},
+			},
+			StmtCommon: nodes.StmtCommon{Lineno: lineno},
+		},
+	}, nil
+}
+
+func (upperExtension) Preprocess(source string, _ *string, _ *string) string {
+	return strings.ReplaceAll(source, "hello", "HELLO")
+}
+
+func (upperExtension) FilterStream(stream *lexer.TokenStream) (*lexer.TokenStream, error) {
+	var tokens []lexer.Token
+	for ; !stream.Eos(); stream.Next() {
+		tok := stream.Current()
+		if tok.Type == lexer.TokenData {
+			tok.Value = strings.ReplaceAll(tok.Value.(string), "world", "WORLD")
+		}
+		tokens = append(tokens, tok)
+	}
+	return lexer.NewTokenStream(tokens, nil, nil), nil
+}
+
+func TestLexParsePreprocess(t *testing.T) {
+	opts := DefaultEnvOpts()
+	opts.Extensions = map[string]func(*Environment) extensions.IExtension{
+		"upper": func(*Environment) extensions.IExtension { return upperExtension{} },
+	}
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := env.Preprocess("hello {{ x }}", nil, nil); res != "HELLO {{ x }}" {
+		t.Fatalf("unexpected preprocessed source %q", res)
+	}
+
+	tokens, err := env.Lex("hello {# note #}{{ x }}", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens[0].Type != lexer.TokenData || tokens[0].Value != "hello " {
+		t.Fatalf("expected unprocessed data token, got %v", tokens[0])
+	}
+	foundComment := false
+	for _, tok := range tokens {
+		if tok.Type == lexer.TokenComment && strings.TrimSpace(tok.Value) == "note" {
+			foundComment = true
+		}
+	}
+	if !foundComment {
+		t.Fatalf("expected comment token in %v", tokens)
+	}
+
+	ast, err := env.Parse("hello {{ x }}", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := ast.Body[0].(*nodes.Output)
+	if data := output.Nodes[0].(*nodes.TemplateData); data.Data != "HELLO " {
+		t.Fatalf("expected preprocessed data, got %q", data.Data)
+	}
+	if name := output.Nodes[1].(*nodes.Name); name.Name != "x" {
+		t.Fatalf("unexpected node %v", name)
+	}
+}
+
+func TestExtension(t *testing.T) {
+	opts := DefaultEnvOpts()
+	opts.Extensions = map[string]func(*Environment) extensions.IExtension{
+		"upper": func(env *Environment) extensions.IExtension {
+			env.Globals["greeting"] = "hi"
+			env.Extend(map[string]any{"shout_suffix": "!"})
+			return upperExtension{}
+		},
+	}
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Attributes["shout_suffix"] != "!" {
+		t.Fatalf("expected extension attribute, got %v", env.Attributes)
+	}
+	env.Extend(map[string]any{"shout_suffix": "?"})
+	if env.Attributes["shout_suffix"] != "!" {
+		t.Fatal("Extend overwrote an existing attribute")
+	}
+
+	tmpl, err := env.FromString("hello world {% shout greeting %}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := tmpl.Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "HELLO WORLD hi!" {
+		t.Fatalf("unexpected output %q", res)
+	}
+
+	if _, err := env.FromString("{% shout 1, 2 %}", nil); err == nil || !strings.Contains(err.Error(), "shout takes a single expression") {
+		t.Fatalf("expected syntax error, got %v", err)
+	}
+}
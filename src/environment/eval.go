@@ -0,0 +1,508 @@
+package environment
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+var binaryOperators = map[string]func(a, b any) (any, error){
+	lexer.TokenAdd:      runtime.Add,
+	lexer.TokenSub:      runtime.Sub,
+	lexer.TokenMul:      runtime.Mul,
+	lexer.TokenDiv:      runtime.Div,
+	lexer.TokenFloordiv: runtime.FloorDiv,
+	lexer.TokenMod:      runtime.Mod,
+	lexer.TokenPow:      runtime.Pow,
+}
+
+var unaryOperators = map[string]func(a any) (any, error){
+	lexer.TokenAdd: runtime.Pos,
+	lexer.TokenSub: runtime.Neg,
+}
+
+// undefinedGuards are the tests and filters handling undefined values, the
+// undefined values passed to them aren't reported by audited renders.
+var undefinedGuards = map[string]bool{
+	"defined":   true,
+	"undefined": true,
+	"default":   true,
+	"d":         true,
+}
+
+func (r *renderer) eval(node nodes.Expr, f *frame) (any, error) {
+	if err := r.ctx.State.Step(); err != nil {
+		return nil, r.wrapError(err, node.GetLineno())
+	}
+	r.lineno = node.GetLineno()
+	v, err := r.evalExpr(node, f)
+	return v, r.wrapError(err, node.GetLineno())
+}
+
+func (r *renderer) evalBool(node nodes.Expr, f *frame) (bool, error) {
+	v, err := r.eval(node, f)
+	if err != nil {
+		return false, err
+	}
+	b, err := runtime.Bool(v)
+	return b, r.wrapError(err, node.GetLineno())
+}
+
+func (r *renderer) evalExprs(exprs []nodes.Expr, f *frame) ([]any, error) {
+	res := make([]any, len(exprs))
+	for i, e := range exprs {
+		v, err := r.eval(e, f)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+func (r *renderer) evalExpr(node nodes.Expr, f *frame) (any, error) {
+	switch n := node.(type) {
+	case *nodes.Const:
+		return n.Value, nil
+	case *nodes.TemplateData:
+		if r.ctx.EvalCtx.AutoEscape {
+			return runtime.Markup(n.Data), nil
+		}
+		return n.Data, nil
+	case *nodes.Name:
+		return r.resolve(f, n.Name), nil
+	case *nodes.InternalName:
+		return r.resolve(f, n.Name), nil
+	case *nodes.ContextReference:
+		return r.ctx, nil
+	case *nodes.DerivedContextReference:
+		return derivedContext(r.ctx, f.locals()), nil
+	case *nodes.Tuple:
+		return r.evalExprs(n.Items, f)
+	case *nodes.List:
+		items, err := r.evalExprs(n.Items, f)
+		if err != nil {
+			return nil, err
+		}
+		return &items, nil
+	case *nodes.Dict:
+		return r.evalDict(n, f)
+	case *nodes.Getattr:
+		obj, err := r.eval(n.Node, f)
+		if err != nil {
+			return nil, err
+		}
+		return r.getattr(obj, n.Attr)
+	case *nodes.Getitem:
+		return r.evalGetitem(n, f)
+	case *nodes.Call:
+		return r.evalCall(n, f)
+	case *nodes.Filter:
+		return r.evalFilter(n, f, nil)
+	case *nodes.Test:
+		return r.evalTest(n, f)
+	case *nodes.CondExpr:
+		test, err := r.evalBool(n.Test, f)
+		if err != nil {
+			return nil, err
+		}
+		if test {
+			return r.eval(n.Expr1, f)
+		}
+		if n.Expr2 == nil {
+			hint := fmt.Sprintf("the inline if-expression on line %d evaluated to false and no else section was defined.", n.Lineno)
+			return r.undefined(&hint, nil, nil), nil
+		}
+		return r.eval(*n.Expr2, f)
+	case *nodes.BinExpr:
+		return r.evalBinExpr(n, f)
+	case *nodes.UnaryExpr:
+		return r.evalUnaryExpr(n, f)
+	case *nodes.Compare:
+		return r.evalCompare(n, f)
+	case *nodes.Concat:
+		return r.evalConcat(n, f)
+	}
+	return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("evaluation of %s nodes is not supported", reflect.TypeOf(node).Elem().Name()))
+}
+
+func (r *renderer) evalDict(n *nodes.Dict, f *frame) (any, error) {
+	res := make(map[any]any, len(n.Items))
+	for _, pair := range n.Items {
+		key, err := r.eval(pair.Key, f)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("unhashable type: '%s'", runtime.TypeName(key)))
+		}
+		value, err := r.eval(pair.Value, f)
+		if err != nil {
+			return nil, err
+		}
+		res[key] = value
+	}
+	return res, nil
+}
+
+// getattr gets an attribute of the object. If there is no such attribute an
+// item with the name is looked up instead. Objects with a `GetAttr` method
+// returning a bool, like namespaces, report missing attributes with false.
+func (r *renderer) getattr(obj any, attr string) (any, error) {
+	switch u := obj.(type) {
+	case interface{ GetAttr(string) (any, error) }:
+		v, err := u.GetAttr(attr)
+		if err != nil {
+			return nil, err
+		}
+		return r.checkAttr(obj, attr, r.checkUndefined(obj, attr, v)), nil
+	case interface{ GetAttr(string) (any, bool) }:
+		if v, ok := u.GetAttr(attr); ok {
+			return r.checkAttr(obj, attr, v), nil
+		}
+		return r.lookupUndefined(nil, obj, attr), nil
+	}
+	if v, ok := r.env.AttrLookup.GetAttr(obj, attr); ok {
+		return r.checkAttr(obj, attr, v), nil
+	}
+	if v, ok, err := r.lookupItem(obj, attr); ok || err != nil {
+		return v, err
+	}
+	return r.lookupUndefined(nil, obj, attr), nil
+}
+
+// lookupItem subscribes the object, reporting false if there is no such item.
+func (r *renderer) lookupItem(obj any, key any) (any, bool, error) {
+	v, ok, err := runtime.GetItem(obj, key)
+	if _, isOp := obj.(runtime.GetItemOp); isOp && ok {
+		v = r.checkUndefined(obj, key, v)
+	}
+	return v, ok, err
+}
+
+// getitem subscribes the object. If there is no such item an attribute with
+// the name is looked up instead.
+func (r *renderer) getitem(obj any, key any) (any, error) {
+	v, ok, err := r.lookupItem(obj, key)
+	if ok || err != nil {
+		return v, err
+	}
+	if name, isStr := key.(string); isStr {
+		if v, ok := r.env.AttrLookup.GetAttr(obj, name); ok {
+			return r.checkAttr(obj, name, v), nil
+		}
+		return r.lookupUndefined(nil, obj, name), nil
+	}
+	return r.lookupUndefined(nil, obj, key), nil
+}
+
+func (r *renderer) evalGetitem(n *nodes.Getitem, f *frame) (any, error) {
+	obj, err := r.eval(n.Node, f)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	if s, ok := n.Arg.(*nodes.Slice); ok {
+		key, err = r.evalSlice(s, f)
+	} else {
+		key, err = r.eval(n.Arg, f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.getitem(obj, key)
+}
+
+func (r *renderer) evalSlice(n *nodes.Slice, f *frame) (runtime.Slice, error) {
+	var res runtime.Slice
+	parts := []struct {
+		expr *nodes.Expr
+		dest **int
+	}{{n.Start, &res.Start}, {n.Stop, &res.Stop}, {n.Step, &res.Step}}
+	for _, part := range parts {
+		if part.expr == nil {
+			continue
+		}
+		v, err := r.eval(*part.expr, f)
+		if err != nil {
+			return res, err
+		}
+		if v == nil {
+			continue
+		}
+		i, ok := toInt(v)
+		if !ok {
+			return res, errors.NewTemplateRuntimeError("slice indices must be integers or None")
+		}
+		idx := int(i)
+		*part.dest = &idx
+	}
+	return res, nil
+}
+
+// evalArgs evaluates the arguments of a call, filter or test.
+func (r *renderer) evalArgs(args []nodes.Expr, kwargs []nodes.Keyword, dynArgs *nodes.Expr, dynKwargs *nodes.Expr, f *frame) ([]any, map[string]any, error) {
+	posArgs, err := r.evalExprs(args, f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dynArgs != nil {
+		v, err := r.eval(*dynArgs, f)
+		if err != nil {
+			return nil, nil, err
+		}
+		items, err := runtime.Iterate(v)
+		if err != nil {
+			return nil, nil, r.wrapError(err, (*dynArgs).GetLineno())
+		}
+		posArgs = append(posArgs, items...)
+	}
+
+	kw := make(map[string]any, len(kwargs))
+	for _, k := range kwargs {
+		v, err := r.eval(k.Value, f)
+		if err != nil {
+			return nil, nil, err
+		}
+		kw[k.Key] = v
+	}
+	if dynKwargs != nil {
+		v, err := r.eval(*dynKwargs, f)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, err := runtime.Iterate(v)
+		if err != nil {
+			return nil, nil, r.wrapError(err, (*dynKwargs).GetLineno())
+		}
+		for _, key := range keys {
+			name, ok := key.(string)
+			if !ok {
+				return nil, nil, errors.NewTemplateRuntimeError("keywords must be strings")
+			}
+			kw[name], _, _ = runtime.GetItem(v, key)
+		}
+	}
+	return posArgs, kw, nil
+}
+
+func (r *renderer) evalCall(n *nodes.Call, f *frame) (any, error) {
+	fn, err := r.eval(n.Node, f)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkCallable(fn); err != nil {
+		return nil, err
+	}
+	args, kwargs, err := r.evalArgs(n.Args, n.Kwargs, n.DynArgs, n.DynKwargs, f)
+	if err != nil {
+		return nil, err
+	}
+	return r.ctx.Call(fn, args, kwargs)
+}
+
+// evalFilter applies the filter. Filters without a node (used by filter blocks
+// and block assignments) are applied to value.
+func (r *renderer) evalFilter(n *nodes.Filter, f *frame, value any) (any, error) {
+	filter, ok := r.env.Filters[n.Name]
+	if !ok {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("no filter named %q", n.Name))
+	}
+	if n.Node != nil {
+		var err error
+		if undefinedGuards[n.Name] {
+			r.guarded++
+		}
+		if inner, ok := (*n.Node).(*nodes.Filter); ok {
+			value, err = r.evalFilter(inner, f, value)
+		} else {
+			value, err = r.eval(*n.Node, f)
+		}
+		if undefinedGuards[n.Name] {
+			r.guarded--
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	args, kwargs, err := r.evalArgs(n.Args, n.Kwargs, n.DynArgs, n.DynKwargs, f)
+	if err != nil {
+		return nil, err
+	}
+	res := filter(append([]any{value}, args...), kwargs)
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *renderer) evalTest(n *nodes.Test, f *frame) (any, error) {
+	test, ok := r.env.Tests[n.Name]
+	if !ok {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("no test named %q", n.Name))
+	}
+	if undefinedGuards[n.Name] {
+		r.guarded++
+	}
+	value, err := r.eval(*n.Node, f)
+	if undefinedGuards[n.Name] {
+		r.guarded--
+	}
+	if err != nil {
+		return nil, err
+	}
+	args, kwargs, err := r.evalArgs(n.Args, n.Kwargs, n.DynArgs, n.DynKwargs, f)
+	if err != nil {
+		return nil, err
+	}
+	if len(kwargs) > 0 {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("test %q doesn't accept keyword arguments", n.Name))
+	}
+	return test(r.env, value, args...)
+}
+
+func (r *renderer) evalBinExpr(n *nodes.BinExpr, f *frame) (any, error) {
+	left, err := r.eval(n.Left, f)
+	if err != nil {
+		return nil, err
+	}
+	switch n.Op {
+	case "and", "or":
+		b, err := runtime.Bool(left)
+		if err != nil {
+			return nil, err
+		}
+		if b == (n.Op == "or") {
+			return left, nil
+		}
+		return r.eval(n.Right, f)
+	}
+	if _, ok := binaryOperators[n.Op]; !ok {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("unknown binary operator %q", n.Op))
+	}
+	right, err := r.eval(n.Right, f)
+	if err != nil {
+		return nil, err
+	}
+	if n.Op == lexer.TokenMul {
+		if err := checkRepeatSize(r.ctx.State, left, right); err != nil {
+			return nil, err
+		}
+		if err := checkRepeatSize(r.ctx.State, right, left); err != nil {
+			return nil, err
+		}
+	}
+	res, err := r.callBinop(n.Op, left, right)
+	if err != nil {
+		return nil, err
+	}
+	return res, checkStringSize(r.ctx.State, res)
+}
+
+func (r *renderer) evalUnaryExpr(n *nodes.UnaryExpr, f *frame) (any, error) {
+	value, err := r.eval(n.Node, f)
+	if err != nil {
+		return nil, err
+	}
+	if n.Op == "not" {
+		b, err := runtime.Bool(value)
+		return !b, err
+	}
+	if _, ok := unaryOperators[n.Op]; !ok {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("unknown unary operator %q", n.Op))
+	}
+	return r.callUnop(n.Op, value)
+}
+
+func compare(op string, a, b any) (bool, error) {
+	switch op {
+	case lexer.TokenEq:
+		return runtime.Eq(a, b)
+	case lexer.TokenNe:
+		return runtime.Ne(a, b)
+	case "in":
+		return runtime.Contains(b, a)
+	case "notin":
+		in, err := runtime.Contains(b, a)
+		return !in, err
+	}
+	return runtime.Compare(op, a, b)
+}
+
+func (r *renderer) evalCompare(n *nodes.Compare, f *frame) (any, error) {
+	left, err := r.eval(n.Expr, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range n.Ops {
+		right, err := r.eval(op.Expr.(nodes.Expr), f)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := compare(op.Op, left, right)
+		if err != nil || !ok {
+			return false, err
+		}
+		left = right
+	}
+	return true, nil
+}
+
+// evalConcat concatenates the operands, see `concat`.
+func (r *renderer) evalConcat(n *nodes.Concat, f *frame) (any, error) {
+	values, err := r.evalExprs(n.Nodes, f)
+	if err != nil {
+		return nil, err
+	}
+	if !r.interceptsConcat() || len(values) == 0 {
+		return concat(r.ctx, values)
+	}
+	res := values[0]
+	for _, v := range values[1:] {
+		if res, err = r.callBinop(lexer.TokenTilde, res, v); err != nil {
+			return nil, err
+		}
+	}
+	return res, checkStringSize(r.ctx.State, res)
+}
+
+// concat converts the values to strings and joins them. With autoescaping
+// enabled the result is markup if any of the values is markup.
+func concat(ctx *runtime.Context, values []any) (any, error) {
+	var err error
+	escape := false
+	if ctx.EvalCtx.AutoEscape {
+		for _, v := range values {
+			if _, ok := v.(runtime.Escaped); ok {
+				escape = true
+				break
+			}
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		var s string
+		if escape {
+			m, err := runtime.Escape(v)
+			if err != nil {
+				return nil, err
+			}
+			s = string(m)
+		} else if s, err = runtime.ToString(v); err != nil {
+			return nil, err
+		}
+		b.WriteString(s)
+	}
+	if err := ctx.State.CheckOutput(b.Len()); err != nil {
+		return nil, err
+	}
+	if escape {
+		return runtime.Markup(b.String()), nil
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,321 @@
+package environment
+
+import (
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+// Money is an amount of cents in a currency, it can be added to money of the
+// same currency and multiplied by integers.
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%d.%02d %s", m.Cents/100, m.Cents%100, m.Currency)
+}
+
+func (m Money) Add(other any) (any, error) {
+	o, ok := other.(Money)
+	if !ok {
+		return runtime.NotImplemented, nil
+	}
+	if o.Currency != m.Currency {
+		return nil, fmt.Errorf("can't add %s to %s", o.Currency, m.Currency)
+	}
+	return Money{m.Cents + o.Cents, m.Currency}, nil
+}
+
+func (m Money) Mul(other any) (any, error) {
+	n, ok := runtime.ToInt(other)
+	if !ok {
+		return runtime.NotImplemented, nil
+	}
+	return Money{m.Cents * n, m.Currency}, nil
+}
+
+func (m Money) RMul(other any) (any, error) {
+	return m.Mul(other)
+}
+
+func (m Money) Eq(other any) (any, error) {
+	o, ok := other.(Money)
+	return ok && o == m, nil
+}
+
+func (m Money) Lt(other any) (any, error) {
+	o, ok := other.(Money)
+	if !ok || o.Currency != m.Currency {
+		return runtime.NotImplemented, nil
+	}
+	return m.Cents < o.Cents, nil
+}
+
+func (m Money) Gt(other any) (any, error) {
+	o, ok := other.(Money)
+	if !ok || o.Currency != m.Currency {
+		return runtime.NotImplemented, nil
+	}
+	return m.Cents > o.Cents, nil
+}
+
+func (m Money) Bool() (bool, error) {
+	return m.Cents != 0, nil
+}
+
+// Decimal is an exact decimal number, it supports the arithmetic with
+// integers on either side.
+type Decimal struct {
+	r *big.Rat
+}
+
+func NewDecimal(s string) Decimal {
+	r, _ := new(big.Rat).SetString(s)
+	return Decimal{r}
+}
+
+func (d Decimal) String() string {
+	return d.r.FloatString(2)
+}
+
+func toRat(v any) (*big.Rat, bool) {
+	if d, ok := v.(Decimal); ok {
+		return d.r, true
+	}
+	if i, ok := runtime.ToInt(v); ok {
+		return new(big.Rat).SetInt64(i), true
+	}
+	return nil, false
+}
+
+func (d Decimal) arithmetic(other any, reflected bool, op func(z, x, y *big.Rat) *big.Rat) (any, error) {
+	o, ok := toRat(other)
+	if !ok {
+		return runtime.NotImplemented, nil
+	}
+	x, y := d.r, o
+	if reflected {
+		x, y = y, x
+	}
+	return Decimal{op(new(big.Rat), x, y)}, nil
+}
+
+func (d Decimal) Add(other any) (any, error) {
+	return d.arithmetic(other, false, (*big.Rat).Add)
+}
+
+func (d Decimal) RAdd(other any) (any, error) {
+	return d.arithmetic(other, true, (*big.Rat).Add)
+}
+
+func (d Decimal) Sub(other any) (any, error) {
+	return d.arithmetic(other, false, (*big.Rat).Sub)
+}
+
+func (d Decimal) RSub(other any) (any, error) {
+	return d.arithmetic(other, true, (*big.Rat).Sub)
+}
+
+func (d Decimal) Div(other any) (any, error) {
+	if o, ok := toRat(other); ok && o.Sign() == 0 {
+		return nil, fmt.Errorf("decimal division by zero")
+	}
+	return d.arithmetic(other, false, (*big.Rat).Quo)
+}
+
+func (d Decimal) RDiv(other any) (any, error) {
+	if d.r.Sign() == 0 {
+		return nil, fmt.Errorf("decimal division by zero")
+	}
+	return d.arithmetic(other, true, (*big.Rat).Quo)
+}
+
+func (d Decimal) Neg() (any, error) {
+	return Decimal{new(big.Rat).Neg(d.r)}, nil
+}
+
+func (d Decimal) Eq(other any) (any, error) {
+	o, ok := toRat(other)
+	return ok && o.Cmp(d.r) == 0, nil
+}
+
+func (d Decimal) Lt(other any) (any, error) {
+	o, ok := toRat(other)
+	if !ok {
+		return runtime.NotImplemented, nil
+	}
+	return d.r.Cmp(o) < 0, nil
+}
+
+func (d Decimal) Gt(other any) (any, error) {
+	o, ok := toRat(other)
+	if !ok {
+		return runtime.NotImplemented, nil
+	}
+	return d.r.Cmp(o) > 0, nil
+}
+
+// basket is a container implementing the protocols of collections.
+type basket struct {
+	items []string
+}
+
+func (b basket) Len() (int, error) {
+	return len(b.items), nil
+}
+
+func (b basket) Iter() ([]any, error) {
+	res := make([]any, len(b.items))
+	for i, item := range b.items {
+		res[i] = strings.ToUpper(item)
+	}
+	return res, nil
+}
+
+func (b basket) Contains(item any) (bool, error) {
+	for _, it := range b.items {
+		if strings.EqualFold(it, fmt.Sprint(item)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b basket) GetItem(key any) (any, error) {
+	return fmt.Sprintf("item %v", key), nil
+}
+
+func (b basket) Call(args []any, kwargs map[string]any) (any, error) {
+	return fmt.Sprintf("called with %d arguments", len(args)), nil
+}
+
+func (b basket) Bool() (bool, error) {
+	return false, nil
+}
+
+func TestOperatorProtocol(t *testing.T) {
+	vars := map[string]any{
+		"a":      Money{150, "EUR"},
+		"b":      Money{275, "EUR"},
+		"usd":    Money{100, "USD"},
+		"zero":   Money{0, "EUR"},
+		"d":      NewDecimal("1.25"),
+		"basket": basket{[]string{"apple", "pear"}},
+	}
+	runRenderCases(t, newTestEnv(t, nil), []renderCase{
+		{"{{ a + b }}|{{ a * 3 }}|{{ 2 * b }}", vars, "4.25 EUR|4.50 EUR|5.50 EUR"},
+		{"{{ a == a }}{{ a == b }}{{ a != b }}{{ a < b }}{{ b > a }}{{ 1 == a }}", vars, "TrueFalseTrueTrueTrueFalse"},
+		{"{{ a < b < a + b }}{% if zero %}x{% endif %}{{ not zero }}", vars, "TrueTrue"},
+		{"{{ d + 1 }}|{{ 1 + d }}|{{ d - 2 }}|{{ 2 - d }}|{{ d / 2 }}|{{ 5 / d }}|{{ -d }}", vars, "2.25|2.25|-0.75|0.75|0.63|4.00|-1.25"},
+		{"{{ d == 1.25 }}{{ d > 1 }}{{ 2 > d }}{{ d is lt 2 }}{{ d is eq d }}", vars, "FalseTrueTrueTrueTrue"},
+		{"{{ 'APPLE' in basket }}{{ 'plum' not in basket }}{{ basket[3] }}{{ basket(1, 2) }}", vars, "TrueTrueitem 3called with 2 arguments"},
+		{"{% for item in basket %}{{ item }},{% endfor %}{% if not basket %}empty{% endif %}", vars, "APPLE,PEAR,empty"},
+		{"{{ basket is sequence }}{{ basket is iterable }}{{ basket is callable }}{{ 3 is in [1, 3] }}", vars, "TrueTrueTrueTrue"},
+	})
+
+	for source, expected := range map[string]string{
+		"{{ a + usd }}": "can't add USD to EUR",
+		"{{ a + 1 }}":   "unsupported operand type(s) for +: 'Money' and 'int'",
+		"{{ a < usd }}": "'<' not supported between instances of 'Money' and 'Money'",
+		"{{ d / 0 }}":   "decimal division by zero",
+	} {
+		tmpl, err := newTestEnv(t, nil).FromString(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.Render(vars); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, got %v", source, expected, err)
+		}
+	}
+}
+
+// conformanceCase is a template with its output rendered by Jinja, see
+// testdata/conformance.py.
+type conformanceCase struct {
+	Template string
+	Vars     map[string]any
+	Output   string
+	Error    string
+}
+
+// jsonNumbers converts the numbers decoded from JSON to int64 and float64.
+func jsonNumbers(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case []any:
+		for i, item := range val {
+			val[i] = jsonNumbers(item)
+		}
+	case map[string]any:
+		for k, item := range val {
+			val[k] = jsonNumbers(item)
+		}
+	}
+	return v
+}
+
+// conformanceErrors maps the python exception classes recorded in the
+// conformance table to the matching error kinds.
+var conformanceErrors = map[string]func(err error) bool{
+	"TypeError": func(err error) bool {
+		var typeErr *errors.TypeError
+		return stdErrors.As(err, &typeErr)
+	},
+	"ZeroDivisionError": func(err error) bool {
+		var zeroDivErr *errors.ZeroDivisionError
+		return stdErrors.As(err, &zeroDivErr)
+	},
+}
+
+func TestConformance(t *testing.T) {
+	f, err := os.Open("testdata/conformance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var table struct {
+		Cases []conformanceCase
+	}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&table); err != nil {
+		t.Fatal(err)
+	}
+
+	env := newTestEnv(t, nil)
+	for _, c := range table.Cases {
+		tmpl, err := env.FromString(c.Template, nil)
+		if err != nil {
+			t.Errorf("%s: %v", c.Template, err)
+			continue
+		}
+		vars := jsonNumbers(c.Vars).(map[string]any)
+		out, err := tmpl.Render(vars)
+		switch {
+		case c.Error != "" && err == nil:
+			t.Errorf("%s %v: expected %s, got %q", c.Template, c.Vars, c.Error, out)
+		case c.Error != "" && conformanceErrors[c.Error] == nil:
+			t.Errorf("%s %v: unknown error class %s", c.Template, c.Vars, c.Error)
+		case c.Error != "" && !conformanceErrors[c.Error](err):
+			t.Errorf("%s %v: expected %s, got %v", c.Template, c.Vars, c.Error, err)
+		case c.Error == "" && err != nil:
+			t.Errorf("%s %v: unexpected error %v", c.Template, c.Vars, err)
+		case c.Error == "" && out != c.Output:
+			t.Errorf("%s %v: expected %q, got %q", c.Template, c.Vars, c.Output, out)
+		}
+	}
+}
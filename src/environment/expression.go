@@ -0,0 +1,72 @@
+package environment
+
+import (
+	"context"
+	"io"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/parser"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+// Expression is a compiled standalone expression returned by `CompileExpression`.
+type Expression struct {
+	template        *Template
+	undefinedToNone bool
+}
+
+// CompileExpression compiles an expression that can be evaluated against a
+// map of variables, useful if applications want to use the same rules as
+// Jinja in template "configuration files" or similar situations.
+//
+// If `undefinedToNone` is set, undefined results are converted to nil.
+func (env *Environment) CompileExpression(source string, undefinedToNone bool) (Expression, error) {
+	state := "variable"
+	stream, err := env.tokenize(source, nil, nil, &state)
+	if err != nil {
+		return Expression{}, err
+	}
+	p := parser.NewParser(stream, env.iterExtensions(), nil, nil, &state)
+	expr, err := p.ParseExpression(true)
+	if err != nil {
+		return Expression{}, err
+	}
+	if !p.Stream().Eos() {
+		return Expression{}, errors.NewTemplateSyntaxError("chunk after expression", p.Stream().Current().Lineno, nil, nil)
+	}
+
+	ast := &nodes.Template{
+		Body: []nodes.Node{&nodes.Assign{
+			Target:     &nodes.Name{Name: "result", Ctx: "store", ExprCommon: nodes.ExprCommon{Lineno: 1}},
+			Node:       expr,
+			StmtCommon: nodes.StmtCommon{Lineno: 1},
+		}},
+		NodeCommon: nodes.NodeCommon{Lineno: 1},
+	}
+	tmpl, err := newTemplate(env, ast, nil, nil, env.MakeGlobals(nil), nil)
+	if err != nil {
+		return Expression{}, err
+	}
+	return Expression{template: tmpl, undefinedToNone: undefinedToNone}, nil
+}
+
+// Eval evaluates the expression with the given variables.
+func (e Expression) Eval(vars map[string]any) (any, error) {
+	return e.EvalContext(context.Background(), vars)
+}
+
+// EvalContext evaluates the expression like `Eval`, but aborts the evaluation
+// with a `CanceledError` once ctx is canceled or its deadline passed.
+func (e Expression) EvalContext(ctx context.Context, vars map[string]any) (any, error) {
+	tmplCtx := e.template.NewContext(vars)
+	tmplCtx.State = e.template.env.newRenderState(ctx)
+	if err := newRenderer(e.template, tmplCtx).renderRoot(io.Discard); err != nil {
+		return nil, err
+	}
+	rv := tmplCtx.Vars["result"]
+	if _, ok := rv.(runtime.IUndefined); ok && e.undefinedToNone {
+		return nil, nil
+	}
+	return rv, nil
+}
@@ -0,0 +1,89 @@
+package environment
+
+import (
+	"context"
+	stdErrors "errors"
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+func TestCompileExpression(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	expr, err := env.CompileExpression("foo == 42 and bar is odd", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := expr.Eval(map[string]any{"foo": 42, "bar": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != true {
+		t.Fatalf("expected true, got %v", res)
+	}
+	res, err = expr.Eval(map[string]any{"foo": 23, "bar": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != false {
+		t.Fatalf("expected false, got %v", res)
+	}
+
+	expr, err = env.CompileExpression("missing", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err = expr.Eval(nil); err != nil || res != nil {
+		t.Fatalf("expected nil, got %v (%v)", res, err)
+	}
+
+	expr, err = env.CompileExpression("missing", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err = expr.Eval(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.(runtime.IUndefined); !ok {
+		t.Fatalf("expected undefined, got %v", res)
+	}
+
+	if _, err = env.CompileExpression("1 2", true); err == nil || !strings.Contains(err.Error(), "chunk after expression") {
+		t.Fatalf("expected syntax error, got %v", err)
+	}
+}
+
+func TestExpressionLimits(t *testing.T) {
+	env := newLimitedEnv(t, Limits{MaxSteps: 50, MaxRange: 10}, nil)
+	for source, limit := range map[string]string{
+		"range(11)":                       "range",
+		strings.Repeat("1 + ", 100) + "1": "steps",
+	} {
+		expr, err := env.CompileExpression(source, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = expr.Eval(nil)
+		var limitErr *errors.LimitExceededError
+		if !stdErrors.As(err, &limitErr) || limitErr.Limit != limit {
+			t.Errorf("%q: expected %s limit error, got %v", source, limit, err)
+		}
+	}
+
+	expr, err := env.CompileExpression("range(10)[-1]", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := expr.Eval(nil); err != nil || res != int64(9) {
+		t.Fatalf("expected 9, got %v (%v)", res, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var canceledErr *errors.CanceledError
+	if _, err := expr.EvalContext(ctx, nil); !stdErrors.As(err, &canceledErr) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package environment
+
+import (
+	"context"
+	"io"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+// DefaultMaxRecursionDepth is the recursion depth limit used if
+// `Limits.MaxRecursionDepth` is 0. Go can't recover from stack overflows, so
+// deeply recursive templates have to fail before.
+const DefaultMaxRecursionDepth = 1000
+
+// Limits are the resource limits of every render of an environment. Limits
+// of 0 are unlimited, except for the recursion depth. Exceeding a limit fails the render with a
+// `LimitExceededError`.
+type Limits struct {
+	// MaxSteps is the maximum number of evaluated statements, expressions and
+	// loop iterations.
+	MaxSteps int
+	// MaxOutputBytes is the maximum size of the rendered output. Captured
+	// output, like `set` blocks, macro bodies and `super()`, and strings
+	// built by concatenation and repetition are limited to it as well.
+	MaxOutputBytes int
+	// MaxRecursionDepth is the maximum nesting of macro calls, includes and
+	// recursive loops, `DefaultMaxRecursionDepth` if 0. Negative values
+	// disable the limit outside of sandboxed environments, deep recursion
+	// then crashes the process.
+	MaxRecursionDepth int
+	// MaxRange is the maximum size of `range`, `runtime.MaxRange` if 0.
+	MaxRange int
+}
+
+// newRenderState creates the state tracking the limits of a single render.
+func (env *Environment) newRenderState(ctx context.Context) *runtime.RenderState {
+	maxDepth := env.Limits.MaxRecursionDepth
+	if maxDepth == 0 || (maxDepth < 0 && env.Sandboxed) {
+		maxDepth = DefaultMaxRecursionDepth
+	}
+	return &runtime.RenderState{
+		Ctx:               ctx,
+		MaxSteps:          env.Limits.MaxSteps,
+		MaxRecursionDepth: maxDepth,
+		MaxOutputBytes:    env.Limits.MaxOutputBytes,
+		MaxRange:          env.Limits.MaxRange,
+	}
+}
+
+// limitOutput limits the bytes written to w to the maximum output size of the
+// render.
+func limitOutput(w io.Writer, state *runtime.RenderState) io.Writer {
+	if state == nil || state.MaxOutputBytes <= 0 {
+		return w
+	}
+	return &limitWriter{w: w, max: state.MaxOutputBytes}
+}
+
+// checkStringSize fails if the value is a string longer than the maximum
+// output size of the render.
+func checkStringSize(state *runtime.RenderState, v any) error {
+	switch s := v.(type) {
+	case string:
+		return state.CheckOutput(len(s))
+	case runtime.Markup:
+		return state.CheckOutput(len(s))
+	}
+	return nil
+}
+
+// checkRepeatSize fails if the string repeated n times would be longer than
+// the maximum output size of the render, before the string is built.
+func checkRepeatSize(state *runtime.RenderState, seq any, n any) error {
+	if state == nil || state.MaxOutputBytes <= 0 {
+		return nil
+	}
+	var length int
+	switch s := seq.(type) {
+	case string:
+		length = len(s)
+	case runtime.Markup:
+		length = len(s)
+	default:
+		return nil
+	}
+	times, ok := runtime.ToInt(n)
+	if !ok || length == 0 || times <= int64(state.MaxOutputBytes/length) {
+		return nil
+	}
+	return errors.NewLimitExceeded("output bytes", state.MaxOutputBytes, "")
+}
+
+// limitWriter fails writes exceeding the maximum number of bytes.
+type limitWriter struct {
+	w       io.Writer
+	written int
+	max     int
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.written+len(p) > lw.max {
+		return 0, errors.NewLimitExceeded("output bytes", lw.max, "")
+	}
+	n, err := lw.w.Write(p)
+	lw.written += n
+	return n, err
+}
+
+// enter enters a level of recursion, leave must be called afterwards if it
+// succeeds.
+func (r *renderer) enter() error {
+	return r.ctx.State.Enter()
+}
+
+func (r *renderer) leave() {
+	r.ctx.State.Leave()
+}
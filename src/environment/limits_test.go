@@ -0,0 +1,140 @@
+package environment
+
+import (
+	"context"
+	stdErrors "errors"
+	"testing"
+	"time"
+
+	"github.com/gojinja/gojinja/src/errors"
+)
+
+func newLimitedEnv(t *testing.T, limits Limits, templates map[string]string) *Environment {
+	opts := DefaultEnvOpts()
+	opts.Limits = limits
+	if templates != nil {
+		dir := t.TempDir()
+		writeTemplates(t, dir, templates)
+		opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	}
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func expectLimitExceeded(t *testing.T, env *Environment, source string, limit string) {
+	t.Helper()
+	tmpl, err := env.FromString(source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(nil)
+	var limitErr *errors.LimitExceededError
+	if !stdErrors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Fatalf("%q: expected %s limit error, got %v", source, limit, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	env := newLimitedEnv(t, Limits{MaxSteps: 100}, nil)
+	runRenderCases(t, env, []renderCase{
+		{"{% for i in range(10) %}{{ i }}{% endfor %}", nil, "0123456789"},
+	})
+	expectLimitExceeded(t, env, "{% for i in range(1000) %}{{ i }}{% endfor %}", "steps")
+	expectLimitExceeded(t, env, "{% for i in range(1000) %}{% endfor %}", "steps")
+
+	env = newLimitedEnv(t, Limits{MaxOutputBytes: 10}, nil)
+	runRenderCases(t, env, []renderCase{
+		{"{{ 'xxxxxxxxxx' }}", nil, "xxxxxxxxxx"},
+	})
+	expectLimitExceeded(t, env, "{% for i in range(20) %}{{ i }}{% endfor %}", "output bytes")
+
+	// output that is captured instead of written is limited as well
+	env = newLimitedEnv(t, Limits{MaxOutputBytes: 10}, map[string]string{
+		"base.html":  "{% block b %}{% for i in range(20) %}{{ i }}{% endfor %}{% endblock %}",
+		"child.html": `{% extends "base.html" %}{% block b %}{% set s = super() %}{% endblock %}`,
+	})
+	runRenderCases(t, env, []renderCase{
+		{"{% set x %}xxxxx{% endset %}{{ x ~ x }}{{ 'y' * 0 }}", nil, "xxxxxxxxxx"},
+	})
+	for _, source := range []string{
+		"{% set x %}{% for i in range(20) %}{{ i }}{% endfor %}{% endset %}",
+		"{% macro m() %}{% for i in range(20) %}{{ i }}{% endfor %}{% endmacro %}{% set x = m() %}",
+		"{% macro m() %}{{ caller() }}{% endmacro %}{% set x %}{% call m() %}{% for i in range(20) %}{{ i }}{% endfor %}{% endcall %}{% endset %}",
+		"{% for x in [[1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1]] recursive %}{% if x is iterable %}{% set s = loop(x) %}{% else %}{{ x }}{% endif %}{% endfor %}",
+		"{% set x = 'x' * 1000000000000 %}",
+		"{% set x = 1000000000000 * 'x' %}",
+		"{% set x = 'xxxxxx' ~ 'xxxxxx' %}",
+		"{% set x = 'xxxxxx' + 'xxxxxx' %}",
+		`{% include "child.html" %}`,
+	} {
+		expectLimitExceeded(t, env, source, "output bytes")
+	}
+
+	env = newLimitedEnv(t, Limits{MaxRange: 10}, nil)
+	runRenderCases(t, env, []renderCase{
+		{"{{ range(10)[-1] }}", nil, "9"},
+	})
+	expectLimitExceeded(t, env, "{{ range(11) }}", "range")
+	expectLimitExceeded(t, newTestEnv(t, nil), "{{ range(100001) }}", "range")
+
+	env = newLimitedEnv(t, Limits{MaxRecursionDepth: 5}, map[string]string{"self.html": `{% include "self.html" %}`})
+	runRenderCases(t, env, []renderCase{
+		{"{% macro m(n) %}{% if n %}{{ n }}{{ m(n - 1) }}{% endif %}{% endmacro %}{{ m(4) }}", nil, "4321"},
+	})
+	expectLimitExceeded(t, env, "{% macro m(n) %}{{ m(n + 1) }}{% endmacro %}{{ m(0) }}", "recursion depth")
+	expectLimitExceeded(t, env, `{% include "self.html" %}`, "recursion depth")
+	expectLimitExceeded(t, env, "{% for x in [[[[[[[1]]]]]]] recursive %}{{ loop(x) if x is iterable }}{% endfor %}", "recursion depth")
+
+	// the default options guard against infinite recursion
+	expectLimitExceeded(t, newTestEnv(t, nil), "{% macro m() %}{{ m() }}{% endmacro %}{{ m() }}", "recursion depth")
+	// and so do limits leaving the recursion depth unset
+	expectLimitExceeded(t, newLimitedEnv(t, Limits{MaxSteps: 1e6}, nil), "{% macro m() %}{{ m() }}{% endmacro %}{{ m() }}", "recursion depth")
+	deep := "{% macro m(n) %}{% if n %}{{ m(n - 1) }}{% endif %}{% endmacro %}{{ m(1500) }}done"
+	expectLimitExceeded(t, newLimitedEnv(t, Limits{MaxSteps: 1e6}, nil), deep, "recursion depth")
+	runRenderCases(t, newLimitedEnv(t, Limits{MaxRecursionDepth: -1}, nil), []renderCase{{deep, nil, "done"}})
+	opts := DefaultEnvOpts()
+	opts.Limits = Limits{MaxRecursionDepth: -1}
+	sandboxed, err := NewSandboxed(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLimitExceeded(t, sandboxed, deep, "recursion depth")
+}
+
+func TestRenderContext(t *testing.T) {
+	env := newTestEnv(t, nil)
+	tmpl, err := env.FromString("{% for x in forever %}{{ x }}{% endfor %}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forever := func(yield func(int) bool) {
+		for yield(1) {
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = tmpl.RenderContext(ctx, map[string]any{"forever": forever})
+	var canceledErr *errors.CanceledError
+	if !stdErrors.As(err, &canceledErr) || !stdErrors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("render wasn't aborted promptly, took %v", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := tmpl.RenderContext(ctx, map[string]any{"forever": []int{1}}); !stdErrors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+
+	res, err := tmpl.RenderContext(context.Background(), map[string]any{"forever": []int{1, 2}})
+	if err != nil || res != "12" {
+		t.Fatalf("unexpected output %q, %v", res, err)
+	}
+}
@@ -15,7 +15,7 @@ import (
 func splitTemplatePath(template string) (pieces []string, err error) {
 	for _, piece := range strings.Split(template, "/") {
 		if strings.Contains(piece, string(os.PathSeparator)) || piece == ".." {
-			return nil, errors.TemplateNotFound(template, "")
+			return nil, errors.NewTemplateNotFound(template, "")
 		} else if piece != "." {
 			pieces = append(pieces, piece)
 		}
@@ -38,7 +38,7 @@ func (l *Loader) Load(env *Environment, name string, globals map[string]any) (IT
 	if err != nil {
 		return nil, err
 	}
-	return env.TemplateClass.FromSource(env, source, filename, globals, upToDate)
+	return env.TemplateClass.FromSource(env, source, &name, filename, globals, upToDate)
 }
 
 type fsLoader struct {
@@ -65,7 +65,7 @@ func (f fsLoader) GetSource(_ *Environment, template string) (string, *string, U
 		}
 		info, err = os.Stat(searchPath)
 		if err != nil {
-			return "", nil, nil, err
+			continue
 		}
 		if info.Mode().IsRegular() {
 			filename = path.Clean(searchPath)
@@ -74,7 +74,7 @@ func (f fsLoader) GetSource(_ *Environment, template string) (string, *string, U
 	}
 
 	if filename == "" {
-		return "", nil, nil, errors.TemplateNotFound(template, "")
+		return "", nil, nil, errors.NewTemplateNotFound(template, "")
 	}
 
 	mtime := info.ModTime()
@@ -89,7 +89,7 @@ func (f fsLoader) GetSource(_ *Environment, template string) (string, *string, U
 	}
 
 	upToDate := func() bool {
-		info, err = os.Stat(filename)
+		info, err := os.Stat(filename)
 		if err != nil {
 			return false
 		}
@@ -0,0 +1,129 @@
+package environment
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/runtime"
+	"github.com/gojinja/gojinja/src/utils"
+)
+
+// macroSpecialNames are the variables with a special meaning in macro bodies.
+var macroSpecialNames = []string{"caller", "kwargs", "varargs"}
+
+// findSpecialNames returns which of the special macro variables the body
+// loads. Nested blocks and macros have their own scope and are skipped.
+func findSpecialNames(body []nodes.Node) map[string]bool {
+	found := make(map[string]bool)
+	var visit func(node nodes.Node) bool
+	visit = func(node nodes.Node) bool {
+		switch n := node.(type) {
+		case *nodes.Name:
+			if n.Ctx == "load" {
+				for _, name := range macroSpecialNames {
+					if n.Name == name {
+						found[name] = true
+					}
+				}
+			}
+		case *nodes.Block, *nodes.Macro:
+			return false
+		case *nodes.CallBlock:
+			nodes.Walk(&n.Call, visit)
+			for _, d := range n.Defaults {
+				nodes.Walk(d, visit)
+			}
+			return false
+		}
+		return true
+	}
+	for _, node := range body {
+		nodes.Walk(node, visit)
+	}
+	return found
+}
+
+// newMacro creates the macro for the signature and body, it's rendered in a
+// new scope inside of the frame it's defined in.
+func (r *renderer) newMacro(f *frame, name string, sig *nodes.MacroCall, body []nodes.Node, lineno int) *runtime.Macro {
+	special := findSpecialNames(body)
+	m := &runtime.Macro{
+		Name:         name,
+		Arguments:    make([]string, len(sig.Args)),
+		CatchKwargs:  special["kwargs"],
+		CatchVarargs: special["varargs"],
+		Lineno:       lineno,
+	}
+	explicitCaller := false
+	for i, arg := range sig.Args {
+		m.Arguments[i] = arg.Name
+		explicitCaller = explicitCaller || arg.Name == "caller"
+	}
+	m.Caller = special["caller"] && !explicitCaller
+	firstDefault := len(sig.Args) - len(sig.Defaults)
+
+	m.Invoke = func(arguments []any, specialValues map[string]any) (any, error) {
+		if err := r.enter(); err != nil {
+			return nil, err
+		}
+		defer r.leave()
+		inner := newFrame(f)
+		for i, arg := range sig.Args {
+			value := arguments[i]
+			if _, ok := value.(utils.Missing); ok {
+				if i >= firstDefault {
+					def := sig.Defaults[i-firstDefault]
+					var err error
+					if value, err = r.eval(def, inner); err != nil {
+						return nil, r.wrapError(err, def.GetLineno())
+					}
+				} else {
+					hint := fmt.Sprintf("parameter '%s' was not provided", arg.Name)
+					value = r.lookupUndefined(&hint, nil, arg.Name)
+				}
+			}
+			inner.vars[arg.Name] = value
+		}
+		for k, v := range specialValues {
+			if _, ok := v.(utils.Missing); ok && k == "caller" {
+				hint := "No caller defined"
+				v = r.undefined(&hint, nil, k)
+			}
+			inner.vars[k] = v
+		}
+		return r.renderCaptured(body, inner)
+	}
+	return m
+}
+
+func (r *renderer) renderMacro(n *nodes.Macro, f *frame) error {
+	r.setVar(f, n.Name, r.newMacro(f, n.Name, &n.MacroCall, n.Body, n.Lineno))
+	return nil
+}
+
+// renderCallBlock calls the macro with the body of the block passed as the
+// `caller` keyword argument.
+func (r *renderer) renderCallBlock(n *nodes.CallBlock, f *frame, w io.Writer) error {
+	caller := r.newMacro(f, "caller", &n.MacroCall, n.Body, n.Lineno)
+	fn, err := r.eval(n.Call.Node, f)
+	if err != nil {
+		return err
+	}
+	if err := r.checkCallable(fn); err != nil {
+		return err
+	}
+	args, kwargs, err := r.evalArgs(n.Call.Args, n.Call.Kwargs, n.Call.DynArgs, n.Call.DynKwargs, f)
+	if err != nil {
+		return err
+	}
+	if kwargs == nil {
+		kwargs = make(map[string]any)
+	}
+	kwargs["caller"] = caller
+	value, err := r.ctx.Call(fn, args, kwargs)
+	if err != nil {
+		return err
+	}
+	return r.write(w, value)
+}
@@ -0,0 +1,608 @@
+package environment
+
+import (
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/runtime"
+	"github.com/gojinja/gojinja/src/utils"
+)
+
+// frame holds the variables of a single scope of the template.
+type frame struct {
+	parent   *frame
+	vars     map[string]any
+	toplevel bool
+}
+
+func newFrame(parent *frame) *frame {
+	return &frame{parent: parent, vars: make(map[string]any)}
+}
+
+// errBreak and errContinue unwind the rendering of a loop body up to the
+// innermost for loop.
+var (
+	errBreak    = stdErrors.New("break outside of a loop")
+	errContinue = stdErrors.New("continue outside of a loop")
+)
+
+// renderer renders the nodes of a single template with the given context.
+type renderer struct {
+	env      *Environment
+	tmpl     *Template
+	ctx      *runtime.Context
+	parent   *Template
+	extended bool
+	// lineno is the line of the node being rendered or evaluated.
+	lineno int
+	// guarded counts the nested operands of tests and filters handling
+	// undefined values, lookups in them aren't reported.
+	guarded int
+}
+
+func newRenderer(t *Template, ctx *runtime.Context) *renderer {
+	return &renderer{env: t.env, tmpl: t, ctx: ctx}
+}
+
+// rootWriter discards everything written after the template extended another
+// template, as only the parent template renders output then.
+type rootWriter struct {
+	w io.Writer
+	r *renderer
+}
+
+func (rw rootWriter) Write(p []byte) (int, error) {
+	if rw.r.extended {
+		return len(p), nil
+	}
+	return rw.w.Write(p)
+}
+
+func (r *renderer) renderRoot(w io.Writer) error {
+	f := newFrame(nil)
+	f.toplevel = true
+	if err := r.renderNodes(r.tmpl.ast.Body, f, rootWriter{w, r}); err != nil {
+		return err
+	}
+	if r.parent != nil {
+		return newRenderer(r.parent, r.ctx).renderRoot(w)
+	}
+	return nil
+}
+
+func (r *renderer) wrapError(err error, lineno int) error {
+	var renderErr *errors.RenderError
+	var syntaxErr *errors.TemplateSyntaxError
+	if err == nil || err == errBreak || err == errContinue || stdErrors.As(err, &renderErr) || stdErrors.As(err, &syntaxErr) {
+		return err
+	}
+	return &errors.RenderError{Err: err, Lineno: lineno, Name: r.tmpl.name, Filename: r.tmpl.filename}
+}
+
+func (r *renderer) undefined(hint *string, obj any, name any) runtime.IUndefined {
+	return r.newUndefined(hint, obj, name, nil)
+}
+
+// lookupUndefined returns the undefined value of a missing variable,
+// attribute or item and reports it if the render is audited.
+func (r *renderer) lookupUndefined(hint *string, obj any, name any) runtime.IUndefined {
+	r.recordUndefined(hint, obj, name)
+	return r.undefined(hint, obj, name)
+}
+
+// recordUndefined reports the lookup of an undefined value if the render is
+// audited. Every undefined lookup is reported through it, also the ones of
+// undefined values returned by `GetAttr` and `GetItem` methods.
+func (r *renderer) recordUndefined(hint *string, obj any, name any) {
+	if s := r.ctx.State; s != nil && s.Undefined != nil && r.guarded == 0 {
+		s.Undefined.Record(r.templateName(), r.lineno, hint, obj, name)
+	}
+}
+
+// checkUndefined reports the value returned by the `GetAttr` or `GetItem`
+// method of obj if it's undefined. Lookups on undefined values aren't
+// reported again.
+func (r *renderer) checkUndefined(obj any, name any, value any) any {
+	if _, ok := value.(runtime.IUndefined); ok {
+		if _, ok := obj.(runtime.IUndefined); !ok {
+			r.recordUndefined(nil, obj, name)
+		}
+	}
+	return value
+}
+
+func (r *renderer) templateName() string {
+	if r.tmpl.name == nil {
+		return ""
+	}
+	return *r.tmpl.name
+}
+
+// newUndefined creates an undefined value with the constructor of the
+// environment and tells it where in the template it was created.
+func (r *renderer) newUndefined(hint *string, obj any, name any, exc func(msg string) error) runtime.IUndefined {
+	newUndefined := r.env.Undefined
+	if newUndefined == nil {
+		newUndefined = runtime.NewUndefined
+	}
+	u := newUndefined(hint, obj, name, exc)
+	if located, ok := u.(runtime.LocatedUndefined); ok {
+		u = located.WithLocation(r.templateName(), r.lineno)
+	}
+	return u
+}
+
+func (r *renderer) resolve(f *frame, name string) any {
+	for ; f != nil; f = f.parent {
+		if v, ok := f.vars[name]; ok {
+			return v
+		}
+	}
+	v := r.ctx.ResolveOrMissing(name)
+	if _, ok := v.(utils.Missing); ok {
+		return r.lookupUndefined(nil, utils.GetMissing(), name)
+	}
+	return v
+}
+
+// locals returns all variables visible in the frame.
+func (f *frame) locals() map[string]any {
+	res := make(map[string]any)
+	for ; f != nil; f = f.parent {
+		for k, v := range f.vars {
+			if _, ok := res[k]; !ok {
+				res[k] = v
+			}
+		}
+	}
+	return res
+}
+
+func (r *renderer) setVar(f *frame, name string, value any) {
+	f.vars[name] = value
+	if f.toplevel {
+		r.ctx.Vars[name] = value
+		if !strings.HasPrefix(name, "_") {
+			r.ctx.ExportedVars.Add(name)
+		}
+	}
+}
+
+func (r *renderer) assign(target nodes.Expr, value any, f *frame) error {
+	switch t := target.(type) {
+	case *nodes.Name:
+		r.setVar(f, t.Name, value)
+		return nil
+	case *nodes.InternalName:
+		f.vars[t.Name] = value
+		return nil
+	case *nodes.Tuple:
+		items, err := runtime.Iterate(value)
+		if err != nil {
+			return err
+		}
+		if len(items) != len(t.Items) {
+			if len(items) > len(t.Items) {
+				return errors.NewTemplateRuntimeError(fmt.Sprintf("too many values to unpack (expected %d)", len(t.Items)))
+			}
+			return errors.NewTemplateRuntimeError(fmt.Sprintf("not enough values to unpack (expected %d, got %d)", len(t.Items), len(items)))
+		}
+		for i, item := range t.Items {
+			if err := r.assign(item, items[i], f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *nodes.NSRef:
+		ns, ok := r.resolve(f, t.Name).(*runtime.Namespace)
+		if !ok {
+			return errors.NewTemplateRuntimeError("cannot assign attribute on non-namespace object")
+		}
+		ns.Set(t.Attr, value)
+		return nil
+	}
+	return fmt.Errorf("can't assign to %T", target)
+}
+
+func (r *renderer) renderNodes(body []nodes.Node, f *frame, w io.Writer) error {
+	for _, n := range body {
+		if err := r.renderNode(n, f, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *renderer) renderNode(node nodes.Node, f *frame, w io.Writer) error {
+	if err := r.ctx.State.Step(); err != nil {
+		return r.wrapError(err, node.GetLineno())
+	}
+	r.lineno = node.GetLineno()
+	return r.wrapError(r.renderStmt(node, f, w), node.GetLineno())
+}
+
+func (r *renderer) renderStmt(node nodes.Node, f *frame, w io.Writer) error {
+	switch n := node.(type) {
+	case *nodes.Output:
+		return r.renderOutput(n, f, w)
+	case *nodes.If:
+		return r.renderIf(n, f, w)
+	case *nodes.For:
+		return r.renderFor(n, f, w)
+	case *nodes.Assign:
+		value, err := r.eval(n.Node.(nodes.Expr), f)
+		if err != nil {
+			return err
+		}
+		return r.assign(n.Target, value, f)
+	case *nodes.AssignBlock:
+		value, err := r.renderCaptured(n.Body, newFrame(f))
+		if err != nil {
+			return err
+		}
+		if n.Filter != nil {
+			value, err = r.evalFilter(n.Filter, f, value)
+			if err != nil {
+				return err
+			}
+		}
+		return r.assign(n.Target, value, f)
+	case *nodes.FilterBlock:
+		value, err := r.renderCaptured(n.Body, newFrame(f))
+		if err != nil {
+			return err
+		}
+		value, err = r.evalFilter(n.Filter, f, value)
+		if err != nil {
+			return err
+		}
+		return r.write(w, value)
+	case *nodes.With:
+		inner := newFrame(f)
+		for i, target := range n.Targets {
+			value, err := r.eval(n.Values[i], f)
+			if err != nil {
+				return err
+			}
+			if err := r.assign(target, value, inner); err != nil {
+				return err
+			}
+		}
+		return r.renderNodes(n.Body, inner, w)
+	case *nodes.Scope:
+		return r.renderNodes(n.Body, newFrame(f), w)
+	case *nodes.ScopedEvalContextModifier:
+		old := *r.ctx.EvalCtx
+		defer func() { *r.ctx.EvalCtx = old }()
+		if err := r.modifyEvalContext(n.Options, f); err != nil {
+			return err
+		}
+		return r.renderNodes(n.Body, f, w)
+	case *nodes.EvalContextModifier:
+		return r.modifyEvalContext(n.Options, f)
+	case *nodes.Block:
+		return r.renderBlockStmt(n, f, w)
+	case *nodes.Extends:
+		return r.renderExtends(n, f)
+	case *nodes.Include:
+		return r.renderInclude(n, f, w)
+	case *nodes.Macro:
+		return r.renderMacro(n, f)
+	case *nodes.CallBlock:
+		return r.renderCallBlock(n, f, w)
+	case *nodes.ExprStmt:
+		_, err := r.eval(n.Node, f)
+		return err
+	case *nodes.Break:
+		return errBreak
+	case *nodes.Continue:
+		return errContinue
+	}
+	return errors.NewTemplateRuntimeError(fmt.Sprintf("rendering of %s nodes is not supported", reflect.TypeOf(node).Elem().Name()))
+}
+
+// finalize applies the finalize function of the environment to the value of
+// a printed expression.
+func (r *renderer) finalize(value any) (any, error) {
+	var res any
+	switch {
+	case r.env.contextFinalize != nil:
+		res = r.env.contextFinalize(r.ctx, value)
+	case r.env.Finalize != nil:
+		res = r.env.Finalize(value)
+	default:
+		return value, nil
+	}
+	// Like filters, finalize functions fail by returning an error.
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// write converts the value to a string, escaping it if autoescaping is enabled, and writes it.
+func (r *renderer) write(w io.Writer, value any) error {
+	var s string
+	var err error
+	if r.ctx.EvalCtx.AutoEscape {
+		var m runtime.Markup
+		m, err = runtime.Escape(value)
+		s = string(m)
+	} else {
+		s, err = runtime.ToString(value)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s)
+	return err
+}
+
+func (r *renderer) renderOutput(n *nodes.Output, f *frame, w io.Writer) error {
+	for _, child := range n.Nodes {
+		if data, ok := child.(*nodes.TemplateData); ok {
+			if _, err := io.WriteString(w, data.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := r.eval(child, f)
+		if err != nil {
+			return err
+		}
+		if value, err = r.finalize(value); err != nil {
+			return r.wrapError(err, child.GetLineno())
+		}
+		if err := r.wrapError(r.write(w, value), child.GetLineno()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderCaptured renders the body into a string, which is marked safe if autoescaping is enabled.
+func (r *renderer) renderCaptured(body []nodes.Node, f *frame) (any, error) {
+	var b strings.Builder
+	if err := r.renderNodes(body, f, limitOutput(&b, r.ctx.State)); err != nil {
+		return nil, err
+	}
+	if r.ctx.EvalCtx.AutoEscape {
+		return runtime.Markup(b.String()), nil
+	}
+	return b.String(), nil
+}
+
+func (r *renderer) modifyEvalContext(options []nodes.Keyword, f *frame) error {
+	for _, opt := range options {
+		value, err := r.eval(opt.Value, f)
+		if err != nil {
+			return err
+		}
+		switch opt.Key {
+		case "autoescape":
+			if r.ctx.EvalCtx.AutoEscape, err = runtime.Bool(value); err != nil {
+				return err
+			}
+		default:
+			return errors.NewTemplateRuntimeError(fmt.Sprintf("unknown eval context option %q", opt.Key))
+		}
+	}
+	return nil
+}
+
+func (r *renderer) renderIf(n *nodes.If, f *frame, w io.Writer) error {
+	test, err := r.evalBool(n.Test.(nodes.Expr), f)
+	if err != nil {
+		return err
+	}
+	if test {
+		return r.renderNodes(n.Body, f, w)
+	}
+	for i := range n.Elif {
+		elif := &n.Elif[i]
+		test, err := r.evalBool(elif.Test.(nodes.Expr), f)
+		if err != nil {
+			return r.wrapError(err, elif.GetLineno())
+		}
+		if test {
+			return r.renderNodes(elif.Body, f, w)
+		}
+	}
+	return r.renderNodes(n.Else, f, w)
+}
+
+func (r *renderer) renderFor(n *nodes.For, f *frame, w io.Writer) error {
+	iter, err := r.eval(n.Iter.(nodes.Expr), f)
+	if err != nil {
+		return err
+	}
+	return r.renderLoop(n, f, iter, 0, w)
+}
+
+// renderLoop renders the loop over the items of iterable. Recursive loops
+// call it again for the nested levels.
+func (r *renderer) renderLoop(n *nodes.For, f *frame, iterable any, depth0 int, w io.Writer) error {
+	it, err := runtime.Iter(iterable)
+	if err != nil {
+		return err
+	}
+	defer runtime.CloseIterator(it)
+	// the filter is applied before the loop context sees the items, so
+	// it only knows about the items that passed it.
+	if n.Test != nil {
+		it = runtime.FilterIterator(it, func(item any) (bool, error) {
+			inner := newFrame(f)
+			if err := r.assign(n.Target.(nodes.Expr), item, inner); err != nil {
+				return false, err
+			}
+			return r.evalBool((*n.Test).(nodes.Expr), inner)
+		})
+	}
+
+	loop := runtime.NewLoopContext(it, depth0, r.lookupUndefined)
+	if n.Recursive {
+		loop.Recurse = func(iterable any) (any, error) {
+			if err := r.enter(); err != nil {
+				return nil, err
+			}
+			defer r.leave()
+			var b strings.Builder
+			if err := r.renderLoop(n, f, iterable, depth0+1, limitOutput(&b, r.ctx.State)); err != nil {
+				return nil, err
+			}
+			if r.ctx.EvalCtx.AutoEscape {
+				return runtime.Markup(b.String()), nil
+			}
+			return b.String(), nil
+		}
+	}
+
+	iterated := false
+	for {
+		if err := r.ctx.State.Step(); err != nil {
+			return err
+		}
+		item, ok, err := loop.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		iterated = true
+		inner := newFrame(f)
+		inner.vars["loop"] = loop
+		if err := r.assign(n.Target.(nodes.Expr), item, inner); err != nil {
+			return err
+		}
+		err = r.renderNodes(n.Body, inner, w)
+		if err == errBreak {
+			break
+		}
+		if err != nil && err != errContinue {
+			return err
+		}
+	}
+	if !iterated {
+		return r.renderNodes(n.Else, newFrame(f), w)
+	}
+	return nil
+}
+
+func (r *renderer) renderBlockStmt(n *nodes.Block, f *frame, w io.Writer) error {
+	if f.toplevel && r.extended {
+		return nil
+	}
+	blocks := r.ctx.Blocks[n.Name]
+	if len(blocks) == 0 {
+		return errors.NewTemplateRuntimeError(fmt.Sprintf("block %q not found", n.Name))
+	}
+	ctx := r.ctx
+	if n.Scoped {
+		ctx = derivedContext(r.ctx, f.locals())
+	}
+	return blocks[0](ctx, w)
+}
+
+func (r *renderer) renderExtends(n *nodes.Extends, f *frame) error {
+	if r.parent != nil {
+		return errors.NewTemplateRuntimeError("extended multiple times")
+	}
+	name, err := r.eval(n.Template, f)
+	if err != nil {
+		return err
+	}
+	parent, err := r.env.GetTemplate(name, r.tmpl.name, nil)
+	if err != nil {
+		return err
+	}
+	tmpl, ok := parent.(*Template)
+	if !ok {
+		return errors.NewTemplateRuntimeError(fmt.Sprintf("cannot extend %T", parent))
+	}
+	for blockName, block := range tmpl.blocks {
+		r.ctx.Blocks[blockName] = append(r.ctx.Blocks[blockName], tmpl.blockFunc(block, len(r.ctx.Blocks[blockName])))
+	}
+	r.parent = tmpl
+	r.extended = true
+	return nil
+}
+
+// renderInclude renders the included template straight into w. With context
+// the template sees the variables of the including template, including the
+// local ones, otherwise only the globals.
+func (r *renderer) renderInclude(n *nodes.Include, f *frame, w io.Writer) error {
+	name, err := r.eval(n.Template, f)
+	if err != nil {
+		return err
+	}
+	included, err := r.env.GetOrSelectTemplate(name, r.tmpl.name, nil)
+	if err != nil {
+		var notFound *errors.TemplateNotFoundError
+		if n.IgnoreMissing && stdErrors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	tmpl, ok := included.(*Template)
+	if !ok {
+		return errors.NewTemplateRuntimeError(fmt.Sprintf("cannot include %T", included))
+	}
+	var ctx *runtime.Context
+	if n.WithContext {
+		ctx = tmpl.NewContext(derivedContext(r.ctx, f.locals()).Parent)
+	} else {
+		ctx = tmpl.NewContext(nil)
+	}
+	ctx.State = r.ctx.State
+	if err := r.enter(); err != nil {
+		return err
+	}
+	defer r.leave()
+	return newRenderer(tmpl, ctx).renderRoot(w)
+}
+
+// derivedContext returns a context sharing the blocks and the eval context of ctx
+// that additionally sees the given local variables.
+func derivedContext(ctx *runtime.Context, locals map[string]any) *runtime.Context {
+	parent := ctx.GetAll()
+	for k, v := range locals {
+		parent[k] = v
+	}
+	derived := runtime.NewContext(parent, ctx.Name, ctx.Blocks, ctx.EvalCtx)
+	derived.State = ctx.State
+	return derived
+}
+
+// blockFunc returns the function rendering the block, which is the index-th
+// entry in the list of blocks with its name.
+func (t *Template) blockFunc(block *nodes.Block, index int) runtime.BlockFunc {
+	return func(ctx *runtime.Context, w io.Writer) error {
+		r := newRenderer(t, ctx)
+		if block.Required {
+			return r.wrapError(errors.NewTemplateRuntimeError(fmt.Sprintf("Required block '%s' not found", block.Name)), block.Lineno)
+		}
+		f := newFrame(nil)
+		f.vars["super"] = func([]any, map[string]any) (any, error) {
+			blocks := ctx.Blocks[block.Name]
+			if index+1 >= len(blocks) {
+				hint := fmt.Sprintf("there is no parent block called '%s'.", block.Name)
+				return r.undefined(&hint, nil, block.Name), nil
+			}
+			var b strings.Builder
+			if err := blocks[index+1](ctx, limitOutput(&b, ctx.State)); err != nil {
+				return nil, err
+			}
+			if ctx.EvalCtx.AutoEscape {
+				return runtime.Markup(b.String()), nil
+			}
+			return b.String(), nil
+		}
+		return r.renderNodes(block.Body, f, w)
+	}
+}
@@ -0,0 +1,895 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+func newTestEnv(t *testing.T, templates map[string]string) *Environment {
+	opts := DefaultEnvOpts()
+	if templates != nil {
+		dir := t.TempDir()
+		writeTemplates(t, dir, templates)
+		opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	}
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+type renderCase struct {
+	source   string
+	vars     map[string]any
+	expected string
+}
+
+func runRenderCases(t *testing.T, env *Environment, cases []renderCase) {
+	for _, c := range cases {
+		tmpl, err := env.FromString(c.source, nil)
+		if err != nil {
+			t.Errorf("%q: %v", c.source, err)
+			continue
+		}
+		res, err := tmpl.Render(c.vars)
+		if err != nil {
+			t.Errorf("%q: %v", c.source, err)
+			continue
+		}
+		if res != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, res)
+		}
+	}
+}
+
+func TestFromString(t *testing.T) {
+	env := newTestEnv(t, nil)
+	runRenderCases(t, env, []renderCase{
+		{"Hello {{ name }}!", map[string]any{"name": "World"}, "Hello World!"},
+		{"{{ missing }}", nil, ""},
+		{"{{ 1 + 2 * 3 }} {{ 7 / 2 }} {{ 7 // 2 }} {{ 2 ** 10 }}", nil, "7 3.5 3 1024"},
+		{"{{ 9223372036854775808 }} {{ 9223372036854775808 - 1 }} {{ -9223372036854775808 }}", nil, "9223372036854775808 9223372036854775807 -9223372036854775808"},
+		{"{{ 'a' ~ 1 ~ none }}", nil, "a1None"},
+		{"{{ [1, 2, 3][1:] }} {{ {'a': 1}['a'] }} {{ 'abc'[-1] }}", nil, "[2, 3] 1 c"},
+		{"{{ true and 'yes' or 'no' }} {{ 1 < 2 < 3 }} {{ 2 in [1, 2] }} {{ 3 not in [1, 2] }}", nil, "yes True True True"},
+		{"{{ 'a' if false }}|{{ 'a' if false else 'b' }}", nil, "|b"},
+		{"{% for x in items if x is odd %}{{ x }}{% else %}none{% endfor %}", map[string]any{"items": []int{1, 2, 3}}, "13"},
+		{"{% for x in [] %}{{ x }}{% else %}none{% endfor %}", nil, "none"},
+		{"{% for k, v in [('a', 1), ('b', 2)] %}{{ k }}={{ v }};{% endfor %}", nil, "a=1;b=2;"},
+		{"{% for k in {'b': 1, 'a': 2} %}{{ k }}{% endfor %}", nil, "ab"},
+		{"{% if x %}x{% elif y %}y{% else %}z{% endif %}", map[string]any{"y": 1}, "y"},
+		{"{% set a, b = 1, 2 %}{{ a }}{{ b }}", nil, "12"},
+		{"{% set x = 1 %}{% for i in [1] %}{% set x = 2 %}{% endfor %}{{ x }}", nil, "1"},
+		{"{% set x %}captured{% endset %}{{ x }}", nil, "captured"},
+		{"{% with a = 1, b = 2 %}{{ a + b }}{% endwith %}{{ a }}", nil, "3"},
+		{"{% block title %}Title{% endblock %}", nil, "Title"},
+		{"{{ user.Name }} {{ user.Age }}", map[string]any{"user": struct {
+			Name string
+			Age  int
+		}{"Ann", 30}}, "Ann 30"},
+		{"{{ f(1, 2) }}", map[string]any{"f": func(a, b int) int { return a + b }}, "3"},
+		{"{{ 1.0 }} {{ 1e20 }} {{ 0.1 + 0.2 }}", nil, "1.0 1e+20 0.30000000000000004"},
+	})
+
+	var autoEscaped []string
+	opts := DefaultEnvOpts()
+	opts.AutoEscape = func(name string) bool {
+		autoEscaped = append(autoEscaped, name)
+		return true
+	}
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := env.FromString("{{ '<' }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.Name() != nil {
+		t.Fatalf("expected string template without a name, got %q", *tmpl.Name())
+	}
+	if res, err := tmpl.Render(nil); err != nil || res != "&lt;" {
+		t.Fatalf("unexpected output %q, %v", res, err)
+	}
+	if len(autoEscaped) != 1 || autoEscaped[0] != "" {
+		t.Fatalf("expected autoescape to be called without a name, got %q", autoEscaped)
+	}
+}
+
+func TestForLoop(t *testing.T) {
+	env := newTestEnv(t, nil)
+	items := map[string]any{"items": []string{"a", "b", "c"}}
+	runRenderCases(t, env, []renderCase{
+		{"{% for x in items %}{{ loop.index }}{{ loop.index0 }}{{ loop.revindex }}{{ loop.revindex0 }} {% endfor %}", items, "1032 2121 3210 "},
+		{"{% for x in items %}{{ loop.first }}-{{ loop.last }}-{{ loop.length }} {% endfor %}", items, "True-False-3 False-False-3 False-True-3 "},
+		{"{% for x in items if x != 'c' %}{{ x }}{% if not loop.last %},{% endif %}{% endfor %}", items, "a,b"},
+		{"{% for x in items if x == 'z' %}{{ x }}{% else %}none{% endfor %}", items, "none"},
+		{"{% for x in items %}{% for y in items %}{% endfor %}{{ loop.index }}{% endfor %}", items, "123"},
+		{"{% for x in items %}{{ loop.previtem is defined }}{{ loop.previtem }}-{{ loop.nextitem if loop.nextitem is defined else '!' }} {% endfor %}", items, "False-b Truea-c Trueb-! "},
+		{"{% for x in items %}{{ loop.cycle('odd', 'even') }} {% endfor %}", items, "odd even odd "},
+		{"{% for x in [1, 1, 2, 2, 1] %}{% if loop.changed(x) %}{{ x }}{% endif %}{% endfor %}", nil, "121"},
+		{"{% for x in items %}{{ loop.depth }}{{ loop.depth0 }}{% endfor %}", items, "101010"},
+		{"{{ items|length if false else '' }}{% for x in items %}{{ loop }}{% endfor %}", items, "<LoopContext 1/3><LoopContext 2/3><LoopContext 3/3>"},
+		{"{% for x in ch %}{{ x }}{{ loop.revindex }}{% endfor %}", map[string]any{"ch": func() chan int {
+			ch := make(chan int, 3)
+			ch <- 1
+			ch <- 2
+			close(ch)
+			return ch
+		}()}, "1221"},
+		{"{% for x in seq %}{{ x }}{% if not loop.last %},{% endif %}{% endfor %}", map[string]any{"seq": func(yield func(int) bool) {
+			for i := 0; i < 3 && yield(i); i++ {
+			}
+		}}, "0,1,2"},
+		{"{% for k, v in seq %}{{ k }}={{ v }};{% endfor %}", map[string]any{"seq": func(yield func(string, int) bool) {
+			_ = yield("a", 1) && yield("b", 2)
+		}}, "a=1;b=2;"},
+	})
+}
+
+type countingIterator struct {
+	items []any
+	calls int
+}
+
+func (it *countingIterator) Next() (any, bool, error) {
+	it.calls++
+	if len(it.items) == 0 {
+		return nil, false, nil
+	}
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, true, nil
+}
+
+func TestForLoopLazy(t *testing.T) {
+	env := newTestEnv(t, nil)
+	newIterator := func() map[string]any {
+		it := &countingIterator{items: []any{0, 1, 2}}
+		return map[string]any{"it": it, "calls": func() int { return it.calls }}
+	}
+	runRenderCases(t, env, []renderCase{
+		{"{% for x in it %}{{ calls() }}{% endfor %}", newIterator(), "123"},
+		{"{% for x in it %}{{ loop.last }}{{ calls() }} {% endfor %}", newIterator(), "False2 False3 True4 "},
+		{"{% for x in it %}{{ loop.length }}{{ calls() }} {% endfor %}", newIterator(), "34 34 34 "},
+		{"{% for x in it if x != 1 %}{{ x }}{{ loop.last }}{{ calls() }} {% endfor %}", newIterator(), "0False3 2True4 "},
+	})
+}
+
+func TestRecursiveLoop(t *testing.T) {
+	env := newTestEnv(t, nil)
+	tree := []any{
+		map[string]any{"name": "a", "children": []any{
+			map[string]any{"name": "b", "children": []any{}},
+			map[string]any{"name": "c", "children": []any{map[string]any{"name": "d", "children": []any{}}}},
+		}},
+		map[string]any{"name": "e", "children": []any{}},
+	}
+	runRenderCases(t, env, []renderCase{
+		{"{% for item in tree recursive %}{{ item.name }}{{ loop.depth }}{% if item.children %}[{{ loop(item.children) }}]{% endif %}{% endfor %}", map[string]any{"tree": tree}, "a1[b2c2[d3]]e1"},
+		{"{% for item in tree recursive %}{{ loop.depth0 }}{{ loop(item.children) }}{% else %}-{% endfor %}", map[string]any{"tree": tree}, "01-12-0-"},
+	})
+
+	tmpl, err := env.FromString("{% for x in [1] %}{{ loop([]) }}{% endfor %}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(nil); err == nil || !strings.Contains(err.Error(), "must be marked as 'recursive'") {
+		t.Fatalf("expected error for non-recursive loop, got %v", err)
+	}
+}
+
+func TestDefaultGlobals(t *testing.T) {
+	env := newTestEnv(t, nil)
+	runRenderCases(t, env, []renderCase{
+		{"{% for i in range(3) %}{{ i }}{% endfor %}", nil, "012"},
+		{"{% for i in range(1, 10, 3) %}{{ i }}{% endfor %}", nil, "147"},
+		{"{% for i in range(3, 0, -1) %}{{ i }}{% endfor %}", nil, "321"},
+		{"{{ range(9223372036854775805, 9223372036854775807) }}{{ range(-9223372036854775806, -9223372036854775807, -1) }}", nil, "[9223372036854775805, 9223372036854775806][-9223372036854775806]"},
+		{"{{ range(0, 9223372036854775807, 4611686018427387904) }}", nil, "[0, 4611686018427387904]"},
+		{"{{ dict(a=1).a }}{{ dict([('b', 2)]).b }}", nil, "12"},
+		{"{% set c = cycler('a', 'b') %}{{ c.next() }}{{ c.next() }}{{ c.current }}{{ c.next() }}{% set _ = c.reset() %}{{ c.current }}", nil, "abaaa"},
+		{"{% set pipe = joiner('|') %}{% for i in range(3) %}{{ pipe() }}{{ i }}{% endfor %}", nil, "0|1|2"},
+		{"{% set comma = joiner() %}{% for i in range(2) %}{{ comma() }}{{ i }}{% endfor %}", nil, "0, 1"},
+		{"{% set ns = namespace(found=false) %}{% for i in range(3) %}{% if i == 1 %}{% set ns.found = true %}{% endif %}{% endfor %}{{ ns.found }}", nil, "True"},
+		{"{% set ns = namespace() %}{% set ns.x %}block{% endset %}{{ ns.x }}", nil, "block"},
+	})
+
+	tmpl, err := env.FromString("{{ lipsum(2, html=false, min=5, max=6) }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := tmpl.Render(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paragraphs := strings.Split(res, "\n\n")
+	if len(paragraphs) != 2 || len(strings.Fields(paragraphs[0])) != 5 || !strings.HasSuffix(paragraphs[1], ".") {
+		t.Fatalf("unexpected lipsum output %q", res)
+	}
+	tmpl, err = env.FromString("{{ lipsum(1) }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err = tmpl.Render(nil); err != nil || !strings.HasPrefix(res, "<p>") || !strings.HasSuffix(res, "</p>") {
+		t.Fatalf("unexpected lipsum output %q, %v", res, err)
+	}
+
+	for source, msg := range map[string]string{
+		"{{ range(1000000) }}":                                       "Range too big",
+		"{{ range(0, 9223372036854775807, 92233720368547) }}":        "Range too big",
+		"{{ range(-9223372036854775807, 9223372036854775807) }}":     "Range too big",
+		"{{ range(9223372036854775807, -9223372036854775807, -1) }}": "Range too big",
+		"{{ range(1, 2, 0) }}":                                       "must not be zero",
+		"{% set x = 1 %}{% set x.a = 2 %}":                           "cannot assign attribute on non-namespace object",
+		"{{ cycler() }}":                                             "at least one item",
+	} {
+		tmpl, err := env.FromString(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.Render(nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected error containing %q, got %v", source, msg, err)
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	env := newTestEnv(t, nil)
+	runRenderCases(t, env, []renderCase{
+		{"{% macro hello(name) %}Hello {{ name }}!{% endmacro %}{{ hello('World') }}", nil, "Hello World!"},
+		{"{% macro m(a, b=2, c=a) %}{{ a }}{{ b }}{{ c }}{% endmacro %}{{ m(1) }}|{{ m(1, c=3) }}|{{ m(b=5, a=4) }}", nil, "121|123|454"},
+		{"{% macro m(a) %}{{ a is defined }}{% endmacro %}{{ m() }}", nil, "False"},
+		{"{% macro m(a) %}{{ a }}{{ varargs }}{{ kwargs }}{% endmacro %}{{ m(1, 2, 3, x=4) }}", nil, "1[2, 3]{'x': 4}"},
+		{"{% macro m() %}[{{ caller() }}]{% endmacro %}{% call m() %}body{% endcall %}", nil, "[body]"},
+		{"{% macro list(items) %}{% for i in items %}{{ caller(i, loop.index) }}{% endfor %}{% endmacro %}" +
+			"{% call(item, n) list(['a', 'b']) %}{{ n }}={{ item }};{% endcall %}", nil, "1=a;2=b;"},
+		{"{% macro m() %}{{ caller is defined }}{% endmacro %}{{ m() }}", nil, "False"},
+		{"{% macro m(x, y=1) %}{{ varargs }}{{ kwargs }}{{ caller }}{% endmacro %}" +
+			"{{ m.name }} {{ m.arguments }} {{ m.catch_varargs }} {{ m.catch_kwargs }} {{ m.caller }}", nil, "m ['x', 'y'] True True True"},
+		{"{% macro m() %}{% endmacro %}{{ m.catch_varargs }} {{ m.catch_kwargs }} {{ m.caller }}", nil, "False False False"},
+		{"{% set x = 1 %}{% macro m() %}{% set x = 2 %}{{ x }}{% endmacro %}{{ m() }}{{ x }}", nil, "21"},
+		{"{% macro fact(n) %}{% if n > 1 %}{{ n }}*{{ fact(n - 1) }}{% else %}1{% endif %}{% endmacro %}{{ fact(3) }}", nil, "3*2*1"},
+	})
+
+	for source, msg := range map[string]string{
+		"{% macro m(a) %}{% endmacro %}{{ m(1, 2) }}":                  "macro 'm' (line 1) takes not more than 1 argument(s)",
+		"{% macro m(a) %}{% endmacro %}{{ m(b=1) }}":                   "macro 'm' (line 1) takes no keyword argument 'b'",
+		"\n{% macro m() %}{% endmacro %}\n{% call m() %}{% endcall %}": "<template>:3: macro 'm' (line 2) was invoked with two values for the special caller argument",
+	} {
+		tmpl, err := env.FromString(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.Render(nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected error containing %q, got %v", source, msg, err)
+		}
+	}
+
+	opts := DefaultEnvOpts()
+	opts.AutoEscape = true
+	escEnv, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderCases(t, escEnv, []renderCase{
+		{"{% macro b(s) %}<b>{{ s }}</b>{% endmacro %}{{ b('<i>') }}", nil, "<b>&lt;i&gt;</b>"},
+		{"{% macro b() %}<b>{{ caller() }}</b>{% endmacro %}{% call b() %}<i>{% endcall %}", nil, "<b><i></b>"},
+	})
+}
+
+func TestAutoEscape(t *testing.T) {
+	opts := DefaultEnvOpts()
+	opts.AutoEscape = true
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderCases(t, env, []renderCase{
+		{"<b>{{ s }}</b>", map[string]any{"s": "<i>&"}, "<b>&lt;i&gt;&amp;</b>"},
+		{"{% autoescape false %}{{ s }}{% endautoescape %}{{ s }}", map[string]any{"s": "<"}, "<&lt;"},
+		{"{% set x %}<b>{% endset %}{{ x }}", nil, "<b>"},
+	})
+}
+
+func TestFinalize(t *testing.T) {
+	newEnv := func(finalize any, autoEscape bool) *Environment {
+		opts := DefaultEnvOpts()
+		opts.Finalize = finalize
+		opts.AutoEscape = autoEscape
+		env, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+	vars := map[string]any{"date": time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC), "prefix": "> "}
+
+	env := newEnv(func(args ...any) any {
+		switch v := args[0].(type) {
+		case nil:
+			return ""
+		case time.Time:
+			return v.Format("2006-01-02")
+		}
+		return args[0]
+	}, false)
+	runRenderCases(t, env, []renderCase{
+		{"{{ none }}|{{ date }}|{{ [none] }}|None", vars, "|2022-07-01|[None]|None"},
+		{"{% macro m() %}{{ none }}{% endmacro %}{{ m() }}|{% for x in [none, date] %}{{ x }},{% endfor %}", vars, "|,2022-07-01,"},
+	})
+
+	env = newEnv(ContextFinalize(func(ctx *runtime.Context, value any) any {
+		return fmt.Sprint(ctx.ResolveOrMissing("prefix"), value)
+	}), false)
+	runRenderCases(t, env, []renderCase{
+		{"<{{ 1 }}{% set prefix = '# ' %}{{ 2 }}>", vars, "<> 1# 2>"},
+	})
+
+	env = newEnv(EvalContextFinalize(func(evalCtx *runtime.EvalContext, value any) any {
+		return fmt.Sprint(evalCtx.AutoEscape)
+	}), true)
+	runRenderCases(t, env, []renderCase{
+		{"{{ 1 }}{% autoescape false %}{{ 2 }}{% endautoescape %}{{ 3 }}", nil, "truefalsetrue"},
+	})
+
+	env = newEnv(EnvironmentFinalize(func(env *Environment, value any) any {
+		return fmt.Sprint(env.Globals["unit"], value)
+	}), false)
+	env.Globals["unit"] = "$"
+	runRenderCases(t, env, []renderCase{
+		{"{{ 1 }}-{{ 2 }}", nil, "$1-$2"},
+	})
+
+	env = newEnv(func(evalCtx *runtime.EvalContext, value any) any {
+		return fmt.Sprint(evalCtx.AutoEscape, value)
+	}, false)
+	runRenderCases(t, env, []renderCase{
+		{"{{ 1 }}", nil, "false 1"},
+	})
+
+	env = newEnv(func(env *Environment, value any) any {
+		return fmt.Sprint(env.Globals["unit"], value)
+	}, false)
+	env.Globals["unit"] = "€"
+	runRenderCases(t, env, []renderCase{
+		{"{{ 1 }}", nil, "€1"},
+	})
+	if env.Finalize != nil {
+		t.Error("expected no Finalize for a finalize function passed the environment")
+	}
+
+	env = newTestEnv(t, nil)
+	env.Finalize = func(args ...any) any { return fmt.Sprint("[", args[0], "]") }
+	runRenderCases(t, env, []renderCase{
+		{"{{ 1 }}", nil, "[1]"},
+	})
+
+	env = newEnv(func(value any) any {
+		if value == nil {
+			return fmt.Errorf("printed none")
+		}
+		return value
+	}, false)
+	tmpl, err := env.FromString("a\n{{ 1 }}{{ none }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(nil); err == nil || err.Error() != "<template>:2: printed none" {
+		t.Errorf("expected finalize error, got %v", err)
+	}
+
+	opts := DefaultEnvOpts()
+	opts.Finalize = func(string) string { return "" }
+	if _, err := New(opts); err == nil {
+		t.Error("expected an error for a finalize function of the wrong type")
+	}
+}
+
+func TestFromStringErrors(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	_, err := env.FromString("{% if %}", nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "<template>:1") {
+		t.Fatalf("expected syntax error with template location, got %v", err)
+	}
+
+	_, err = env.FromString("{% block a %}{% endblock %}{% block a %}{% endblock %}", nil)
+	if err == nil || !strings.Contains(err.Error(), "block 'a' defined twice") {
+		t.Fatalf("expected duplicate block error, got %v", err)
+	}
+
+	tmpl, err := env.FromString("\n{{ 1 / 0 }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(nil)
+	var renderErr *errors.RenderError
+	if !stdErrors.As(err, &renderErr) || renderErr.Lineno != 2 || !strings.HasPrefix(err.Error(), "<template>:2") {
+		t.Fatalf("expected render error on line 2, got %v", err)
+	}
+
+	tmpl, err = env.FromString("{{ missing.attr }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(nil)
+	var undefinedErr *errors.UndefinedError
+	if !stdErrors.As(err, &undefinedErr) {
+		t.Fatalf("expected undefined error, got %v", err)
+	}
+}
+
+func TestExtends(t *testing.T) {
+	env := newTestEnv(t, map[string]string{
+		"base.html":   "<title>{% block title %}Base{% endblock %}</title>{% block body %}{% endblock %}",
+		"middle.html": `{% extends "base.html" %}{% block title %}Middle {{ super() }}{% endblock %}`,
+		"child.html":  `{% extends "middle.html" %}ignored{% block body %}Body{% endblock %}{% block title %}Child {{ super() }}{% endblock %}`,
+		"twice.html":  `{% extends "base.html" %}{% extends "base.html" %}`,
+		"req.html":    `{% block content required %}{% endblock %}`,
+		"scoped.html": `{% for i in [1, 2] %}{% block item scoped %}{{ i }}{% endblock %}{% endfor %}`,
+	})
+
+	render := func(name string) (string, error) {
+		tmpl, err := env.GetTemplate(name, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl.Render(nil)
+	}
+
+	res, err := render("child.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "<title>Child Middle Base</title>Body" {
+		t.Fatalf("unexpected output %q", res)
+	}
+
+	res, err = render("scoped.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "12" {
+		t.Fatalf("unexpected output %q", res)
+	}
+
+	if _, err := render("twice.html"); err == nil || !strings.Contains(err.Error(), "extended multiple times") {
+		t.Fatalf("expected error, got %v", err)
+	}
+	if _, err := render("req.html"); err == nil || !strings.Contains(err.Error(), "Required block 'content' not found") {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	env := newTestEnv(t, map[string]string{
+		"header.html": "[{{ title }}|{{ local }}]",
+		"a.html":      "A",
+		"set.html":    "{% set title = 'changed' %}{{ title }}",
+	})
+	runRenderCases(t, env, []renderCase{
+		{`{% include "a.html" %}`, nil, "A"},
+		{`{% set local = 1 %}{% include "header.html" %}`, map[string]any{"title": "T"}, "[T|1]"},
+		{`{% for local in [1, 2] %}{% include "header.html" %}{% endfor %}`, map[string]any{"title": "T"}, "[T|1][T|2]"},
+		{`{% include "header.html" without context %}`, map[string]any{"title": "T"}, "[|]"},
+		{`{% include "set.html" %}{{ title }}`, map[string]any{"title": "T"}, "changedT"},
+		{`{% include ["missing.html", "a.html"] %}`, nil, "A"},
+		{`{% include ("missing.html", "a.html") %}`, nil, "A"},
+		{`{% include names %}`, map[string]any{"names": []string{"missing.html", "a.html"}}, "A"},
+		{`{% include "missing.html" ignore missing %}B`, nil, "B"},
+		{`{% include ["missing.html", "other.html"] ignore missing %}B`, nil, "B"},
+	})
+
+	tmpl, err := env.FromString(`{% include ["missing.html", "other.html"] %}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(nil)
+	var notFound *errors.TemplatesNotFoundError
+	if !stdErrors.As(err, &notFound) || len(notFound.Names) != 2 || !strings.Contains(err.Error(), "none of the templates given were found: missing.html, other.html") {
+		t.Fatalf("expected templates not found error, got %v", err)
+	}
+	var single *errors.TemplateNotFoundError
+	if !stdErrors.As(err, &single) || single.Name != "other.html" {
+		t.Fatalf("expected template not found error for the last name, got %v", err)
+	}
+
+	// included templates render straight into the writer of the including template
+	tmpl, err = env.FromString(`{% include "a.html" %}{{ 1 / 0 }}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.RenderTo(&b, nil); err == nil || b.String() != "A" {
+		t.Fatalf("expected included output before the error, got %q, %v", b.String(), err)
+	}
+}
+
+func TestFileSystemLoaderSearchPath(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeTemplates(t, second, map[string]string{"b.html": "B"})
+	opts := DefaultEnvOpts()
+	opts.Loader = NewFileSystemLoader([]string{first, second}, "utf-8", false)
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := env.GetTemplate("b.html", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := tmpl.Render(nil); err != nil || res != "B" {
+		t.Fatalf("unexpected output %q, %v", res, err)
+	}
+	var notFound *errors.TemplateNotFoundError
+	if _, err := env.GetTemplate("c.html", nil, nil); !stdErrors.As(err, &notFound) {
+		t.Fatalf("expected template not found error, got %v", err)
+	}
+}
+
+type lookupAddress struct {
+	City string `json:"city"`
+}
+
+type lookupBase struct {
+	ID      int
+	Created string `json:"created_at,omitempty"`
+}
+
+type lookupUser struct {
+	lookupBase
+	*lookupAddress
+	FirstName string
+	Nick      string `json:"-"`
+	Tags      map[int]string
+	password  string
+}
+
+func (u lookupUser) FullName() string {
+	return u.FirstName + " Doe"
+}
+
+func (u *lookupUser) HTTPGreeting() string {
+	return "Hi " + u.FirstName
+}
+
+func TestAttributeLookup(t *testing.T) {
+	user := lookupUser{
+		lookupBase:    lookupBase{ID: 7, Created: "today"},
+		lookupAddress: &lookupAddress{City: "Oslo"},
+		FirstName:     "Ann",
+		Nick:          "annie",
+		Tags:          map[int]string{1: "a", 2: "b"},
+		password:      "secret",
+	}
+	userPtr := &user
+	vars := map[string]any{
+		"user":     user,
+		"ptr":      &userPtr,
+		"nobody":   lookupUser{},
+		"nilptr":   (*lookupUser)(nil),
+		"items":    []any{1, 2, 3},
+		"floats":   map[float64]string{1: "one"},
+		"anything": map[any]any{1: "int", "a": "str"},
+	}
+	runRenderCases(t, newTestEnv(t, nil), []renderCase{
+		{"{{ user.FirstName }} {{ user.first_name }} {{ user['first_name'] }}", vars, "Ann Ann Ann"},
+		{"{{ user.id }} {{ user.ID }} {{ user.created_at }} {{ user.city }}", vars, "7 7 today Oslo"},
+		{"{{ user.full_name() }} {{ user.http_greeting() }} {{ user.HTTPGreeting() }}", vars, "Ann Doe Hi Ann Hi Ann"},
+		{"{{ ptr.first_name }} {{ ptr.full_name() }} {{ ptr.city }}", vars, "Ann Ann Doe Oslo"},
+		{"{{ user.tags[1] }}{{ user.tags[2] }}{{ floats[1] }}{{ anything[1] }}{{ anything.a }}", vars, "aboneintstr"},
+		{"{{ items[-1] }}{{ items[-3] }}", vars, "31"},
+		{"{{ user.nick }}|{{ user.password }}|{{ nobody.city }}|{{ items[-4] }}", vars, "annie|||"},
+		{"{{ nilptr.full_name is defined }}|{{ nilptr.http_greeting is defined }}|{{ nilptr.first_name }}", vars, "False|False|"},
+	})
+
+	opts := DefaultEnvOpts()
+	opts.Undefined = runtime.NewStrictUndefined
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for source, expected := range map[string]string{
+		"{{ user.missing }}":       "'lookupUser object' has no attribute 'missing'",
+		"{{ items[-4] }}":          "list object has no element -4",
+		"{{ user.tags[3] }}":       "dict object has no element 3",
+		"{{ nobody.city }}":        "'lookupUser object' has no attribute 'city'",
+		"{{ none.attr }}":          "'None' has no attribute 'attr'",
+		"{{ user.password }}":      "'lookupUser object' has no attribute 'password'",
+		"{{ nilptr.full_name() }}": "has no attribute 'full_name'",
+		"{{ boom() }}":             "call of func() string panicked: boom",
+	} {
+		tmpl, err := env.FromString(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tmpl.Render(map[string]any{
+			"user": user, "nobody": lookupUser{}, "nilptr": (*lookupUser)(nil), "items": []any{1}, "none": nil,
+			"boom": func() string { panic("boom") },
+		})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, got %v", source, expected, err)
+		}
+	}
+
+	opts = DefaultEnvOpts()
+	opts.AttrLookup = &runtime.AttrLookup{Exact: true}
+	env, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderCases(t, env, []renderCase{
+		{"{{ user.FirstName }}|{{ user.first_name }}|{{ user.created_at }}|{{ user.full_name }}", vars, "Ann|||"},
+	})
+	opts.AttrLookup = &runtime.AttrLookup{Tags: []string{"json"}}
+	env, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderCases(t, env, []renderCase{
+		{"{{ user.FirstName }}|{{ user.created_at }}|{{ user.city }}", vars, "|today|Oslo"},
+	})
+}
+
+// markedUndefined is a custom undefined value printed as a marker.
+type markedUndefined struct {
+	runtime.BaseUndefined
+}
+
+func (markedUndefined) String_() (string, error) {
+	return "<missing>", nil
+}
+
+func newUndefinedEnv(t *testing.T, undefined runtime.UndefinedConstructor) *Environment {
+	opts := DefaultEnvOpts()
+	opts.Undefined = undefined
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func expectUndefinedErrors(t *testing.T, env *Environment, cases map[string]string) {
+	t.Helper()
+	for source, expected := range cases {
+		tmpl, err := env.FromString(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tmpl.Render(map[string]any{"data": map[string]any{}})
+		var undefinedErr *errors.UndefinedError
+		if !stdErrors.As(err, &undefinedErr) || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected undefined error %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestUndefinedTypes(t *testing.T) {
+	vars := map[string]any{"data": map[string]any{}}
+
+	env := newUndefinedEnv(t, runtime.NewUndefined)
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing }}|{{ missing is defined }}|{{ missing is undefined }}", vars, "|False|True"},
+		{"{{ missing == missing }}{{ missing != 1 }}{{ missing == none }}{{ 1 in missing }}", vars, "TrueTrueFalseFalse"},
+		{"{% for x in missing %}x{% endfor %}{{ 'y' if missing else 'n' }}{{ [missing] }}", vars, "n[Undefined]"},
+		{"{{ data.missing }}{{ data['missing'] }}", vars, ""},
+	})
+	expectUndefinedErrors(t, env, map[string]string{
+		"{{ missing + 1 }}":    "'missing' is undefined",
+		"{{ 1 - missing }}":    "'missing' is undefined",
+		"{{ -missing }}":       "'missing' is undefined",
+		"{{ missing < 1 }}":    "'missing' is undefined",
+		"{{ missing.attr }}":   "'missing' is undefined",
+		"{{ missing[0] }}":     "'missing' is undefined",
+		"{{ missing() }}":      "'missing' is undefined",
+		"{{ data.a.b }}":       "'dict object' has no attribute 'a'",
+		"{{ data['a'] + 1 }}":  "'dict object' has no attribute 'a'",
+		"{{ data[1] ** 2 }}":   "dict object has no element 1",
+		"{{ missing * 'ab' }}": "'missing' is undefined",
+	})
+
+	env = newUndefinedEnv(t, runtime.NewChainableUndefined)
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing.a.b['c'] }}|{{ data.a.b }}|{{ missing.a == missing }}|{{ missing.a is defined }}", vars, "||True|False"},
+	})
+	expectUndefinedErrors(t, env, map[string]string{
+		"{{ missing.a + 1 }}": "'missing' is undefined",
+	})
+
+	env = newUndefinedEnv(t, runtime.NewDebugUndefined)
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing }}|{{ data.missing }}|{{ data[1] }}", vars, "{{ missing }}|{{ no such element: dict object['missing'] }}|{{ no such element: dict object[1] }}"},
+		{"{% for x in [1] %}{{ loop.previtem }}{% endfor %}", vars, "{{ undefined value printed: there is no previous item }}"},
+		{"{{ missing == missing }}{{ 'y' if missing else 'n' }}", vars, "Truen"},
+	})
+
+	env = newUndefinedEnv(t, runtime.NewStrictUndefined)
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing is defined }}{{ missing is undefined }}{{ data.a is defined }}", vars, "FalseTrueFalse"},
+	})
+	expectUndefinedErrors(t, env, map[string]string{
+		"{{ missing }}":                                    "'missing' is undefined",
+		"{% if missing %}{% endif %}":                      "'missing' is undefined",
+		"{{ missing == 1 }}":                               "'missing' is undefined",
+		"{{ missing != missing }}":                         "'missing' is undefined",
+		"{% for x in missing %}{% endfor %}":               "'missing' is undefined",
+		"{{ 1 in missing }}":                               "'missing' is undefined",
+		"{{ data.a }}":                                     "'dict object' has no attribute 'a'",
+		"{{ 'a' ~ missing }}":                              "'missing' is undefined",
+		"{% if not missing %}{% endif %}":                  "'missing' is undefined",
+		"{% for x in [1] if missing %}{% endfor %}":        "'missing' is undefined",
+		"{% set ns = namespace() %}{{ ns.missing }}":       "'Namespace object' has no attribute 'missing'",
+		"{% set c = cycler(1) %}{{ c.missing }}":           "'Cycler object' has no attribute 'missing'",
+		"{% macro m() %}{% endmacro %}{{ m.missing }}":     "'Macro object' has no attribute 'missing'",
+		"{% for x in [1] %}{{ loop.missing }}{% endfor %}": "'LoopContext object' has no attribute 'missing'",
+	})
+
+	env = newUndefinedEnv(t, func(hint *string, obj any, name any, exc func(msg string) error) runtime.IUndefined {
+		return markedUndefined{runtime.NewUndefined(hint, obj, name, exc).(runtime.BaseUndefined)}
+	})
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing }}|{{ data.a }}|{{ missing is defined }}", vars, "<missing>|<missing>|False"},
+	})
+}
+
+func TestLoggingUndefined(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	vars := map[string]any{"data": map[string]any{}}
+
+	// The values behave like the ones of the wrapped constructor.
+	env := newUndefinedEnv(t, runtime.MakeLoggingUndefined(logger, nil))
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing }}|{{ missing is defined }}|{{ missing == missing }}{{ missing != 1 }}", vars, "|False|TrueTrue"},
+		{"{% for x in missing %}x{% endfor %}{{ 'y' if missing else 'n' }}{{ data.missing }}", vars, "n"},
+	})
+	expectUndefinedErrors(t, env, map[string]string{
+		"{{ missing + 1 }}":  "'missing' is undefined",
+		"{{ 1 - missing }}":  "'missing' is undefined",
+		"{{ missing.attr }}": "'missing' is undefined",
+		"{{ data[1] ** 2 }}": "dict object has no element 1",
+	})
+	env = newUndefinedEnv(t, runtime.MakeLoggingUndefined(logger, runtime.NewChainableUndefined))
+	runRenderCases(t, env, []renderCase{
+		{"{{ missing.a.b['c'] }}|{{ missing.a == missing }}", vars, "|True"},
+	})
+	env = newUndefinedEnv(t, runtime.MakeLoggingUndefined(logger, runtime.NewStrictUndefined))
+	expectUndefinedErrors(t, env, map[string]string{
+		"{{ missing }}": "'missing' is undefined",
+	})
+
+	buf.Reset()
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"page.html": "<h1>{{ title }}</h1>\n{% if user.admin %}admin{% endif %}\n{{ user.name + 1 }}",
+	})
+	opts := DefaultEnvOpts()
+	opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	opts.Undefined = runtime.MakeLoggingUndefined(logger, nil)
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := env.GetTemplate("page.html", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(map[string]any{"user": map[string]any{}}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	expected := []map[string]any{
+		{"level": "WARN", "template": "page.html", "line": 1.0, "variable": "title", "operation": "str", "message": "'title' is undefined"},
+		{"level": "WARN", "template": "page.html", "line": 2.0, "variable": "admin", "object": "dict object", "operation": "bool"},
+		{"level": "ERROR", "template": "page.html", "line": 3.0, "variable": "name", "operation": "add", "error": "'dict object' has no attribute 'name'"},
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d records, got %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range expected[i] {
+			if record[k] != v {
+				t.Errorf("record %d: expected %s=%v, got %v", i, k, v, record[k])
+			}
+		}
+	}
+}
+
+// auditRecord returns undefined values for its missing attributes and items.
+type auditRecord struct{}
+
+func (r auditRecord) GetAttr(name string) (any, error) {
+	return runtime.NewUndefined(nil, r, name, nil), nil
+}
+
+func (r auditRecord) GetItem(key any) (any, error) {
+	return runtime.NewUndefined(nil, r, key, nil), nil
+}
+
+func TestRenderAudit(t *testing.T) {
+	env := newTestEnv(t, map[string]string{
+		"page.html": "{{ title }}\n{% include 'nav.html' %}\n{{ user.name }}{{ user['email'] }}{{ items[3] }}\n" +
+			"{% for i in [1, 2] %}{{ title }}{% endfor %}{{ subtitle is defined }}{{ footer|default('f') }}",
+		"nav.html": "{{ links }}",
+		"ok.html":  "{{ title }}{% if loop is undefined %}{{ missing|default('') }}{% endif %}",
+		"runtime.html": "{% macro m(a) %}{{ a }}{% endmacro %}{{ m() }}\n" +
+			"{% set ns = namespace() %}{{ ns.x }}{% set c = cycler(1) %}{{ c.y }}\n" +
+			"{% for i in [1] %}{{ loop.z }}{% endfor %}\n" +
+			"{{ record.name }}{{ record['id'] }}{{ record.email is defined }}",
+	})
+	env.Filters["default"] = func(args []any, _ map[string]any) any {
+		if _, ok := args[0].(runtime.IUndefined); ok {
+			return args[1]
+		}
+		return args[0]
+	}
+	render := func(name string, vars map[string]any) (string, *runtime.UndefinedReport) {
+		tmpl, err := env.GetTemplate(name, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, report, err := tmpl.RenderAudit(context.Background(), vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out, report
+	}
+
+	out, report := render("page.html", map[string]any{"user": map[string]any{}, "items": []any{1}})
+	if out != "\n\n\nFalsef" {
+		t.Errorf("unexpected output %q", out)
+	}
+	expected := []runtime.UndefinedAccess{
+		{Template: "page.html", Lineno: 1, Name: "title", Message: "'title' is undefined"},
+		{Template: "nav.html", Lineno: 1, Name: "links", Message: "'links' is undefined"},
+		{Template: "page.html", Lineno: 3, Name: "name", Object: "dict object", Message: "'dict object' has no attribute 'name'"},
+		{Template: "page.html", Lineno: 3, Name: "email", Object: "dict object", Message: "'dict object' has no attribute 'email'"},
+		{Template: "page.html", Lineno: 3, Name: "3", Object: "list object", Message: "list object has no element 3"},
+		{Template: "page.html", Lineno: 4, Name: "title", Message: "'title' is undefined"},
+	}
+	if !reflect.DeepEqual(report.Accesses, expected) {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+	if line := strings.SplitN(report.String(), "\n", 2)[0]; line != "page.html:1: 'title' is undefined" {
+		t.Errorf("unexpected report line %q", line)
+	}
+
+	if out, report := render("ok.html", map[string]any{"title": "T"}); out != "T" || !report.Empty() {
+		t.Errorf("expected an empty report, got %q:\n%s", out, report)
+	}
+
+	out, report = render("runtime.html", map[string]any{"record": auditRecord{}})
+	if out != "\n\n\nFalse" {
+		t.Errorf("unexpected output %q", out)
+	}
+	expected = []runtime.UndefinedAccess{
+		{Template: "runtime.html", Lineno: 1, Name: "a", Message: "parameter 'a' was not provided"},
+		{Template: "runtime.html", Lineno: 2, Name: "x", Object: "Namespace object", Message: "'Namespace object' has no attribute 'x'"},
+		{Template: "runtime.html", Lineno: 2, Name: "y", Object: "Cycler object", Message: "'Cycler object' has no attribute 'y'"},
+		{Template: "runtime.html", Lineno: 3, Name: "z", Object: "LoopContext object", Message: "'LoopContext object' has no attribute 'z'"},
+		{Template: "runtime.html", Lineno: 4, Name: "name", Object: "auditRecord object", Message: "'auditRecord object' has no attribute 'name'"},
+		{Template: "runtime.html", Lineno: 4, Name: "id", Object: "auditRecord object", Message: "'auditRecord object' has no attribute 'id'"},
+	}
+	if !reflect.DeepEqual(report.Accesses, expected) {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+}
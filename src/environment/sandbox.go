@@ -0,0 +1,405 @@
+package environment
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/runtime"
+	"github.com/gojinja/gojinja/src/utils/set"
+)
+
+// Sandbox is the security policy of a sandboxed environment. Every attribute
+// access and call of a template goes through it. By default, names starting
+// with an underscore, unexported struct fields and methods of Go values are
+// blocked, methods can be allowed with `AllowMethods`. Attributes provided by
+// a `GetAttr` method, like the ones of `loop`, and the python list and dict
+// methods are allowed.
+type Sandbox struct {
+	// IsSafeAttribute reports whether the attribute of obj that has the value
+	// may be accessed. Defaults to `DefaultIsSafeAttribute` if nil.
+	IsSafeAttribute func(obj any, attr string, value any) bool
+	// IsSafeCallable reports whether obj may be called. Defaults to
+	// `DefaultIsSafeCallable` if nil.
+	IsSafeCallable func(obj any) bool
+	// Immutable sandboxes additionally block the methods modifying known
+	// mutable values, see `ModifiesKnownMutable`.
+	Immutable bool
+	// InterceptedBinops and InterceptedUnops are the operators, like "**"
+	// or "-", whose evaluation goes through CallBinop and CallUnop. "~"
+	// intercepts string concatenation.
+	InterceptedBinops set.Set[string]
+	InterceptedUnops  set.Set[string]
+	// CallBinop evaluates the intercepted binary operators. Defaults to
+	// `DefaultCallBinop` if nil.
+	CallBinop func(ctx *runtime.Context, op string, left, right any) (any, error)
+	// CallUnop evaluates the intercepted unary operators. Defaults to
+	// `DefaultCallUnop` if nil.
+	CallUnop func(ctx *runtime.Context, op string, operand any) (any, error)
+
+	safeMethods     map[reflect.Type]set.Set[string]
+	mutatingMethods map[reflect.Type]set.Set[string]
+}
+
+// NewSandbox creates a sandbox with the default policy, it intercepts "**"
+// and "*" to limit the size of powers and repeated strings and lists.
+func NewSandbox() *Sandbox {
+	return &Sandbox{
+		InterceptedBinops: set.FromElems("**", "*"),
+		InterceptedUnops:  set.New[string](),
+		safeMethods:       make(map[reflect.Type]set.Set[string]),
+	}
+}
+
+// NewImmutableSandbox creates an immutable sandbox with the default policy.
+func NewImmutableSandbox() *Sandbox {
+	s := NewSandbox()
+	s.Immutable = true
+	return s
+}
+
+// NewSandboxed creates a sandboxed environment for untrusted templates. If
+// the options don't have a sandbox, one with the default policy is used.
+func NewSandboxed(opts *EnvOpts) (*Environment, error) {
+	o := *opts
+	if o.Sandbox == nil {
+		o.Sandbox = NewSandbox()
+	}
+	return New(&o)
+}
+
+// NewImmutableSandboxed creates a sandboxed environment like `NewSandboxed`
+// whose templates can't modify maps, slices and registered mutable types.
+func NewImmutableSandboxed(opts *EnvOpts) (*Environment, error) {
+	o := *opts
+	if o.Sandbox == nil {
+		o.Sandbox = NewImmutableSandbox()
+	} else {
+		s := *o.Sandbox
+		s.Immutable = true
+		o.Sandbox = &s
+	}
+	return New(&o)
+}
+
+// elemType returns the type of the value, pointers are dereferenced.
+func elemType(v any) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// AllowMethods allows templates to access the methods of the type of v, or
+// the type v points to.
+func (s *Sandbox) AllowMethods(v any, names ...string) {
+	if s.safeMethods == nil {
+		s.safeMethods = make(map[reflect.Type]set.Set[string])
+	}
+	t := elemType(v)
+	if _, ok := s.safeMethods[t]; !ok {
+		s.safeMethods[t] = set.New[string]()
+	}
+	for _, name := range names {
+		s.safeMethods[t].Add(name)
+	}
+}
+
+// isMethodAllowed reports whether the method is allowed with `AllowMethods`.
+func (s *Sandbox) isMethodAllowed(obj any, name string) bool {
+	methods, ok := s.safeMethods[elemType(obj)]
+	return ok && methods.Has(name)
+}
+
+// listMutatingMethods and dictMutatingMethods are the python methods that
+// modify lists and dicts.
+var (
+	listMutatingMethods = set.FrozenFromElems("append", "clear", "extend", "insert", "pop", "remove", "reverse", "sort")
+	dictMutatingMethods = set.FrozenFromElems("clear", "pop", "popitem", "setdefault", "update")
+)
+
+// RegisterMutable registers the type of v, or the type v points to, as a
+// mutable type whose methods with the names modify it.
+func (s *Sandbox) RegisterMutable(v any, methods ...string) {
+	if s.mutatingMethods == nil {
+		s.mutatingMethods = make(map[reflect.Type]set.Set[string])
+	}
+	t := elemType(v)
+	if _, ok := s.mutatingMethods[t]; !ok {
+		s.mutatingMethods[t] = set.New[string]()
+	}
+	for _, name := range methods {
+		s.mutatingMethods[t].Add(name)
+	}
+}
+
+// ModifiesKnownMutable reports whether the attribute of obj is a method
+// modifying it. Slices, arrays, maps and the types registered with
+// `RegisterMutable` are known to be mutable.
+func (s *Sandbox) ModifiesKnownMutable(obj any, attr string) bool {
+	t := elemType(obj)
+	if t == nil {
+		return false
+	}
+	if methods, ok := s.mutatingMethods[t]; ok {
+		if name, isMethod := methodLookup.MethodName(obj, attr); isMethod && methods.Has(name) {
+			return true
+		}
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return listMutatingMethods.Has(attr)
+	case reflect.Map:
+		return dictMutatingMethods.Has(attr)
+	}
+	return false
+}
+
+// methodLookup finds the methods attributes map to with any `runtime.AttrLookup`.
+var methodLookup = runtime.AttrLookup{Exact: true, SnakeCase: true}
+
+// DefaultIsSafeAttribute is the default attribute policy: names starting with
+// an underscore, unexported struct fields and methods that are not allowed
+// with `AllowMethods` are unsafe. The attributes of the runtime's own values,
+// like `loop` and macros, are safe.
+func (s *Sandbox) DefaultIsSafeAttribute(obj any, attr string, value any) bool {
+	if strings.HasPrefix(attr, "_") {
+		return false
+	}
+	switch obj.(type) {
+	case *runtime.LoopContext, *runtime.Macro, *runtime.Namespace, *runtime.Cycler, runtime.IUndefined:
+		return true
+	}
+	if obj == nil {
+		return true
+	}
+	if name, ok := methodLookup.MethodName(obj, attr); ok {
+		return s.isMethodAllowed(obj, name)
+	}
+	if t := elemType(obj); t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName(attr); ok && !f.IsExported() {
+			return false
+		}
+	}
+	return true
+}
+
+// UnsafeCallable is implemented by values that must never be called from
+// sandboxed templates, see `Unsafe`.
+type UnsafeCallable interface {
+	UnsafeCallable() bool
+}
+
+// unsafeFunc is a function marked as unsafe, it can still be called outside
+// of the sandbox.
+type unsafeFunc struct {
+	fn any
+}
+
+func (u unsafeFunc) Call(args []any, kwargs map[string]any) (any, error) {
+	return runtime.Call(u.fn, args, kwargs)
+}
+
+func (unsafeFunc) UnsafeCallable() bool {
+	return true
+}
+
+// Unsafe marks the function as unsafe, sandboxed templates can't call it.
+func Unsafe(fn any) any {
+	return unsafeFunc{fn: fn}
+}
+
+// DefaultIsSafeCallable is the default call policy: everything but values
+// marked as unsafe with `Unsafe` or by implementing `UnsafeCallable` may be
+// called.
+func DefaultIsSafeCallable(obj any) bool {
+	u, ok := obj.(UnsafeCallable)
+	return !ok || !u.UnsafeCallable()
+}
+
+func (s *Sandbox) isSafeAttribute(obj any, attr string, value any) bool {
+	if s.Immutable && s.ModifiesKnownMutable(obj, attr) {
+		return false
+	}
+	if s.IsSafeAttribute != nil {
+		return s.IsSafeAttribute(obj, attr, value)
+	}
+	return s.DefaultIsSafeAttribute(obj, attr, value)
+}
+
+func (s *Sandbox) isSafeCallable(obj any) bool {
+	if s.IsSafeCallable != nil {
+		return s.IsSafeCallable(obj)
+	}
+	return DefaultIsSafeCallable(obj)
+}
+
+// defaultSandbox is used by environments marked as sandboxed without a sandbox.
+var defaultSandbox = NewSandbox()
+
+// sandbox returns the sandbox of the environment, nil if it isn't sandboxed.
+func (env *Environment) sandbox() *Sandbox {
+	if !env.Sandboxed {
+		return nil
+	}
+	if env.Sandbox == nil {
+		return defaultSandbox
+	}
+	return env.Sandbox
+}
+
+// checkAttr returns the value of the attribute if accessing it is safe and
+// an undefined raising a `SecurityError` otherwise.
+func (r *renderer) checkAttr(obj any, attr string, value any) any {
+	s := r.env.sandbox()
+	if s == nil || s.isSafeAttribute(obj, attr, value) {
+		return value
+	}
+	hint := fmt.Sprintf("access to attribute '%s' of '%s' object is unsafe.", attr, runtime.TypeName(obj))
+	return r.newUndefined(&hint, obj, attr, errors.NewSecurityError)
+}
+
+// checkCallable returns a `SecurityError` if calling obj is unsafe.
+func (r *renderer) checkCallable(obj any) error {
+	s := r.env.sandbox()
+	if s == nil || s.isSafeCallable(obj) {
+		return nil
+	}
+	return errors.NewSecurityError(fmt.Sprintf("%s is not safely callable", runtime.Repr(obj)))
+}
+
+// MaxPowerBits is the maximum estimated size in bits of integer powers
+// computed by `DefaultCallBinop`.
+const MaxPowerBits = 4096
+
+// MaxRepeatLength is the maximum length of strings and lists repeated with
+// "*" by `DefaultCallBinop`.
+const MaxRepeatLength = 100000
+
+// operatorSymbols maps the operators of the nodes to their symbols.
+var operatorSymbols = map[string]string{
+	lexer.TokenAdd:      "+",
+	lexer.TokenSub:      "-",
+	lexer.TokenMul:      "*",
+	lexer.TokenDiv:      "/",
+	lexer.TokenFloordiv: "//",
+	lexer.TokenMod:      "%",
+	lexer.TokenPow:      "**",
+	lexer.TokenTilde:    "~",
+}
+
+var symbolBinops = map[string]func(a, b any) (any, error){}
+
+var symbolUnops = map[string]func(a any) (any, error){}
+
+func init() {
+	for op, fn := range binaryOperators {
+		symbolBinops[operatorSymbols[op]] = fn
+	}
+	for op, fn := range unaryOperators {
+		symbolUnops[operatorSymbols[op]] = fn
+	}
+}
+
+// DefaultCallBinop evaluates the binary operator. Integer powers larger than
+// `MaxPowerBits` and strings or lists repeated to more than `MaxRepeatLength`
+// items raise a `SecurityError`.
+func DefaultCallBinop(ctx *runtime.Context, op string, left, right any) (any, error) {
+	switch op {
+	case "~":
+		return concat(ctx, []any{left, right})
+	case "**":
+		if base, ok := runtime.ToBigInt(left); ok {
+			if exp, ok := runtime.ToBigInt(right); ok && exp.Sign() > 0 && base.CmpAbs(big.NewInt(1)) > 0 {
+				if !exp.IsInt64() || exp.Int64() > MaxPowerBits || int64(base.BitLen())*exp.Int64() > MaxPowerBits {
+					return nil, errors.NewSecurityError("the power operation is too large")
+				}
+			}
+		}
+	case "*":
+		if err := checkRepeat(left, right); err != nil {
+			return nil, err
+		}
+		if err := checkRepeat(right, left); err != nil {
+			return nil, err
+		}
+	}
+	fn, ok := symbolBinops[op]
+	if !ok {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("unknown binary operator %q", op))
+	}
+	return fn(left, right)
+}
+
+// DefaultCallUnop evaluates the unary operator.
+func DefaultCallUnop(_ *runtime.Context, op string, operand any) (any, error) {
+	fn, ok := symbolUnops[op]
+	if !ok {
+		return nil, errors.NewTemplateRuntimeError(fmt.Sprintf("unknown unary operator %q", op))
+	}
+	return fn(operand)
+}
+
+// checkRepeat fails if the sequence repeated n times is too long. Counts that
+// don't fit into an int64 are always too large.
+func checkRepeat(seq any, n any) error {
+	count, ok := runtime.ToBigInt(n)
+	if !ok || count.Sign() <= 0 {
+		return nil
+	}
+	var length int
+	switch v := seq.(type) {
+	case string:
+		length = len(v)
+	case runtime.Markup:
+		length = len(v)
+	default:
+		rv := reflect.ValueOf(runtime.DerefList(seq))
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil
+		}
+		length = rv.Len()
+	}
+	if !count.IsInt64() || (length > 0 && count.Int64() > MaxRepeatLength/int64(length)) {
+		return errors.NewSecurityError(fmt.Sprintf("the repeated sequence is longer than %d", MaxRepeatLength))
+	}
+	return nil
+}
+
+// callBinop evaluates the binary operator, going through the sandbox if it
+// intercepts it.
+func (r *renderer) callBinop(op string, left, right any) (any, error) {
+	s := r.env.sandbox()
+	symbol := operatorSymbols[op]
+	if s == nil || !s.InterceptedBinops.Has(symbol) {
+		return binaryOperators[op](left, right)
+	}
+	if s.CallBinop != nil {
+		return s.CallBinop(r.ctx, symbol, left, right)
+	}
+	return DefaultCallBinop(r.ctx, symbol, left, right)
+}
+
+// callUnop evaluates the unary operator, going through the sandbox if it
+// intercepts it.
+func (r *renderer) callUnop(op string, operand any) (any, error) {
+	s := r.env.sandbox()
+	symbol := operatorSymbols[op]
+	if s == nil || !s.InterceptedUnops.Has(symbol) {
+		return unaryOperators[op](operand)
+	}
+	if s.CallUnop != nil {
+		return s.CallUnop(r.ctx, symbol, operand)
+	}
+	return DefaultCallUnop(r.ctx, symbol, operand)
+}
+
+// interceptsConcat reports whether concatenation goes through the sandbox.
+func (r *renderer) interceptsConcat() bool {
+	s := r.env.sandbox()
+	return s != nil && s.InterceptedBinops.Has("~")
+}
@@ -0,0 +1,272 @@
+package environment
+
+import (
+	stdErrors "errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/runtime"
+	"github.com/gojinja/gojinja/src/utils/set"
+)
+
+type sandboxUser struct {
+	Name     string
+	password string
+}
+
+func (u *sandboxUser) Greeting() string {
+	return "Hello " + u.Name
+}
+
+func (u *sandboxUser) Delete() string {
+	return "deleted"
+}
+
+// sandboxRecord resolves attributes with its own `GetAttr` method.
+type sandboxRecord struct{}
+
+func (sandboxRecord) GetAttr(name string) (any, error) {
+	return "attr " + name, nil
+}
+
+func (sandboxRecord) Delete() string {
+	return "deleted"
+}
+
+func newSandboxedEnv(t *testing.T, sandbox *Sandbox) *Environment {
+	opts := DefaultEnvOpts()
+	opts.Sandbox = sandbox
+	env, err := NewSandboxed(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func renderSandboxed(t *testing.T, env *Environment, source string, vars map[string]any) (string, error) {
+	tmpl, err := env.FromString(source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl.Render(vars)
+}
+
+func TestSandbox(t *testing.T) {
+	user := &sandboxUser{Name: "Ann", password: "secret"}
+	vars := map[string]any{
+		"user":   user,
+		"items":  []any{1, 2},
+		"data":   map[string]any{"a": 1},
+		"unsafe": Unsafe(func() string { return "boom" }),
+		"record": sandboxRecord{},
+	}
+
+	env := newSandboxedEnv(t, nil)
+	if !env.Sandboxed {
+		t.Fatal("expected a sandboxed environment")
+	}
+	runRenderCases(t, env, []renderCase{
+		{"{{ user.Name }}", vars, "Ann"},
+		{"{{ user['Name'] }}", vars, "Ann"},
+		{"{{ user.password }}", vars, ""},
+		{"{{ items.index(2) }}{{ data.get('a') }}", vars, "11"},
+		{"{% for i in items %}{{ loop.index }}{% endfor %}", vars, "12"},
+		{"{% set ns = namespace(a=1) %}{% set ns.b = 2 %}{{ ns.a }}{{ ns.b }}{{ cycler(1, 2).next() }}", vars, "121"},
+		{"{% macro m() %}{% endmacro %}{{ m.name }}", vars, "m"},
+		{"{{ record.title }}", vars, "attr title"},
+	})
+
+	for _, source := range []string{
+		"{{ user.Greeting() }}",
+		"{{ user['Greeting']() }}",
+		"{{ user.Delete() }}",
+		"{{ unsafe() }}",
+		"{% macro m() %}{% endmacro %}{% call unsafe() %}{% endcall %}",
+		"{{ record.Delete() }}",
+	} {
+		_, err := renderSandboxed(t, env, source, vars)
+		var securityErr *errors.SecurityError
+		if !stdErrors.As(err, &securityErr) {
+			t.Errorf("%q: expected security error, got %v", source, err)
+		}
+		var runtimeErr *errors.TemplateRuntimeError
+		if !stdErrors.As(err, &runtimeErr) {
+			t.Errorf("%q: expected security error to be a runtime error, got %v", source, err)
+		}
+	}
+	_, err := renderSandboxed(t, env, "{{ user.Delete() }}", vars)
+	if err == nil || !strings.Contains(err.Error(), "access to attribute 'Delete' of 'sandboxUser' object is unsafe.") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	sandbox := NewSandbox()
+	sandbox.AllowMethods(sandboxUser{}, "Greeting")
+	env = newSandboxedEnv(t, sandbox)
+	runRenderCases(t, env, []renderCase{
+		{"{{ user.Greeting() }}", vars, "Hello Ann"},
+	})
+	if _, err := renderSandboxed(t, env, "{{ user.Delete() }}", vars); err == nil {
+		t.Error("expected methods that aren't allowed to be blocked")
+	}
+
+	sandbox = &Sandbox{
+		IsSafeAttribute: func(obj any, attr string, value any) bool { return attr != "Name" },
+		IsSafeCallable:  func(obj any) bool { return true },
+	}
+	env = newSandboxedEnv(t, sandbox)
+	runRenderCases(t, env, []renderCase{
+		{"{{ user.Greeting() }}{{ unsafe() }}", vars, "Hello Annboom"},
+	})
+	if _, err := renderSandboxed(t, env, "{{ user.Name.upper }}", vars); err == nil {
+		t.Error("expected custom attribute policy to be applied")
+	}
+
+	// without the sandbox everything is accessible
+	runRenderCases(t, newTestEnv(t, nil), []renderCase{
+		{"{{ user.Delete() }}{{ unsafe() }}", vars, "deletedboom"},
+	})
+}
+
+type sandboxCounter struct {
+	N int
+}
+
+func (c *sandboxCounter) Inc() int {
+	c.N++
+	return c.N
+}
+
+func (c *sandboxCounter) Get() int {
+	return c.N
+}
+
+func TestImmutableSandbox(t *testing.T) {
+	items := []any{1, 2}
+	data := map[string]any{"a": 1}
+	counter := &sandboxCounter{N: 1}
+	vars := map[string]any{"items": &items, "data": data, "counter": counter}
+
+	sandbox := NewSandbox()
+	sandbox.AllowMethods(counter, "Inc", "Get")
+	sandbox.RegisterMutable(counter, "Inc")
+	opts := DefaultEnvOpts()
+	opts.Sandbox = sandbox
+	env, err := NewImmutableSandboxed(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sandbox.Immutable {
+		t.Fatal("the sandbox of the options must not be modified")
+	}
+
+	runRenderCases(t, env, []renderCase{
+		{"{{ items.index(2) }}{{ items.count(1) }}{{ data.get('a') }}{{ data['a'] }}{{ counter.Get() }}", vars, "11111"},
+		{"{% for k, v in data.items() %}{{ k }}{{ v }}{% endfor %}", vars, "a1"},
+	})
+	for _, source := range []string{
+		"{{ items.append(3) }}",
+		"{{ items.pop() }}",
+		"{{ data.update(b=2) }}",
+		"{{ data.setdefault('b', 2) }}",
+		"{{ data.clear() }}",
+		"{{ counter.Inc() }}",
+		"{% set xs = [] %}{{ xs.append(1) }}",
+	} {
+		_, err := renderSandboxed(t, env, source, vars)
+		var securityErr *errors.SecurityError
+		if !stdErrors.As(err, &securityErr) {
+			t.Errorf("%q: expected security error, got %v", source, err)
+		}
+	}
+	if _, err := renderSandboxed(t, env, "{% set ns = data %}{% set ns.b = 2 %}", vars); err == nil {
+		t.Error("expected assigning to map attributes to fail")
+	}
+	if len(items) != 2 || len(data) != 1 || counter.N != 1 {
+		t.Fatalf("the values were modified: %v %v %v", items, data, counter.N)
+	}
+
+	// the mutable sandbox allows modifications
+	opts.Sandbox = sandbox
+	env, err = NewSandboxed(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderCases(t, env, []renderCase{
+		{"{{ items.append(3) }}{{ data.update(b=2) }}{{ counter.Inc() }}", vars, "NoneNone2"},
+	})
+	if len(items) != 3 || len(data) != 2 {
+		t.Fatalf("the values weren't modified: %v %v", items, data)
+	}
+}
+
+func TestSandboxIntercepts(t *testing.T) {
+	env := newSandboxedEnv(t, nil)
+	runRenderCases(t, env, []renderCase{
+		{"{{ 2 ** 10 }}{{ 2 ** -1 }}{{ 1 ** 100000 }}", nil, "10240.51"},
+		{"{{ 3 * 4 }}{{ -(2) }}", nil, "12-2"},
+		{"{{ range(0, 9223372036854775807, 4611686018427387904) }}", nil, "[0, 4611686018427387904]"},
+	})
+	for _, source := range []string{
+		"{{ 2 ** 10000 }}", "{{ 2 ** 1000000000 }}", "{{ (2 ** 100) ** 100 }}",
+		"{{ 'a' * 100001 }}", "{{ 'a' * (2 ** 64) }}", "{{ (2 ** 64) * [1] }}", "{{ 'ab' * 4611686018427387904 }}",
+	} {
+		_, err := renderSandboxed(t, env, source, nil)
+		var securityErr *errors.SecurityError
+		if !stdErrors.As(err, &securityErr) {
+			t.Errorf("%q: expected security error, got %v", source, err)
+		}
+	}
+	for _, source := range []string{
+		"{{ range(0, 9223372036854775807, 92233720368547) }}",
+		"{{ range(-9223372036854775807, 9223372036854775807) }}",
+		"{{ range(9223372036854775807, -9223372036854775807, -1) }}",
+	} {
+		_, err := renderSandboxed(t, env, source, nil)
+		var limitErr *errors.LimitExceededError
+		if !stdErrors.As(err, &limitErr) {
+			t.Errorf("%q: expected range limit error, got %v", source, err)
+		}
+	}
+	if err := checkRepeat("ab", int64(MaxRepeatLength)); err == nil {
+		t.Error("expected repeated string to be too long")
+	}
+	if err := checkRepeat("", new(big.Int).Lsh(big.NewInt(1), 64)); err == nil {
+		t.Error("expected repeat count to be too large")
+	}
+	if err := checkRepeat([]any{1}, int64(MaxRepeatLength)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	var calls []string
+	sandbox := NewSandbox()
+	sandbox.InterceptedBinops = set.FromElems("+", "~")
+	sandbox.InterceptedUnops = set.FromElems("-")
+	sandbox.CallBinop = func(ctx *runtime.Context, op string, left, right any) (any, error) {
+		calls = append(calls, op)
+		if op == "+" {
+			return nil, errors.NewSecurityError("no adding")
+		}
+		return DefaultCallBinop(ctx, op, left, right)
+	}
+	sandbox.CallUnop = func(ctx *runtime.Context, op string, operand any) (any, error) {
+		calls = append(calls, "unary"+op)
+		return DefaultCallUnop(ctx, op, operand)
+	}
+	env = newSandboxedEnv(t, sandbox)
+	runRenderCases(t, env, []renderCase{
+		{"{{ 'a' ~ 1 ~ 'b' }}{{ -1 }}{{ 2 * 3 }}", nil, "a1b-16"},
+	})
+	if strings.Join(calls, ",") != "~,~,unary-" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	if _, err := renderSandboxed(t, env, "{{ 1 + 2 }}", nil); err == nil || !strings.Contains(err.Error(), "no adding") {
+		t.Fatalf("expected intercepted error, got %v", err)
+	}
+
+	// without the sandbox operators aren't intercepted
+	runRenderCases(t, newTestEnv(t, nil), []renderCase{
+		{"{{ 2 ** 10 }}", nil, "1024"},
+	})
+}
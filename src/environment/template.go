@@ -1,16 +1,222 @@
 package environment
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/meta"
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/runtime"
+	"github.com/gojinja/gojinja/src/utils/maps"
+)
+
 type Class struct{}
 
-type Template struct{}
+// Template is a compiled template that can be rendered.
+type Template struct {
+	env      *Environment
+	name     *string
+	filename *string
+	globals  map[string]any
+	upToDate UpToDate
+	ast      *nodes.Template
+	blocks   map[string]*nodes.Block
+}
 
+// ITemplate is a template returned by the loaders and `GetTemplate`.
 type ITemplate interface {
 	IsUpToDate() bool
 	Globals() map[string]any
+	Render(vars map[string]any) (string, error)
+	RenderTo(w io.Writer, vars map[string]any) error
+	RenderContext(ctx context.Context, vars map[string]any) (string, error)
+	RenderContextTo(ctx context.Context, w io.Writer, vars map[string]any) error
+	RenderAudit(ctx context.Context, vars map[string]any) (string, *runtime.UndefinedReport, error)
 }
 
 type UpToDate = func() bool
 
-func (Class) FromSource(env *Environment, source string, filename *string, globals map[string]any, upToData UpToDate) (ITemplate, error) {
-	panic("TODO implemented")
+var _ ITemplate = &Template{}
+
+// FromSource compiles the source and creates a template from it.
+func (Class) FromSource(env *Environment, source string, name *string, filename *string, globals map[string]any, upToDate UpToDate) (*Template, error) {
+	ast, err := env.parse(source, name, filename)
+	if err != nil {
+		return nil, err
+	}
+	return newTemplate(env, ast, name, filename, globals, upToDate)
+}
+
+func newTemplate(env *Environment, ast *nodes.Template, name *string, filename *string, globals map[string]any, upToDate UpToDate) (*Template, error) {
+	blocks, err := findBlocks(ast, name, filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLoopControls(ast, false, name, filename); err != nil {
+		return nil, err
+	}
+	return &Template{
+		env:      env,
+		name:     name,
+		filename: filename,
+		globals:  globals,
+		upToDate: upToDate,
+		ast:      ast,
+		blocks:   blocks,
+	}, nil
+}
+
+// checkLoopControls checks that `break` and `continue` are only used inside
+// the body of a loop. Blocks, macros and call blocks are rendered on their
+// own, so they can't control a loop they are placed in.
+func checkLoopControls(node nodes.Node, inLoop bool, name *string, filename *string) error {
+	var body []nodes.Node
+	switch n := node.(type) {
+	case *nodes.Break:
+		if !inLoop {
+			return errors.NewTemplateSyntaxError("'break' outside of a loop", n.Lineno, name, filename)
+		}
+		return nil
+	case *nodes.Continue:
+		if !inLoop {
+			return errors.NewTemplateSyntaxError("'continue' outside of a loop", n.Lineno, name, filename)
+		}
+		return nil
+	case *nodes.For:
+		for _, child := range n.Body {
+			if err := checkLoopControls(child, true, name, filename); err != nil {
+				return err
+			}
+		}
+		body = n.Else
+	case *nodes.Block:
+		body, inLoop = n.Body, false
+	case *nodes.Macro:
+		body, inLoop = n.Body, false
+	case *nodes.CallBlock:
+		body, inLoop = n.Body, false
+	default:
+		body = nodes.IterChildNodes(node)
+	}
+	for _, child := range body {
+		if err := checkLoopControls(child, inLoop, name, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBlocks collects all blocks defined in the template.
+func findBlocks(ast *nodes.Template, name *string, filename *string) (map[string]*nodes.Block, error) {
+	blocks := make(map[string]*nodes.Block)
+	var err error
+	nodes.Walk(ast, func(node nodes.Node) bool {
+		block, ok := node.(*nodes.Block)
+		if !ok || err != nil {
+			return err == nil
+		}
+		if _, ok := blocks[block.Name]; ok {
+			err = errors.NewTemplateSyntaxError(fmt.Sprintf("block '%s' defined twice", block.Name), block.Lineno, name, filename)
+			return false
+		}
+		blocks[block.Name] = block
+		return true
+	})
+	return blocks, err
+}
+
+// IsUpToDate reports whether the template source didn't change since the template was loaded.
+// Templates without an up-to-date function are always up to date.
+func (t *Template) IsUpToDate() bool {
+	if t.upToDate == nil {
+		return true
+	}
+	return t.upToDate()
+}
+
+func (t *Template) Globals() map[string]any {
+	return t.globals
+}
+
+// Name returns the loading name of the template or nil if the template wasn't loaded by name.
+func (t *Template) Name() *string {
+	return t.name
+}
+
+// Filename returns the filename of the template on the file system or nil if the template wasn't loaded from there.
+func (t *Template) Filename() *string {
+	return t.filename
+}
+
+// ReferencedTemplates returns the names of templates this template extends, includes or imports.
+func (t *Template) ReferencedTemplates() []string {
+	return meta.FindReferencedTemplates(t.ast)
+}
+
+// Render renders the template with the given variables and returns the output.
+func (t *Template) Render(vars map[string]any) (string, error) {
+	var b strings.Builder
+	if err := t.RenderTo(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RenderTo renders the template with the given variables into w.
+func (t *Template) RenderTo(w io.Writer, vars map[string]any) error {
+	return t.RenderContextTo(context.Background(), w, vars)
+}
+
+// RenderContext renders the template like `Render`, but aborts the render
+// with a `CanceledError` once ctx is canceled or its deadline passed.
+func (t *Template) RenderContext(ctx context.Context, vars map[string]any) (string, error) {
+	var b strings.Builder
+	if err := t.RenderContextTo(ctx, &b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RenderContextTo renders the template like `RenderTo`, but aborts the
+// render with a `CanceledError` once ctx is canceled or its deadline passed.
+func (t *Template) RenderContextTo(ctx context.Context, w io.Writer, vars map[string]any) error {
+	return t.render(w, vars, t.env.newRenderState(ctx))
+}
+
+// RenderAudit renders the template like `RenderContext` and also returns
+// the undefined variables, attributes and items looked up during the render,
+// e.g. to check that fixture data covers all variables. Values tested with
+// `defined` or `undefined` or passed to the `default` filter aren't reported.
+func (t *Template) RenderAudit(ctx context.Context, vars map[string]any) (string, *runtime.UndefinedReport, error) {
+	state := t.env.newRenderState(ctx)
+	state.Undefined = &runtime.UndefinedReport{}
+	var b strings.Builder
+	if err := t.render(&b, vars, state); err != nil {
+		return "", state.Undefined, err
+	}
+	return b.String(), state.Undefined, nil
+}
+
+func (t *Template) render(w io.Writer, vars map[string]any, state *runtime.RenderState) error {
+	tmplCtx := t.NewContext(vars)
+	tmplCtx.State = state
+	return newRenderer(t, tmplCtx).renderRoot(limitOutput(w, state))
+}
+
+// NewContext creates a new template context for this template. The variables
+// passed take precedence over the globals of the template.
+func (t *Template) NewContext(vars map[string]any) *runtime.Context {
+	blocks := make(map[string][]runtime.BlockFunc, len(t.blocks))
+	for name, block := range t.blocks {
+		blocks[name] = []runtime.BlockFunc{t.blockFunc(block, 0)}
+	}
+	name := ""
+	if t.name != nil {
+		name = *t.name
+	}
+	evalCtx := &runtime.EvalContext{AutoEscape: t.env.AutoEscape != nil && t.env.AutoEscape(name)}
+	return runtime.NewContext(maps.Chain(vars, t.globals), t.name, blocks, evalCtx)
 }
@@ -0,0 +1,155 @@
+"""Generates conformance.json, the expected output of templates rendered by Jinja.
+
+Run it from this directory with `python3 conformance.py`, jinja2 must be
+installed. The version of jinja2 used is recorded in the file. Failing cases
+record the name of the exception class raised.
+"""
+
+import json
+import sys
+
+try:
+    import jinja2
+except ImportError:
+    sys.exit("jinja2 is required to generate conformance.json, install it with `pip install jinja2`")
+
+CASES = [
+    # integer and float division
+    ("{{ 7 // 2 }}", {}),
+    ("{{ -7 // 2 }}", {}),
+    ("{{ 7 // -2 }}", {}),
+    ("{{ -7 // -2 }}", {}),
+    ("{{ 7 % 3 }}", {}),
+    ("{{ -7 % 3 }}", {}),
+    ("{{ 7 % -3 }}", {}),
+    ("{{ -7 % -3 }}", {}),
+    ("{{ 7.5 // 2 }}", {}),
+    ("{{ -7.5 // 2 }}", {}),
+    ("{{ -7.5 % 2 }}", {}),
+    ("{{ 7.5 % -2 }}", {}),
+    ("{{ 1 / 2 }}", {}),
+    ("{{ 4 / 2 }}", {}),
+    ("{{ -1 / 3 }}", {}),
+    ("{{ 7 / 7 }}", {}),
+    ("{{ 1 // 0 }}", {}),
+    ("{{ 1 % 0 }}", {}),
+    ("{{ 1 / 0 }}", {}),
+    ("{{ 1.0 // 0.0 }}", {}),
+    # mixed int and float
+    ("{{ 1 + 1.5 }}", {}),
+    ("{{ 3 - 0.5 }}", {}),
+    ("{{ 2 * 2.5 }}", {}),
+    ("{{ 0.1 + 0.2 }}", {}),
+    ("{{ 1 == 1.0 }}", {}),
+    ("{{ 1 != 1.0 }}", {}),
+    ("{{ 2 ** 10 }}", {}),
+    ("{{ 2 ** -1 }}", {}),
+    ("{{ 2 ** 0.5 }}", {}),
+    ("{{ 0 ** -1 }}", {}),
+    ("{{ x * 2 }}", {"x": 0.25}),
+    ("{{ x // y }}", {"x": -9, "y": 4}),
+    ("{{ x % y }}", {"x": -9, "y": 4}),
+    # big integers
+    ("{{ 2 ** 100 }}", {}),
+    ("{{ 10 ** 20 // 3 }}", {}),
+    ("{{ -(2 ** 63) }}", {}),
+    ("{{ 9223372036854775807 + 1 }}", {}),
+    ("{{ -9223372036854775807 - 2 }}", {}),
+    ("{{ 3037000500 * 3037000500 }}", {}),
+    ("{{ 2 ** 64 % 7 }}", {}),
+    ("{{ -(2 ** 64) // 3 }}", {}),
+    ("{{ -(2 ** 64) % 3 }}", {}),
+    ("{{ 2 ** 64 / 2 }}", {}),
+    ("{{ 2 ** 64 + 0.5 }}", {}),
+    ("{{ 2 ** 64 - 2 ** 64 }}", {}),
+    ("{{ 2 ** 64 > 2 ** 63 }}", {}),
+    ("{{ 2 ** 64 == 18446744073709551616.0 }}", {}),
+    ("{{ x + 1 }}", {"x": 9223372036854775807}),
+    # booleans are integers
+    ("{{ True + 1 }}", {}),
+    ("{{ True * 3 }}", {}),
+    ("{{ -True }}", {}),
+    ("{{ True == 1 }}", {}),
+    ("{{ +5 }}", {}),
+    ("{{ -(-5) }}", {}),
+    # sequences
+    ("{{ 'ab' * 3 }}", {}),
+    ("{{ 3 * 'ab' }}", {}),
+    ("{{ 'ab' * 0 }}", {}),
+    ("{{ 'ab' * -1 }}", {}),
+    ("{{ 'a' + 'b' }}", {}),
+    ("{{ [1] + [2] }}", {}),
+    ("{{ [1, 2] * 2 }}", {}),
+    ("{{ 2 * [0] }}", {}),
+    ("{{ [1, 'a'] + ['b'] }}", {}),
+    ("{{ x + y }}", {"x": [1], "y": [2, 3]}),
+    ("{{ [1] + 'a' }}", {}),
+    ("{{ 'a' + 1 }}", {}),
+    ("{{ 'a' * 1.5 }}", {}),
+    # comparisons
+    ("{{ 1 < 2 < 3 }}", {}),
+    ("{{ 1 < 3 < 2 }}", {}),
+    ("{{ 3 > 2 > 1 }}", {}),
+    ("{{ 1 == 1 == 1 }}", {}),
+    ("{{ 1 == 1 != 2 }}", {}),
+    ("{{ 1 < x < 3 }}", {"x": 2}),
+    ("{{ 1 < x < 3 }}", {"x": 3}),
+    ("{{ 1 < x <= 3 }}", {"x": 3}),
+    ("{{ 'a' < 'b' }}", {}),
+    ("{{ 'abc' < 'abd' }}", {}),
+    ("{{ [1, 2] < [1, 3] }}", {}),
+    ("{{ [1, 2] < [1, 2, 0] }}", {}),
+    ("{{ [1, 2] == [1, 2] }}", {}),
+    ("{{ [1, 2] >= [1, 2] }}", {}),
+    ("{{ 1 == '1' }}", {}),
+    ("{{ None == None }}", {}),
+    ("{{ 1 < 'a' }}", {}),
+    ("{{ 2 in [1, 2] }}", {}),
+    ("{{ 'b' in 'abc' }}", {}),
+    ("{{ 3 not in [1, 2] }}", {}),
+    ("{{ 'a' in {'a': 1} }}", {}),
+    ("{{ 1.0 in [1, 2] }}", {}),
+    # truthiness
+    ("{{ 'y' if x else 'n' }}", {"x": 0}),
+    ("{{ 'y' if x else 'n' }}", {"x": 0.0}),
+    ("{{ 'y' if x else 'n' }}", {"x": 1}),
+    ("{{ 'y' if x else 'n' }}", {"x": -1}),
+    ("{{ 'y' if x else 'n' }}", {"x": 0.1}),
+    ("{{ 'y' if x else 'n' }}", {"x": ""}),
+    ("{{ 'y' if x else 'n' }}", {"x": "a"}),
+    ("{{ 'y' if x else 'n' }}", {"x": []}),
+    ("{{ 'y' if x else 'n' }}", {"x": [0]}),
+    ("{{ 'y' if x else 'n' }}", {"x": {}}),
+    ("{{ 'y' if x else 'n' }}", {"x": {"a": 1}}),
+    ("{{ 'y' if x else 'n' }}", {"x": None}),
+    ("{{ 'y' if x else 'n' }}", {"x": False}),
+    ("{{ 'y' if 2 ** 64 else 'n' }}", {}),
+    ("{{ not [] }}", {}),
+    ("{{ not 'a' }}", {}),
+    ("{{ [] or 'empty' }}", {}),
+    ("{{ 0 and 1 }}", {}),
+    ("{{ '' or 0 or [] or 'last' }}", {}),
+    ("{{ 1 and 'both' }}", {}),
+]
+
+
+def render(template, variables):
+    return jinja2.Environment().from_string(template).render(**variables)
+
+
+def main():
+    cases = []
+    for template, variables in CASES:
+        case = {"template": template, "vars": variables}
+        try:
+            case["output"] = render(template, variables)
+        except Exception as e:
+            case["error"] = type(e).__name__
+        cases.append(case)
+    with open("conformance.json", "w") as f:
+        json.dump({"generator": f"jinja2 {jinja2.__version__}", "cases": cases}, f, indent=1)
+        f.write("\n")
+
+
+if __name__ == "__main__":
+    main()
@@ -185,8 +185,14 @@ func testNumber(_ *Environment, value any, _ ...any) (bool, error) {
 }
 
 func testSequence(_ *Environment, value any, _ ...any) (bool, error) {
-	// TODO rewrite using operator len and getitem
-	switch reflect.TypeOf(value).Kind() {
+	// sequences have a length and items
+	if _, err := runtime.Len(value); err != nil {
+		return false, nil
+	}
+	if _, ok := value.(runtime.GetItemOp); ok {
+		return true, nil
+	}
+	switch reflect.TypeOf(runtime.DerefList(value)).Kind() {
 	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
 		return true, nil
 	default:
@@ -195,8 +201,10 @@ func testSequence(_ *Environment, value any, _ ...any) (bool, error) {
 }
 
 func testCallable(_ *Environment, value any, _ ...any) (bool, error) {
-	// TODO rewrite using operator call
-	return reflect.TypeOf(value).Kind() == reflect.Func, nil
+	if _, ok := value.(runtime.CallOp); ok {
+		return true, nil
+	}
+	return value != nil && reflect.TypeOf(value).Kind() == reflect.Func, nil
 }
 
 func testSameAs(_ *Environment, value any, values ...any) (bool, error) {
@@ -219,8 +227,13 @@ func testSameAs(_ *Environment, value any, values ...any) (bool, error) {
 }
 
 func testIterable(_ *Environment, value any, _ ...any) (bool, error) {
-	// TODO rewrite using operator iter
-	switch reflect.TypeOf(value).Kind() {
+	switch value.(type) {
+	case nil:
+		return false, nil
+	case runtime.IterOp, runtime.Iterator:
+		return true, nil
+	}
+	switch reflect.TypeOf(runtime.DerefList(value)).Kind() {
 	case reflect.Slice, reflect.Array, reflect.Map, reflect.String, reflect.Chan:
 		return true, nil
 	default:
@@ -228,9 +241,7 @@ func testIterable(_ *Environment, value any, _ ...any) (bool, error) {
 	}
 }
 
-type Escaped interface {
-	HTML() (string, error)
-}
+type Escaped = runtime.Escaped
 
 func testEscaped(_ *Environment, value any, _ ...any) (bool, error) {
 	_, ok := value.(Escaped)
@@ -238,35 +249,37 @@ func testEscaped(_ *Environment, value any, _ ...any) (bool, error) {
 }
 
 func testIn(_ *Environment, value any, values ...any) (bool, error) {
-	// TODO rewrite using operator contains
 	if len(values) == 0 {
 		return false, fmt.Errorf("not enough values passed to the function")
 	}
+	return runtime.Contains(values[0], value)
+}
 
-	switch reflect.TypeOf(values[0]).Kind() {
-	case reflect.Slice:
-		s := reflect.ValueOf(values[0])
-		for i := 0; i < s.Len(); i++ {
-			if value == s.Index(i).Interface() {
-				return true, nil
-			}
-		}
-		return false, nil
-	case reflect.Map:
-		s := reflect.ValueOf(values[0])
-		v := s.MapIndex(reflect.ValueOf(value))
-		return v != reflect.Value{}, nil
-	case reflect.String:
-		v := values[0].(string)
-		if vS, ok := value.(string); ok {
-			return strings.Contains(v, vS), nil
+// compareTest returns a test comparing the value to the argument.
+func compareTest(compare func(a, b any) (bool, error)) Test {
+	return func(_ *Environment, value any, values ...any) (bool, error) {
+		if len(values) == 0 {
+			return false, fmt.Errorf("not enough values passed to the function")
 		}
-		return false, nil
-	default:
-		return false, fmt.Errorf("second arguemnt is not a slice")
+		return compare(value, values[0])
 	}
 }
 
+func ordering(op string) func(a, b any) (bool, error) {
+	return func(a, b any) (bool, error) {
+		return runtime.Compare(op, a, b)
+	}
+}
+
+var (
+	testEq = compareTest(runtime.Eq)
+	testNe = compareTest(runtime.Ne)
+	testLt = compareTest(ordering("lt"))
+	testLe = compareTest(ordering("lteq"))
+	testGt = compareTest(ordering("gt"))
+	testGe = compareTest(ordering("gteq"))
+)
+
 // Test represents a test function. Some tests only require one variable
 type Test func(env *Environment, firstArg any, args ...any) (bool, error)
 
@@ -295,5 +308,19 @@ var Default = map[string]Test{
 	"callable":    testCallable,
 	"sameas":      testSameAs,
 	"escaped":     testEscaped,
-	// TODO operators
+	"==":          testEq,
+	"eq":          testEq,
+	"equalto":     testEq,
+	"!=":          testNe,
+	"ne":          testNe,
+	">":           testGt,
+	"gt":          testGt,
+	"greaterthan": testGt,
+	">=":          testGe,
+	"ge":          testGe,
+	"<":           testLt,
+	"lt":          testLt,
+	"lessthan":    testLt,
+	"<=":          testLe,
+	"le":          testLe,
 }
@@ -56,10 +56,10 @@ func TestDefined(t *testing.T) {
 	runTestCases(t, testDefined, []testCase{
 		{nil, 0, nil, true, false},
 		{nil, "", nil, true, false},
-		{nil, runtime.NewUndefined(nil, nil, nil, nil, nil), nil, false, false},
-		{nil, runtime.NewChainableUndefined(nil, nil, nil, nil, nil), nil, false, false},
-		{nil, runtime.NewStrictUndefined(nil, nil, nil, nil, nil), nil, false, false},
-		{nil, runtime.NewDebugUndefined(nil, nil, nil, nil, nil), nil, false, false},
+		{nil, runtime.NewUndefined(nil, nil, nil, nil), nil, false, false},
+		{nil, runtime.NewChainableUndefined(nil, nil, nil, nil), nil, false, false},
+		{nil, runtime.NewStrictUndefined(nil, nil, nil, nil), nil, false, false},
+		{nil, runtime.NewDebugUndefined(nil, nil, nil, nil), nil, false, false},
 	})
 }
 
@@ -67,10 +67,10 @@ func TestUndefined(t *testing.T) {
 	runTestCases(t, testUndefined, []testCase{
 		{nil, 0, nil, false, false},
 		{nil, "", nil, false, false},
-		{nil, runtime.NewUndefined(nil, nil, nil, nil, nil), nil, true, false},
-		{nil, runtime.NewChainableUndefined(nil, nil, nil, nil, nil), nil, true, false},
-		{nil, runtime.NewStrictUndefined(nil, nil, nil, nil, nil), nil, true, false},
-		{nil, runtime.NewDebugUndefined(nil, nil, nil, nil, nil), nil, true, false},
+		{nil, runtime.NewUndefined(nil, nil, nil, nil), nil, true, false},
+		{nil, runtime.NewChainableUndefined(nil, nil, nil, nil), nil, true, false},
+		{nil, runtime.NewStrictUndefined(nil, nil, nil, nil), nil, true, false},
+		{nil, runtime.NewDebugUndefined(nil, nil, nil, nil), nil, true, false},
 	})
 }
 
@@ -339,7 +339,7 @@ func TestIn(t *testing.T) {
 		{nil, "bar", []any{[]string{"foo", "bar"}}, true, false},
 		{nil, "", []any{[]string{"foo", "bar"}}, false, false},
 		{nil, "a", []any{"Ala"}, true, false},
-		{nil, 'a', []any{"Ala"}, false, false},
+		{nil, 'a', []any{"Ala"}, false, true},
 		{nil, "o", []any{"Ala"}, false, false},
 		{nil, 'o', []any{0}, false, true},
 		{nil, "o", []any{map[string]string{"o": "o"}}, true, false},
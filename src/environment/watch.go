@@ -0,0 +1,173 @@
+package environment
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gojinja/gojinja/src/utils/set"
+)
+
+// watcher keeps track of dependencies between loaded templates and, once started,
+// polls the cached templates for changes in the background.
+type watcher struct {
+	mu         sync.Mutex
+	stop       chan struct{}
+	hooks      []func(name string)
+	dependents map[string]set.Set[string]
+	references map[string][]string
+}
+
+func newWatcher() *watcher {
+	return &watcher{dependents: make(map[string]set.Set[string]), references: make(map[string][]string)}
+}
+
+func (w *watcher) running() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stop != nil
+}
+
+// trackDependencies records that the template `name` depends on all templates
+// it extends, includes or imports. The dependencies recorded when the
+// template was loaded before are replaced.
+func (w *watcher) trackDependencies(name string, template ITemplate) {
+	t, ok := template.(interface{ ReferencedTemplates() []string })
+	if !ok {
+		return
+	}
+	refs := t.ReferencedTemplates()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ref := range w.references[name] {
+		if deps := w.dependents[ref]; deps != nil {
+			deps.Remove(name)
+			if len(deps) == 0 {
+				delete(w.dependents, ref)
+			}
+		}
+	}
+	w.references[name] = refs
+	for _, ref := range refs {
+		if w.dependents[ref] == nil {
+			w.dependents[ref] = set.New[string]()
+		}
+		w.dependents[ref].Add(name)
+	}
+}
+
+// affected returns the name itself followed by all templates that
+// (transitively) depend on it.
+func (w *watcher) affected(name string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seen := set.FromElems(name)
+	res := []string{name}
+	for i := 0; i < len(res); i++ {
+		for dep := range w.dependents[res[i]] {
+			if !seen.Has(dep) {
+				seen.Add(dep)
+				res = append(res, dep)
+			}
+		}
+	}
+	return res
+}
+
+// OnTemplateChanged registers a hook that is called with the name of every
+// template that was invalidated because it (or a template it extends,
+// includes or imports) changed on disk. Hooks are called from the watcher
+// goroutine started with `Watch`.
+func (env *Environment) OnTemplateChanged(hook func(name string)) {
+	env.watcher.mu.Lock()
+	defer env.watcher.mu.Unlock()
+	env.watcher.hooks = append(env.watcher.hooks, hook)
+}
+
+// Watch starts checking the cached templates for changes every `interval`.
+// While watching, `GetTemplate` doesn't check whether templates are up to
+// date, instead changed templates, and everything that extends, includes or
+// imports them, are evicted from the cache by the watcher.
+//
+// The returned function stops watching. Calling `Watch` on an environment that
+// is already watched restarts the watcher with the new interval. The interval
+// must be positive.
+func (env *Environment) Watch(interval time.Duration) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("non-positive interval for Watch: %v", interval)
+	}
+	w := env.watcher
+	w.mu.Lock()
+	if w.stop != nil {
+		close(w.stop)
+	}
+	done := make(chan struct{})
+	w.stop = done
+	w.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				env.checkForChanges()
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if w.stop == done {
+				close(done)
+				w.stop = nil
+			}
+		})
+	}
+	return stop, nil
+}
+
+// checkForChanges invalidates all cached templates that are no longer up to date.
+func (env *Environment) checkForChanges() {
+	if env.Cache == nil {
+		return
+	}
+	for _, k := range env.Cache.Keys() {
+		key, ok := k.(cacheKey)
+		if !ok {
+			continue
+		}
+		v, ok := env.Cache.Peek(key)
+		if !ok {
+			continue
+		}
+		if tmpl, ok := v.(ITemplate); ok && !tmpl.IsUpToDate() {
+			env.invalidate(key.name)
+		}
+	}
+}
+
+// invalidate evicts the template and all templates depending on it from the
+// cache and notifies the registered hooks.
+func (env *Environment) invalidate(name string) {
+	names := env.watcher.affected(name)
+	for _, n := range names {
+		if env.Cache != nil {
+			env.Cache.Remove(cacheKey{env.Loader, n})
+		}
+	}
+
+	env.watcher.mu.Lock()
+	hooks := append([]func(string){}, env.watcher.hooks...)
+	env.watcher.mu.Unlock()
+	for _, n := range names {
+		for _, hook := range hooks {
+			hook(n)
+		}
+	}
+}
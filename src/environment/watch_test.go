@@ -0,0 +1,250 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeTemplates(t *testing.T, dir string, templates map[string]string) {
+	for name, source := range templates {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"base.html":    "base",
+		"child.html":   `{% extends "base.html" %}`,
+		"page.html":    `{% include "child.html" %}`,
+		"partial.html": "partial",
+	})
+
+	opts := DefaultEnvOpts()
+	opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var changed []string
+	notified := make(chan struct{}, 10)
+	env.OnTemplateChanged(func(name string) {
+		mu.Lock()
+		changed = append(changed, name)
+		mu.Unlock()
+		notified <- struct{}{}
+	})
+
+	loaded := make(map[string]ITemplate)
+	for _, name := range []string{"base.html", "child.html", "page.html", "partial.html"} {
+		tmpl, err := env.GetTemplate(name, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded[name] = tmpl
+	}
+
+	stop, err := env.Watch(5 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "base.html"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-notified:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change notifications")
+		}
+	}
+
+	mu.Lock()
+	sort.Strings(changed)
+	got := changed
+	mu.Unlock()
+	expected := []string{"base.html", "child.html", "page.html"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v to be invalidated, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v to be invalidated, got %v", expected, got)
+		}
+	}
+
+	for name, old := range loaded {
+		tmpl, err := env.GetTemplate(name, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "partial.html" && tmpl != old {
+			t.Fatal("unchanged template was reloaded")
+		}
+		if name != "partial.html" && tmpl == old {
+			t.Fatalf("changed template %s wasn't reloaded", name)
+		}
+	}
+}
+
+func TestAutoReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{"index.html": "index"})
+
+	opts := DefaultEnvOpts()
+	opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := env.GetTemplate("index.html", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := env.GetTemplate("index.html", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("expected template to be cached")
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "index.html"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	third, err := env.GetTemplate("index.html", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Fatal("expected changed template to be reloaded")
+	}
+}
+
+func TestWatchIncludeInBlock(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"nav.html":   "nav",
+		"page.html":  `{% extends "base.html" %}{% block body %}{% include "nav.html" %}{% endblock %}`,
+		"base.html":  `{% block body %}{% endblock %}`,
+		"other.html": "other",
+	})
+
+	opts := DefaultEnvOpts()
+	opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notified := make(chan string, 10)
+	env.OnTemplateChanged(func(name string) {
+		notified <- name
+	})
+	for _, name := range []string{"nav.html", "page.html", "other.html"} {
+		if _, err := env.GetTemplate(name, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop, err := env.Watch(5 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "nav.html"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	var changed []string
+	for len(changed) < 2 {
+		select {
+		case name := <-notified:
+			changed = append(changed, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change notifications, got %v", changed)
+		}
+	}
+	sort.Strings(changed)
+	if changed[0] != "nav.html" || changed[1] != "page.html" {
+		t.Fatalf("expected nav.html and page.html to be invalidated, got %v", changed)
+	}
+	select {
+	case name := <-notified:
+		t.Fatalf("unexpected change notification for %s", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchInterval(t *testing.T) {
+	env, err := New(DefaultEnvOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := env.Watch(interval); err == nil {
+			t.Fatalf("expected error for interval %v", interval)
+		}
+	}
+}
+
+func TestWatchRemovedReference(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplates(t, dir, map[string]string{
+		"nav.html":  "nav",
+		"page.html": `{% include "nav.html" %}`,
+	})
+
+	opts := DefaultEnvOpts()
+	opts.Loader = NewFileSystemLoader(dir, "utf-8", false)
+	env, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed []string
+	env.OnTemplateChanged(func(name string) {
+		changed = append(changed, name)
+	})
+	touch := func(name string, offset time.Duration) {
+		future := time.Now().Add(offset)
+		if err := os.Chtimes(filepath.Join(dir, name), future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"nav.html", "page.html"} {
+		if _, err := env.GetTemplate(name, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the include is removed and the template reloaded
+	writeTemplates(t, dir, map[string]string{"page.html": "page"})
+	touch("page.html", time.Hour)
+	env.checkForChanges()
+	if _, err := env.GetTemplate("page.html", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	changed = nil
+	touch("nav.html", time.Hour)
+	env.checkForChanges()
+	if len(changed) != 1 || changed[0] != "nav.html" {
+		t.Fatalf("expected only nav.html to be invalidated, got %v", changed)
+	}
+}
@@ -1,17 +1,207 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
-func TemplateNotFound(name string, msg string) error {
-	// TODO real implementation
-	return fmt.Errorf("TEMPLATE NOT FOUND %s", name)
+// TemplateError is the base error for all errors raised by templates.
+type TemplateError struct {
+	Message string
 }
 
-func TemplateSyntaxError(msg string, lineno int, name *string, filename *string) error {
-	// TODO real implementation
-	return fmt.Errorf("TEMPLATE SYNTAX ERROR %s %d", msg, lineno)
+func (e *TemplateError) Error() string {
+	return e.Message
 }
 
-func TemplateError(msg string) error {
-	return fmt.Errorf("TEMPLATE ERROR: %s", msg)
+func NewTemplateError(msg string) error {
+	return &TemplateError{Message: msg}
+}
+
+// TemplateNotFoundError is raised if a template does not exist.
+type TemplateNotFoundError struct {
+	Name    string
+	Message string
+}
+
+func (e *TemplateNotFoundError) Error() string {
+	return e.Message
+}
+
+func NewTemplateNotFound(name string, msg string) error {
+	if msg == "" {
+		msg = name
+	}
+	return &TemplateNotFoundError{Name: name, Message: fmt.Sprintf("template not found: %s", msg)}
+}
+
+// TemplatesNotFoundError is like `TemplateNotFoundError` but raised if
+// multiple templates are selected. It unwraps to a `TemplateNotFoundError`
+// named after the last template tried.
+type TemplatesNotFoundError struct {
+	Names []string
+	TemplateNotFoundError
+}
+
+func (e *TemplatesNotFoundError) Unwrap() error {
+	return &e.TemplateNotFoundError
+}
+
+func NewTemplatesNotFound(names []string, msg string) error {
+	if msg == "" {
+		msg = fmt.Sprintf("none of the templates given were found: %s", strings.Join(names, ", "))
+	}
+	name := ""
+	if len(names) > 0 {
+		name = names[len(names)-1]
+	}
+	return &TemplatesNotFoundError{Names: names, TemplateNotFoundError: TemplateNotFoundError{Name: name, Message: msg}}
+}
+
+// TemplateSyntaxError is raised to tell the user that there is a problem with the template.
+type TemplateSyntaxError struct {
+	Message  string
+	Lineno   int
+	Name     *string
+	Filename *string
+}
+
+func (e *TemplateSyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", location(e.Lineno, e.Name, e.Filename), e.Message)
+}
+
+func NewTemplateSyntaxError(msg string, lineno int, name *string, filename *string) error {
+	return &TemplateSyntaxError{Message: msg, Lineno: lineno, Name: name, Filename: filename}
+}
+
+// TemplateRuntimeError is a generic runtime error in the template engine.
+type TemplateRuntimeError struct {
+	Message string
+}
+
+func (e *TemplateRuntimeError) Error() string {
+	return e.Message
+}
+
+func NewTemplateRuntimeError(msg string) error {
+	return &TemplateRuntimeError{Message: msg}
+}
+
+// SecurityError is raised if a template tries to do something insecure if
+// the sandbox is enabled. It unwraps to a `TemplateRuntimeError`.
+type SecurityError struct {
+	TemplateRuntimeError
+}
+
+func (e *SecurityError) Unwrap() error {
+	return &e.TemplateRuntimeError
+}
+
+func NewSecurityError(msg string) error {
+	return &SecurityError{TemplateRuntimeError{Message: msg}}
+}
+
+// TypeError is raised if an operation is applied to values of the wrong type,
+// like python's `TypeError`. It unwraps to a `TemplateRuntimeError`.
+type TypeError struct {
+	TemplateRuntimeError
+}
+
+func (e *TypeError) Unwrap() error {
+	return &e.TemplateRuntimeError
+}
+
+func NewTypeError(msg string) error {
+	return &TypeError{TemplateRuntimeError{Message: msg}}
+}
+
+// ZeroDivisionError is raised if a number is divided by zero, like python's
+// `ZeroDivisionError`. It unwraps to a `TemplateRuntimeError`.
+type ZeroDivisionError struct {
+	TemplateRuntimeError
+}
+
+func (e *ZeroDivisionError) Unwrap() error {
+	return &e.TemplateRuntimeError
+}
+
+func NewZeroDivisionError(msg string) error {
+	return &ZeroDivisionError{TemplateRuntimeError{Message: msg}}
+}
+
+// LimitExceededError is raised if a render exceeds one of its resource
+// limits, like the maximum number of evaluation steps.
+type LimitExceededError struct {
+	Limit   string
+	Max     int
+	Message string
+}
+
+func (e *LimitExceededError) Error() string {
+	return e.Message
+}
+
+func NewLimitExceeded(limit string, max int, msg string) error {
+	if msg == "" {
+		msg = fmt.Sprintf("render exceeded the %s limit of %d", limit, max)
+	}
+	return &LimitExceededError{Limit: limit, Max: max, Message: msg}
+}
+
+// CanceledError is raised if the context of a render is canceled or its
+// deadline passed. It unwraps to the error of the context.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("render aborted: %v", e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+func NewCanceled(err error) error {
+	return &CanceledError{Err: err}
+}
+
+// UndefinedError is raised if a template tries to operate on `Undefined`.
+type UndefinedError struct {
+	Message string
+}
+
+func (e *UndefinedError) Error() string {
+	return e.Message
+}
+
+func NewUndefinedError(msg string) error {
+	return &UndefinedError{Message: msg}
+}
+
+// RenderError wraps an error that happened while rendering a template with
+// the position in the template where it happened.
+type RenderError struct {
+	Err      error
+	Lineno   int
+	Name     *string
+	Filename *string
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("%s: %v", location(e.Lineno, e.Name, e.Filename), e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+func location(lineno int, name *string, filename *string) string {
+	if filename != nil {
+		return fmt.Sprintf("%s:%d", *filename, lineno)
+	}
+	if name != nil {
+		return fmt.Sprintf("%s:%d", *name, lineno)
+	}
+	return fmt.Sprintf("<template>:%d", lineno)
 }
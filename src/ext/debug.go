@@ -0,0 +1,226 @@
+package ext
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/nodes"
+	jinjaRuntime "github.com/gojinja/gojinja/src/runtime"
+)
+
+// DebugExtension adds a `{% debug %}` tag that dumps the variables visible
+// at the tag and the names of the available filters and tests. The output is
+// escaped like any other output if autoescaping is enabled.
+//
+//	<pre>{% debug %}</pre>
+type DebugExtension struct {
+	extensions.Extension
+	env *environment.Environment
+}
+
+var _ extensions.IExtension = &DebugExtension{}
+
+// Debug creates the debug extension, use it as a constructor in `EnvOpts.Extensions`.
+func Debug(env *environment.Environment) extensions.IExtension {
+	return &DebugExtension{env: env}
+}
+
+func (*DebugExtension) Tags() []string {
+	return []string{"debug"}
+}
+
+func (e *DebugExtension) Parse(p extensions.IParser) ([]nodes.Node, error) {
+	lineno := p.Stream().Next().Lineno
+	call := &nodes.Call{
+		Node:       &nodes.Const{Value: e.render, LiteralCommon: nodes.LiteralCommon{Lineno: lineno}},
+		Args:       []nodes.Expr{&nodes.DerivedContextReference{ExprCommon: nodes.ExprCommon{Lineno: lineno}}},
+		ExprCommon: nodes.ExprCommon{Lineno: lineno},
+	}
+	return []nodes.Node{&nodes.Output{Nodes: []nodes.Expr{call}, StmtCommon: nodes.StmtCommon{Lineno: lineno}}}, nil
+}
+
+func (e *DebugExtension) render(ctx *jinjaRuntime.Context) string {
+	filters := make([]any, 0, len(e.env.Filters))
+	for name := range e.env.Filters {
+		filters = append(filters, name)
+	}
+	tests := make([]any, 0, len(e.env.Tests))
+	for name := range e.env.Tests {
+		tests = append(tests, name)
+	}
+	sortNames(filters)
+	sortNames(tests)
+
+	result := map[string]any{
+		"context": ctx.GetAll(),
+		"filters": filters,
+		"tests":   tests,
+	}
+	// set the depth since the intent is to show the top few names.
+	return pformat(result, 3)
+}
+
+func sortNames(names []any) {
+	sort.Slice(names, func(i, j int) bool {
+		return names[i].(string) < names[j].(string)
+	})
+}
+
+const pformatWidth = 80
+
+// pformat formats the value like python's `pprint.pformat` with `compact=True`:
+// values that don't fit into the line are split over multiple lines and
+// containers nested deeper than depth are abbreviated.
+func pformat(v any, depth int) string {
+	var b strings.Builder
+	p := &prettyPrinter{b: &b, depth: depth}
+	p.format(v, 0, 0, 0)
+	return b.String()
+}
+
+type prettyPrinter struct {
+	b     *strings.Builder
+	depth int
+}
+
+// container returns the kind of container the value is, if it's one.
+func container(v any) reflect.Kind {
+	if v == nil {
+		return reflect.Invalid
+	}
+	if _, ok := v.(string); ok {
+		return reflect.String
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		return reflect.Map
+	case reflect.Slice, reflect.Array:
+		return reflect.Slice
+	}
+	return reflect.Invalid
+}
+
+// repr returns the single line representation of the value. Containers at
+// the maximum depth are abbreviated.
+func (p *prettyPrinter) repr(v any, level int) string {
+	switch container(v) {
+	case reflect.Map:
+		keys, _ := jinjaRuntime.Iterate(v)
+		if len(keys) == 0 {
+			return "{}"
+		}
+		if level >= p.depth {
+			return "{...}"
+		}
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			value, _, _ := jinjaRuntime.GetItem(v, k)
+			items[i] = p.repr(k, level+1) + ": " + p.repr(value, level+1)
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case reflect.Slice:
+		values, _ := jinjaRuntime.Iterate(v)
+		if len(values) == 0 {
+			return "[]"
+		}
+		if level >= p.depth {
+			return "[...]"
+		}
+		items := make([]string, len(values))
+		for i, value := range values {
+			items[i] = p.repr(value, level+1)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	if v != nil && reflect.TypeOf(v).Kind() == reflect.Func {
+		rv := reflect.ValueOf(v)
+		if !rv.IsNil() {
+			if fn := runtime.FuncForPC(rv.Pointer()); fn != nil {
+				return fmt.Sprintf("<function %s>", fn.Name())
+			}
+		}
+		return "<function>"
+	}
+	return jinjaRuntime.Repr(v)
+}
+
+// format writes the value starting at the column indent. allowance is the
+// number of characters that follow the value on its last line.
+func (p *prettyPrinter) format(v any, indent int, allowance int, level int) {
+	rep := p.repr(v, level)
+	if utf8.RuneCountInString(rep) <= pformatWidth-indent-allowance {
+		p.b.WriteString(rep)
+		return
+	}
+	switch container(v) {
+	case reflect.Map:
+		keys, _ := jinjaRuntime.Iterate(v)
+		indent++
+		p.b.WriteString("{")
+		for i, k := range keys {
+			key := p.repr(k, level+1)
+			value, _, _ := jinjaRuntime.GetItem(v, k)
+			p.b.WriteString(key + ": ")
+			if i == len(keys)-1 {
+				p.format(value, indent+utf8.RuneCountInString(key)+2, allowance+1, level+1)
+			} else {
+				p.format(value, indent+utf8.RuneCountInString(key)+2, 1, level+1)
+				p.b.WriteString(",\n" + strings.Repeat(" ", indent))
+			}
+		}
+		p.b.WriteString("}")
+	case reflect.Slice:
+		values, _ := jinjaRuntime.Iterate(v)
+		p.b.WriteString("[")
+		p.formatItems(values, indent+1, allowance+1, level+1)
+		p.b.WriteString("]")
+	default:
+		p.b.WriteString(rep)
+	}
+}
+
+// formatItems writes the items of a list, packing as many items as possible
+// into each line.
+func (p *prettyPrinter) formatItems(values []any, indent int, allowance int, level int) {
+	width := pformatWidth - indent + 1
+	maxWidth := width
+	delim := ""
+	for i, value := range values {
+		last := i == len(values)-1
+		if last {
+			width -= allowance
+			maxWidth -= allowance
+		}
+		rep := p.repr(value, level)
+		w := utf8.RuneCountInString(rep) + 2
+		if width < w {
+			width = maxWidth
+			if delim != "" {
+				delim = ",\n" + strings.Repeat(" ", indent)
+			}
+		}
+		if width >= w {
+			width -= w
+			p.b.WriteString(delim + rep)
+			delim = ", "
+			continue
+		}
+		p.b.WriteString(delim)
+		delim = ",\n" + strings.Repeat(" ", indent)
+		if last {
+			p.format(value, indent, allowance, level)
+		} else {
+			p.format(value, indent, 1, level)
+		}
+	}
+}
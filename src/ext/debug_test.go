@@ -0,0 +1,66 @@
+package ext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/filters"
+)
+
+func newDebugEnv(t *testing.T, autoescape bool) *environment.Environment {
+	opts := environment.DefaultEnvOpts()
+	opts.AutoEscape = autoescape
+	opts.Extensions = map[string]func(*environment.Environment) extensions.IExtension{"debug": Debug}
+	env, err := environment.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noop := func(args []any, _ map[string]any) any { return args[0] }
+	env.Filters = map[string]filters.Filter{"upper": noop, "lower": noop}
+	env.Globals = map[string]any{}
+	env.Tests = map[string]environment.Test{"odd": env.Tests["odd"], "defined": env.Tests["defined"]}
+	return env
+}
+
+func TestDebug(t *testing.T) {
+	vars := map[string]any{
+		"user":   "Ann <admin>",
+		"items":  []int{1, 2, 3},
+		"nested": map[string]any{"a": map[string]any{"b": map[string]any{"c": 1}}},
+	}
+	source := "{% for x in items %}{% if loop.last %}{% debug %}{% endif %}{% endfor %}"
+	expected := `{'context': {'items': [1, 2, 3],
+             'loop': <LoopContext 3/3>,
+             'nested': {'a': {...}},
+             'user': 'Ann <admin>',
+             'x': 3},
+ 'filters': ['lower', 'upper'],
+ 'tests': ['defined', 'odd']}`
+	if res := render(t, newDebugEnv(t, false), source, vars); res != expected {
+		t.Fatalf("unexpected output:\n%s", res)
+	}
+
+	res := render(t, newDebugEnv(t, true), "{% debug %}", map[string]any{"user": "<b>"})
+	if !strings.Contains(res, "&#39;user&#39;: &#39;&lt;b&gt;&#39;") {
+		t.Fatalf("expected escaped output, got:\n%s", res)
+	}
+}
+
+func TestPformat(t *testing.T) {
+	long := make([]any, 30)
+	for i := range long {
+		long[i] = i * 1000
+	}
+	res := pformat(map[string]any{"numbers": long, "empty": map[string]any{}, "fn": strings.ToUpper}, 3)
+	expected := `{'empty': {},
+ 'fn': <function strings.ToUpper>,
+ 'numbers': [0, 1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 9000, 10000,
+             11000, 12000, 13000, 14000, 15000, 16000, 17000, 18000, 19000,
+             20000, 21000, 22000, 23000, 24000, 25000, 26000, 27000, 28000,
+             29000]}`
+	if res != expected {
+		t.Fatalf("unexpected output:\n%s", res)
+	}
+}
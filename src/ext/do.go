@@ -0,0 +1,33 @@
+package ext
+
+import (
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/nodes"
+)
+
+// ExprStmtExtension adds a `do` tag that works like a print statement but
+// doesn't print the return value, e.g. `{% do items.append(item) %}`.
+type ExprStmtExtension struct {
+	extensions.Extension
+}
+
+var _ extensions.IExtension = &ExprStmtExtension{}
+
+// Do creates the expression statement extension, use it as a constructor in `EnvOpts.Extensions`.
+func Do(*environment.Environment) extensions.IExtension {
+	return &ExprStmtExtension{}
+}
+
+func (*ExprStmtExtension) Tags() []string {
+	return []string{"do"}
+}
+
+func (*ExprStmtExtension) Parse(p extensions.IParser) ([]nodes.Node, error) {
+	lineno := p.Stream().Next().Lineno
+	node, err := p.ParseTuple(false, true, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return []nodes.Node{&nodes.ExprStmt{Node: node, StmtCommon: nodes.StmtCommon{Lineno: lineno}}}, nil
+}
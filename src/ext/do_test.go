@@ -0,0 +1,100 @@
+package ext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+)
+
+type counter struct {
+	n int
+}
+
+func (c *counter) Add(n int) int {
+	c.n += n
+	return c.n
+}
+
+func newDoEnv(t *testing.T) *environment.Environment {
+	opts := environment.DefaultEnvOpts()
+	opts.Extensions = map[string]func(*environment.Environment) extensions.IExtension{"do": Do}
+	env, err := environment.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestDo(t *testing.T) {
+	env := newDoEnv(t)
+
+	items := &[]any{}
+	ints := &[]int{3, 1, 2}
+	dict := map[string]any{"a": 1}
+	c := &counter{}
+	vars := map[string]any{"items": items, "ints": ints, "dict": dict, "counter": c}
+	source := `{% do items.append(1) %}{% do items.extend(['b', 'c']) %}{% do items.insert(0, 'first') %}` +
+		`{% do counter.Add(2) %}{% do counter.Add(3) %}` +
+		`{% do ints.remove(1) %}{% do ints.reverse() %}{{ ints.pop(0) }}{{ ints.index(3) }}` +
+		`{% do dict.update({'b': 2}, c=3) %}{% do dict.pop('a') %}{{ dict.get('x', 'default') }}` +
+		`{% for x in [1, 2] %}{% do items.append(x * 10), counter.Add(x) %}{% endfor %}`
+	res := render(t, env, source, vars)
+	if res != "20default" {
+		t.Fatalf("unexpected output %q", res)
+	}
+	if expected := []any{"first", int64(1), "b", "c", int64(10), int64(20)}; !reflect.DeepEqual(*items, expected) {
+		t.Fatalf("expected %v, got %v", expected, *items)
+	}
+	if c.n != 8 {
+		t.Fatalf("expected counter to be 8, got %d", c.n)
+	}
+	if expected := []int{3}; !reflect.DeepEqual(*ints, expected) {
+		t.Fatalf("expected %v, got %v", expected, *ints)
+	}
+	if expected := map[string]any{"b": int64(2), "c": int64(3)}; !reflect.DeepEqual(dict, expected) {
+		t.Fatalf("expected %v, got %v", expected, dict)
+	}
+}
+
+func TestDoTemplateLists(t *testing.T) {
+	env := newDoEnv(t)
+	cases := map[string]string{
+		"{% set xs = [] %}{% do xs.append(1) %}{% do xs.extend([2, 3]) %}{{ xs }} {{ xs == [1, 2, 3] }}": "[1, 2, 3] True",
+		"{% set ns = namespace(l=[]) %}{% do ns.l.append(1) %}{% do ns.l.append(2) %}{{ ns.l }}":         "[1, 2]",
+		"{% set xs = [3, 1] %}{% do xs.insert(0, xs.pop()) %}{{ xs }}{{ xs + [2] }}{{ xs * 2 }}":         "[1, 3][1, 3, 2][1, 3, 1, 3]",
+		"{% set d = {'items': []} %}{% do d['items'].append('a') %}{{ d }}":                              "{'items': ['a']}",
+	}
+	for source, expected := range cases {
+		if res := render(t, env, source, nil); res != expected {
+			t.Errorf("%q: expected %q, got %q", source, expected, res)
+		}
+	}
+}
+
+func TestDoErrors(t *testing.T) {
+	env := newDoEnv(t)
+	if _, err := env.FromString("{% do %}", nil); err == nil {
+		t.Fatal("expected syntax error for missing expression")
+	}
+
+	cases := map[string]string{
+		"{% do ints.append('x') %}":  "cannot use str as argument of type int",
+		"{% do ints.pop(5) %}":       "pop index out of range",
+		"{% do ints.remove(7) %}":    "x not in list",
+		"{% do ints.append(1, 2) %}": "append() takes exactly one argument (2 given)",
+		"{% do ints.reverse(1) %}":   "reverse() takes no arguments (1 given)",
+		"{% do missing.append(1) %}": "'missing' is undefined",
+	}
+	for source, msg := range cases {
+		tmpl, err := env.FromString(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tmpl.Render(map[string]any{"ints": &[]int{1}}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected error containing %q, got %v", source, msg, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ext
+
+import (
+	"strings"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/nodes"
+)
+
+// GettextFunctions are the names of the functions extracted by default.
+var GettextFunctions = []string{"_", "gettext", "ngettext", "pgettext", "npgettext"}
+
+// ExtractedMessage is a translatable string found in a template.
+type ExtractedMessage struct {
+	// Lineno is the line of the call or the `{% trans %}` block.
+	Lineno int
+	// Function is the name of the gettext function used.
+	Function string
+	// Strings contains one entry per argument of the call. Arguments that are
+	// not string constants are nil.
+	Strings []*string
+	// Comments are the translator comments found before the message.
+	Comments []string
+}
+
+// ExtractFromAST extracts the localizable strings from the given template
+// node. Every call to one of the gettext functions is reported, including the
+// calls generated by `{% trans %}` blocks. Keyword and dynamic arguments are
+// reported as nil strings, so the positions of the strings always match the
+// positions of the arguments.
+//
+// If gettextFunctions is nil `GettextFunctions` are used.
+func ExtractFromAST(ast nodes.Node, gettextFunctions []string) []ExtractedMessage {
+	if gettextFunctions == nil {
+		gettextFunctions = GettextFunctions
+	}
+	functions := make(map[string]struct{}, len(gettextFunctions))
+	for _, name := range gettextFunctions {
+		functions[name] = struct{}{}
+	}
+
+	var res []ExtractedMessage
+	nodes.Walk(ast, func(node nodes.Node) bool {
+		call, ok := node.(*nodes.Call)
+		if !ok {
+			return true
+		}
+		name, ok := call.Node.(*nodes.Name)
+		if !ok {
+			return true
+		}
+		if _, ok := functions[name.Name]; !ok {
+			return true
+		}
+
+		strs := make([]*string, 0, len(call.Args)+len(call.Kwargs)+2)
+		for _, arg := range call.Args {
+			var s *string
+			if c, ok := arg.(*nodes.Const); ok {
+				if v, ok := c.Value.(string); ok {
+					s = &v
+				}
+			}
+			strs = append(strs, s)
+		}
+		for range call.Kwargs {
+			strs = append(strs, nil)
+		}
+		if call.DynArgs != nil {
+			strs = append(strs, nil)
+		}
+		if call.DynKwargs != nil {
+			strs = append(strs, nil)
+		}
+		res = append(res, ExtractedMessage{Lineno: call.Lineno, Function: name.Name, Strings: strs})
+		return true
+	})
+	return res
+}
+
+// ExtractOptions configures `Extract`.
+type ExtractOptions struct {
+	// EnvLexerInformation holds the delimiters and other lexer settings the
+	// templates are written with. If nil the defaults are used.
+	*lexer.EnvLexerInformation
+	// Keywords are the gettext functions to extract, `GettextFunctions` if nil.
+	Keywords []string
+	// CommentTags are the prefixes of comments that are extracted as
+	// translator comments, e.g. "TRANSLATORS:". Without tags no comments are
+	// extracted.
+	CommentTags []string
+	// Trimmed enables the `ext.i18n.trimmed` policy.
+	Trimmed bool
+	// Extensions are additional extensions the templates need to be parsed.
+	// The i18n extension is always enabled.
+	Extensions map[string]func(*environment.Environment) extensions.IExtension
+}
+
+// Extract parses the template source and returns the translatable strings
+// together with the translator comments that precede them.
+func Extract(source string, filename string, opts *ExtractOptions) ([]ExtractedMessage, error) {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+	envOpts := environment.DefaultEnvOpts()
+	if opts.EnvLexerInformation != nil {
+		envOpts.EnvLexerInformation = opts.EnvLexerInformation
+	}
+	envOpts.Extensions = map[string]func(*environment.Environment) extensions.IExtension{}
+	for name, ext := range opts.Extensions {
+		envOpts.Extensions[name] = ext
+	}
+	envOpts.Extensions["i18n"] = I18n
+	env, err := environment.New(envOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Trimmed {
+		env.Policies["ext.i18n.trimmed"] = true
+	}
+
+	ast, err := env.Parse(source, nil, &filename)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := env.Lex(env.Preprocess(source, nil, &filename), nil, &filename)
+	if err != nil {
+		return nil, err
+	}
+
+	finder := &commentFinder{tokens: tokens, commentTags: opts.CommentTags}
+	messages := ExtractFromAST(ast, opts.Keywords)
+	for i := range messages {
+		messages[i].Comments = finder.findComments(messages[i].Lineno)
+	}
+	return messages, nil
+}
+
+// commentFinder helps to find the translator comments of the messages. The
+// messages have to be looked up in the order of their line numbers.
+type commentFinder struct {
+	tokens      []lexer.RawToken
+	commentTags []string
+	offset      int
+	lastLineno  int
+}
+
+func (f *commentFinder) findBackwards(offset int) []string {
+	defer func() {
+		f.offset = offset
+	}()
+	for i := offset - 1; i >= f.offset; i-- {
+		token := f.tokens[i]
+		if token.Type != lexer.TokenComment && token.Type != lexer.TokenLinecomment {
+			continue
+		}
+		value := strings.TrimSpace(token.Value)
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, tag := range f.commentTags {
+			if fields[0] == tag {
+				comment := strings.TrimLeft(strings.TrimPrefix(value, fields[0]), " \t\r\n")
+				return []string{strings.TrimRight(comment, " \t\r\n")}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *commentFinder) findComments(lineno int) []string {
+	if len(f.commentTags) == 0 || f.lastLineno > lineno {
+		return nil
+	}
+	f.lastLineno = lineno
+	for idx, token := range f.tokens[f.offset:] {
+		if token.Lineno > lineno {
+			return f.findBackwards(f.offset + idx)
+		}
+	}
+	return f.findBackwards(len(f.tokens))
+}
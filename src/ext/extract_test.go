@@ -0,0 +1,141 @@
+package ext
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gojinja/gojinja/src/lexer"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestExtract(t *testing.T) {
+	source := `{# NOTE: greeting shown on top #}
+<h1>{{ _('Hello World') }}</h1>
+{# NOTE: first line
+   second line #}
+{% trans user=name %}Hello {{ user }}!{% endtrans %}
+{% trans count=items %}{{ count }} item{% pluralize %}{{ count }} items{% endtrans %}
+{# unrelated comment #}
+{{ pgettext('menu', 'Open') }}
+{{ ngettext(singular, 'plural', 3) }}
+{{ gettext('x', *args) }}
+{{ other('ignored') }}
+`
+	messages, err := Extract(source, "index.html", &ExtractOptions{CommentTags: []string{"NOTE:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ExtractedMessage{
+		{Lineno: 2, Function: "_", Strings: []*string{strPtr("Hello World")}, Comments: []string{"greeting shown on top"}},
+		{Lineno: 5, Function: "gettext", Strings: []*string{strPtr("Hello %(user)s!"), nil}, Comments: []string{"first line\n   second line"}},
+		{Lineno: 6, Function: "ngettext", Strings: []*string{strPtr("%(count)s item"), strPtr("%(count)s items"), nil, nil}},
+		{Lineno: 8, Function: "pgettext", Strings: []*string{strPtr("menu"), strPtr("Open")}},
+		{Lineno: 9, Function: "ngettext", Strings: []*string{nil, strPtr("plural"), nil}},
+		{Lineno: 10, Function: "gettext", Strings: []*string{strPtr("x"), nil}},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("unexpected messages:\n%s", dumpMessages(messages))
+	}
+}
+
+func dumpMessages(messages []ExtractedMessage) string {
+	var b strings.Builder
+	for _, m := range messages {
+		var strs []string
+		for _, s := range m.Strings {
+			if s == nil {
+				strs = append(strs, "<nil>")
+			} else {
+				strs = append(strs, *s)
+			}
+		}
+		b.WriteString(strings.Join([]string{m.Function, strings.Join(strs, "|"), strings.Join(m.Comments, "|")}, " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestExtractCustomDelimiters(t *testing.T) {
+	info := lexer.DefaultEnvLexerInformation()
+	info.BlockStartString, info.BlockEndString = "<%", "%>"
+	info.VariableStartString, info.VariableEndString = "${", "}"
+	info.CommentStartString, info.CommentEndString = "<#", "#>"
+
+	source := "<# T: title #>${ _('Title') }\n<% trans %>Body<% endtrans %>"
+	messages, err := Extract(source, "page.html", &ExtractOptions{EnvLexerInformation: info, CommentTags: []string{"T:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || *messages[0].Strings[0] != "Title" || *messages[1].Strings[0] != "Body" {
+		t.Fatalf("unexpected messages:\n%s", dumpMessages(messages))
+	}
+	if !reflect.DeepEqual(messages[0].Comments, []string{"title"}) {
+		t.Fatalf("unexpected comments %q", messages[0].Comments)
+	}
+	if messages[1].Lineno != 2 {
+		t.Fatalf("unexpected line number %d", messages[1].Lineno)
+	}
+}
+
+func TestPot(t *testing.T) {
+	pot := NewPot()
+	pot.Project = "demo"
+	pot.Version = "1.0"
+	pot.CreationDate = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	pot.Add("a.html", []ExtractedMessage{
+		{Lineno: 1, Function: "_", Strings: []*string{strPtr("Hello")}, Comments: []string{"greeting"}},
+		{Lineno: 2, Function: "ngettext", Strings: []*string{strPtr("%(num)s file"), strPtr("%(num)s files"), nil}},
+		{Lineno: 3, Function: "gettext", Strings: []*string{nil}},
+	})
+	pot.Add("b.html", []ExtractedMessage{
+		{Lineno: 7, Function: "gettext", Strings: []*string{strPtr("Hello")}},
+		{Lineno: 8, Function: "pgettext", Strings: []*string{strPtr("menu"), strPtr("Say \"hi\"\nnow")}},
+	})
+
+	var b strings.Builder
+	if _, err := pot.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Translations template for demo.
+#
+#, fuzzy
+msgid ""
+msgstr ""
+"Project-Id-Version: demo 1.0\n"
+"Report-Msgid-Bugs-To: \n"
+"POT-Creation-Date: 2024-01-02 03:04+0000\n"
+"PO-Revision-Date: YEAR-MO-DA HO:MI+ZONE\n"
+"Last-Translator: FULL NAME <EMAIL@ADDRESS>\n"
+"Language-Team: LANGUAGE <LL@li.org>\n"
+"MIME-Version: 1.0\n"
+"Content-Type: text/plain; charset=utf-8\n"
+"Content-Transfer-Encoding: 8bit\n"
+
+#. greeting
+#: a.html:1 b.html:7
+msgid "Hello"
+msgstr ""
+
+#: a.html:2
+#, python-format
+msgid "%(num)s file"
+msgid_plural "%(num)s files"
+msgstr[0] ""
+msgstr[1] ""
+
+#: b.html:8
+msgctxt "menu"
+msgid ""
+"Say \"hi\"\n"
+"now"
+msgstr ""
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+}
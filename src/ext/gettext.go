@@ -0,0 +1,470 @@
+package ext
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Translations provides translated messages to the i18n extension.
+type Translations interface {
+	Gettext(message string) string
+	Ngettext(singular, plural string, n int) string
+	Pgettext(msgctxt, message string) string
+	Npgettext(msgctxt, singular, plural string, n int) string
+}
+
+// NullTranslations returns all messages untranslated.
+type NullTranslations struct{}
+
+func (NullTranslations) Gettext(message string) string {
+	return message
+}
+
+func (NullTranslations) Ngettext(singular, plural string, n int) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+func (NullTranslations) Pgettext(_, message string) string {
+	return message
+}
+
+func (NullTranslations) Npgettext(_, singular, plural string, n int) string {
+	return NullTranslations{}.Ngettext(singular, plural, n)
+}
+
+var _ Translations = NullTranslations{}
+
+// Catalog holds the messages of a GNU gettext message catalog. Messages that
+// are missing in the catalog are looked up in the fallback.
+type Catalog struct {
+	messages map[string]string
+	plurals  map[string][]string
+	plural   func(n int64) int64
+	Headers  map[string]string
+	Fallback Translations
+}
+
+var _ Translations = &Catalog{}
+
+const (
+	moMagic          = 0x950412de
+	contextSeparator = "\x04"
+)
+
+func germanicPlural(n int64) int64 {
+	if n != 1 {
+		return 1
+	}
+	return 0
+}
+
+// ParseMO parses the contents of a GNU `.mo` file.
+func ParseMO(data []byte) (*Catalog, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("invalid .mo file: file too short")
+	}
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(data) == moMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == moMagic:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid .mo file: bad magic number")
+	}
+	if major := order.Uint32(data[4:]) >> 16; major > 1 {
+		return nil, fmt.Errorf("invalid .mo file: unsupported major version %d", major)
+	}
+	count := order.Uint32(data[8:])
+	origTable := order.Uint32(data[12:])
+	transTable := order.Uint32(data[16:])
+
+	readString := func(table uint32, i uint32) (string, error) {
+		entry := uint64(table) + uint64(i)*8
+		if entry+8 > uint64(len(data)) {
+			return "", fmt.Errorf("invalid .mo file: table out of range")
+		}
+		length := uint64(order.Uint32(data[entry:]))
+		offset := uint64(order.Uint32(data[entry+4:]))
+		if offset+length > uint64(len(data)) {
+			return "", fmt.Errorf("invalid .mo file: string out of range")
+		}
+		return string(data[offset : offset+length]), nil
+	}
+
+	c := &Catalog{
+		messages: make(map[string]string),
+		plurals:  make(map[string][]string),
+		plural:   germanicPlural,
+		Headers:  make(map[string]string),
+	}
+	for i := uint32(0); i < count; i++ {
+		msg, err := readString(origTable, i)
+		if err != nil {
+			return nil, err
+		}
+		tmsg, err := readString(transTable, i)
+		if err != nil {
+			return nil, err
+		}
+		if msg == "" {
+			if err := c.parseHeaders(tmsg); err != nil {
+				return nil, err
+			}
+		}
+		if idx := strings.IndexByte(msg, 0); idx >= 0 {
+			c.plurals[msg[:idx]] = strings.Split(tmsg, "\x00")
+		} else {
+			c.messages[msg] = tmsg
+		}
+	}
+	return c, nil
+}
+
+func (c *Catalog) parseHeaders(headers string) error {
+	for _, line := range strings.Split(headers, "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		c.Headers[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	pluralForms, ok := c.Headers["plural-forms"]
+	if !ok {
+		return nil
+	}
+	for _, part := range strings.Split(pluralForms, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if ok && strings.TrimSpace(k) == "plural" {
+			plural, err := compilePluralForms(v)
+			if err != nil {
+				return err
+			}
+			c.plural = plural
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) Gettext(message string) string {
+	if t, ok := c.messages[message]; ok {
+		return t
+	}
+	if c.Fallback != nil {
+		return c.Fallback.Gettext(message)
+	}
+	return message
+}
+
+func (c *Catalog) Ngettext(singular, plural string, n int) string {
+	if forms, ok := c.plurals[singular]; ok {
+		if idx := c.plural(int64(n)); idx >= 0 && idx < int64(len(forms)) {
+			return forms[idx]
+		}
+	}
+	if c.Fallback != nil {
+		return c.Fallback.Ngettext(singular, plural, n)
+	}
+	return NullTranslations{}.Ngettext(singular, plural, n)
+}
+
+func (c *Catalog) Pgettext(msgctxt, message string) string {
+	if t, ok := c.messages[msgctxt+contextSeparator+message]; ok {
+		return t
+	}
+	if c.Fallback != nil {
+		return c.Fallback.Pgettext(msgctxt, message)
+	}
+	return message
+}
+
+func (c *Catalog) Npgettext(msgctxt, singular, plural string, n int) string {
+	if forms, ok := c.plurals[msgctxt+contextSeparator+singular]; ok {
+		if idx := c.plural(int64(n)); idx >= 0 && idx < int64(len(forms)) {
+			return forms[idx]
+		}
+	}
+	if c.Fallback != nil {
+		return c.Fallback.Npgettext(msgctxt, singular, plural, n)
+	}
+	return NullTranslations{}.Ngettext(singular, plural, n)
+}
+
+// expandLanguage returns the variants of the locale name, from the most to
+// the least specific one, e.g. `de_DE.UTF-8` expands to `de_DE.UTF-8`,
+// `de_DE` and `de`.
+func expandLanguage(lang string) []string {
+	res := []string{lang}
+	add := func(l string) {
+		if l != "" && l != res[len(res)-1] {
+			res = append(res, l)
+		}
+	}
+	if i := strings.IndexAny(lang, ".@"); i >= 0 {
+		lang = lang[:i]
+		add(lang)
+	}
+	if i := strings.IndexByte(lang, '_'); i >= 0 {
+		add(lang[:i])
+	}
+	return res
+}
+
+// LoadTranslations loads the `.mo` files of the domain for the languages from
+// `localedir/<language>/LC_MESSAGES/<domain>.mo`. The catalog of the first
+// language found is returned, catalogs of the other languages are used as
+// fallbacks in the given order.
+func LoadTranslations(localedir string, domain string, languages ...string) (*Catalog, error) {
+	var first, last *Catalog
+	seen := make(map[string]bool)
+	for _, lang := range languages {
+		for _, l := range expandLanguage(lang) {
+			path := filepath.Join(localedir, l, "LC_MESSAGES", domain+".mo")
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			c, err := ParseMO(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if first == nil {
+				first = c
+			} else {
+				last.Fallback = c
+			}
+			last = c
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("no translation file found for domain %q: %w", domain, os.ErrNotExist)
+	}
+	return first, nil
+}
+
+// compilePluralForms compiles the C expression of the `plural` part of the
+// Plural-Forms header.
+func compilePluralForms(expr string) (func(n int64) int64, error) {
+	p := &pluralParser{}
+	if err := p.tokenize(expr); err != nil {
+		return nil, err
+	}
+	f, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("invalid plural forms expression %q", expr)
+	}
+	return f, nil
+}
+
+type pluralParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *pluralParser) tokenize(expr string) error {
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case ch >= '0' && ch <= '9':
+			j := i
+			for j < len(expr) && expr[j] >= '0' && expr[j] <= '9' {
+				j++
+			}
+			p.tokens = append(p.tokens, expr[i:j])
+			i = j
+		case ch == 'n':
+			p.tokens = append(p.tokens, "n")
+			i++
+		default:
+			if i+1 < len(expr) {
+				switch two := expr[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					p.tokens = append(p.tokens, two)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("?:<>+-*/%!()", rune(ch)) {
+				return fmt.Errorf("invalid character %q in plural forms expression", ch)
+			}
+			p.tokens = append(p.tokens, string(ch))
+			i++
+		}
+	}
+	return nil
+}
+
+func (p *pluralParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *pluralParser) parseTernary() (func(int64) int64, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "?" {
+		return cond, nil
+	}
+	p.pos++
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != ":" {
+		return nil, fmt.Errorf("expected ':' in plural forms expression")
+	}
+	p.pos++
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return func(n int64) int64 {
+		if cond(n) != 0 {
+			return then(n)
+		}
+		return otherwise(n)
+	}, nil
+}
+
+// pluralPrecedence lists the binary operators from the lowest to the highest precedence.
+var pluralPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *pluralParser) parseBinary(level int) (func(int64) int64, error) {
+	if level == len(pluralPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range pluralPrecedence[level] {
+			if op == candidate {
+				found = true
+			}
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = pluralBinaryOp(op, left, right)
+	}
+}
+
+func pluralBinaryOp(op string, l, r func(int64) int64) func(int64) int64 {
+	return func(n int64) int64 {
+		a := l(n)
+		switch op {
+		case "||":
+			return boolToInt(a != 0 || r(n) != 0)
+		case "&&":
+			return boolToInt(a != 0 && r(n) != 0)
+		}
+		b := r(n)
+		switch op {
+		case "==":
+			return boolToInt(a == b)
+		case "!=":
+			return boolToInt(a != b)
+		case "<":
+			return boolToInt(a < b)
+		case ">":
+			return boolToInt(a > b)
+		case "<=":
+			return boolToInt(a <= b)
+		case ">=":
+			return boolToInt(a >= b)
+		case "+":
+			return a + b
+		case "-":
+			return a - b
+		case "*":
+			return a * b
+		case "/":
+			if b == 0 {
+				return 0
+			}
+			return a / b
+		default:
+			if b == 0 {
+				return 0
+			}
+			return a % b
+		}
+	}
+}
+
+func (p *pluralParser) parseUnary() (func(int64) int64, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "!":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n int64) int64 { return boolToInt(operand(n) == 0) }, nil
+	case tok == "(":
+		inner, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("expected ')' in plural forms expression")
+		}
+		p.pos++
+		return inner, nil
+	case tok == "n":
+		return func(n int64) int64 { return n }, nil
+	case tok != "" && tok[0] >= '0' && tok[0] <= '9':
+		v, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(int64) int64 { return v }, nil
+	}
+	return nil, fmt.Errorf("unexpected token %q in plural forms expression", tok)
+}
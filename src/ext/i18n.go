@@ -0,0 +1,504 @@
+package ext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/nodes"
+	"github.com/gojinja/gojinja/src/runtime"
+	"github.com/gojinja/gojinja/src/utils"
+)
+
+// InternationalizationExtension adds gettext support to Jinja. The `{% trans %}`
+// blocks are translated with the `gettext`, `ngettext`, `pgettext` and
+// `npgettext` globals, which are installed with one of the install methods,
+// e.g. `InstallGettextTranslations`.
+//
+// The installed functions use the new style gettext calls: variables are passed
+// as keyword arguments and interpolated into the translated `%(name)s`
+// placeholders, and the result is marked safe if autoescaping is enabled.
+type InternationalizationExtension struct {
+	extensions.Extension
+	env *environment.Environment
+}
+
+var _ extensions.IExtension = &InternationalizationExtension{}
+
+// I18n creates the i18n extension, use it as a constructor in `EnvOpts.Extensions`.
+func I18n(env *environment.Environment) extensions.IExtension {
+	e := &InternationalizationExtension{env: env}
+	env.Globals["_"] = runtime.ContextFunction(gettextAlias)
+	env.Extend(map[string]any{
+		"install_gettext_translations":   e.InstallGettextTranslations,
+		"install_null_translations":      e.InstallNullTranslations,
+		"install_gettext_callables":      e.InstallGettextCallables,
+		"uninstall_gettext_translations": e.UninstallGettextTranslations,
+	})
+	return e
+}
+
+func (*InternationalizationExtension) Tags() []string {
+	return []string{"trans"}
+}
+
+// InstallGettextTranslations installs the translations in the environment.
+func (e *InternationalizationExtension) InstallGettextTranslations(translations Translations) {
+	e.InstallGettextCallables(translations.Gettext, translations.Ngettext, translations.Pgettext, translations.Npgettext)
+}
+
+// InstallNullTranslations installs translations that return all messages untranslated.
+func (e *InternationalizationExtension) InstallNullTranslations() {
+	e.InstallGettextTranslations(NullTranslations{})
+}
+
+// InstallGettextCallables installs the given functions as the gettext globals.
+// `pgettext` and `npgettext` may be nil.
+func (e *InternationalizationExtension) InstallGettextCallables(
+	gettext func(message string) string,
+	ngettext func(singular, plural string, n int) string,
+	pgettext func(msgctxt, message string) string,
+	npgettext func(msgctxt, singular, plural string, n int) string,
+) {
+	e.env.Globals["gettext"] = newstyleGettext(gettext)
+	e.env.Globals["ngettext"] = newstyleNgettext(ngettext)
+	if pgettext != nil {
+		e.env.Globals["pgettext"] = newstylePgettext(pgettext)
+	}
+	if npgettext != nil {
+		e.env.Globals["npgettext"] = newstyleNpgettext(npgettext)
+	}
+}
+
+// UninstallGettextTranslations removes the installed gettext globals.
+func (e *InternationalizationExtension) UninstallGettextTranslations() {
+	for _, name := range []string{"gettext", "ngettext", "pgettext", "npgettext"} {
+		delete(e.env.Globals, name)
+	}
+}
+
+func gettextAlias(ctx *runtime.Context, args []any, kwargs map[string]any) (any, error) {
+	gettext := ctx.ResolveOrMissing("gettext")
+	if _, ok := gettext.(utils.Missing); ok {
+		return nil, errors.NewUndefinedError("'gettext' is undefined")
+	}
+	return ctx.Call(gettext, args, kwargs)
+}
+
+// stringArgs checks that exactly len(dest) string arguments were passed and stores them in dest.
+func stringArgs(name string, args []any, dest ...*string) error {
+	if len(args) != len(dest) {
+		return fmt.Errorf("%s() takes %d positional arguments but %d were given", name, len(dest), len(args))
+	}
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			if m, isMarkup := arg.(runtime.Markup); isMarkup {
+				s, ok = string(m), true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s() argument %d must be a string, not %s", name, i+1, runtime.TypeName(arg))
+		}
+		*dest[i] = s
+	}
+	return nil
+}
+
+// pluralArgs splits the arguments of the plural functions into the messages and the number.
+func pluralArgs(name string, args []any, kwargs map[string]any, dest ...*string) (int, error) {
+	if len(args) != len(dest)+1 {
+		return 0, fmt.Errorf("%s() takes %d positional arguments but %d were given", name, len(dest)+1, len(args))
+	}
+	if err := stringArgs(name, args[:len(dest)], dest...); err != nil {
+		return 0, err
+	}
+	num := args[len(dest)]
+	n, ok := runtime.ToInt(num)
+	if !ok {
+		return 0, fmt.Errorf("%s() count must be an integer, not %s", name, runtime.TypeName(num))
+	}
+	if _, ok := kwargs["num"]; !ok {
+		kwargs["num"] = num
+	}
+	return int(n), nil
+}
+
+func newstyleGettext(gettext func(string) string) runtime.ContextFunction {
+	return func(ctx *runtime.Context, args []any, kwargs map[string]any) (any, error) {
+		var message string
+		if err := stringArgs("gettext", args, &message); err != nil {
+			return nil, err
+		}
+		return formatTranslation(ctx, gettext(message), kwargs)
+	}
+}
+
+func newstyleNgettext(ngettext func(string, string, int) string) runtime.ContextFunction {
+	return func(ctx *runtime.Context, args []any, kwargs map[string]any) (any, error) {
+		var singular, plural string
+		kwargs = copyKwargs(kwargs)
+		n, err := pluralArgs("ngettext", args, kwargs, &singular, &plural)
+		if err != nil {
+			return nil, err
+		}
+		return formatTranslation(ctx, ngettext(singular, plural, n), kwargs)
+	}
+}
+
+func newstylePgettext(pgettext func(string, string) string) runtime.ContextFunction {
+	return func(ctx *runtime.Context, args []any, kwargs map[string]any) (any, error) {
+		var msgctxt, message string
+		if err := stringArgs("pgettext", args, &msgctxt, &message); err != nil {
+			return nil, err
+		}
+		kwargs = copyKwargs(kwargs)
+		if _, ok := kwargs["context"]; !ok {
+			kwargs["context"] = msgctxt
+		}
+		return formatTranslation(ctx, pgettext(msgctxt, message), kwargs)
+	}
+}
+
+func newstyleNpgettext(npgettext func(string, string, string, int) string) runtime.ContextFunction {
+	return func(ctx *runtime.Context, args []any, kwargs map[string]any) (any, error) {
+		var msgctxt, singular, plural string
+		kwargs = copyKwargs(kwargs)
+		n, err := pluralArgs("npgettext", args, kwargs, &msgctxt, &singular, &plural)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := kwargs["context"]; !ok {
+			kwargs["context"] = msgctxt
+		}
+		return formatTranslation(ctx, npgettext(msgctxt, singular, plural, n), kwargs)
+	}
+}
+
+func copyKwargs(kwargs map[string]any) map[string]any {
+	res := make(map[string]any, len(kwargs)+1)
+	for k, v := range kwargs {
+		res[k] = v
+	}
+	return res
+}
+
+// formatTranslation interpolates the variables into the `%(name)s` placeholders
+// of the translated string. With autoescaping enabled the variables are escaped
+// and the result is markup.
+func formatTranslation(ctx *runtime.Context, translated string, variables map[string]any) (any, error) {
+	autoescape := ctx.EvalCtx != nil && ctx.EvalCtx.AutoEscape
+	var b strings.Builder
+	for i := 0; i < len(translated); i++ {
+		ch := translated[i]
+		if ch != '%' {
+			b.WriteByte(ch)
+			continue
+		}
+		i++
+		if i < len(translated) && translated[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if i >= len(translated) || translated[i] != '(' {
+			return nil, fmt.Errorf("unsupported format in translation %q, use %%(name)s placeholders and %%%% for percent signs", translated)
+		}
+		end := strings.IndexByte(translated[i:], ')')
+		if end < 0 || i+end+1 >= len(translated) {
+			return nil, fmt.Errorf("incomplete format in translation %q", translated)
+		}
+		name := translated[i+1 : i+end]
+		conversion := translated[i+end+1]
+		i += end + 1
+
+		value, ok := variables[name]
+		if !ok {
+			return nil, fmt.Errorf("missing variable %q for translation %q", name, translated)
+		}
+		var s string
+		var err error
+		switch conversion {
+		case 's':
+			if autoescape {
+				var m runtime.Markup
+				m, err = runtime.Escape(value)
+				s = string(m)
+			} else {
+				s, err = runtime.ToString(value)
+			}
+		case 'd', 'i':
+			n, ok := runtime.ToInt(value)
+			if !ok {
+				return nil, fmt.Errorf("%%%c format: a number is required, not %s", conversion, runtime.TypeName(value))
+			}
+			s = fmt.Sprint(n)
+		default:
+			return nil, fmt.Errorf("unsupported format character %q in translation %q", conversion, translated)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(s)
+	}
+	if autoescape {
+		return runtime.Markup(b.String()), nil
+	}
+	return b.String(), nil
+}
+
+// Parse parses a translatable tag.
+func (e *InternationalizationExtension) Parse(p extensions.IParser) ([]nodes.Node, error) {
+	stream := p.Stream()
+	lineno := stream.Next().Lineno
+
+	var msgctxt *string
+	if tok := stream.NextIf(lexer.TokenString); tok != nil {
+		s := tok.Value.(string)
+		msgctxt = &s
+	}
+
+	// find all the variables referenced. Additionally, a variable can be
+	// defined in the body of the trans block too, but this is checked at
+	// a later state.
+	var pluralExpr nodes.Expr
+	var pluralExprAssignment nodes.Node
+	numCalledNum := false
+	variables := make(map[string]nodes.Expr)
+	var variableNames []string
+	var trimmed *bool
+
+	for stream.Current().Type != lexer.TokenBlockEnd {
+		if len(variables) > 0 {
+			if _, err := stream.Expect(lexer.TokenComma); err != nil {
+				return nil, err
+			}
+		}
+		// skip colon for python compatibility
+		if stream.SkipIf(lexer.TokenColon) {
+			break
+		}
+		token, err := stream.Expect(lexer.TokenName)
+		if err != nil {
+			return nil, err
+		}
+		name := token.Value.(string)
+		if _, ok := variables[name]; ok {
+			return nil, p.Fail(fmt.Sprintf("translatable variable '%s' defined twice.", name), &token.Lineno)
+		}
+
+		var variable nodes.Expr
+		if stream.Current().Type == lexer.TokenAssign {
+			stream.Next()
+			if variable, err = p.ParseExpression(true); err != nil {
+				return nil, err
+			}
+		} else if trimmed == nil && (name == "trimmed" || name == "notrimmed") {
+			t := name == "trimmed"
+			trimmed = &t
+			continue
+		} else {
+			variable = &nodes.Name{Name: name, Ctx: "load", ExprCommon: nodes.ExprCommon{Lineno: token.Lineno}}
+		}
+		variables[name] = variable
+		variableNames = append(variableNames, name)
+
+		if pluralExpr == nil {
+			if _, ok := variable.(*nodes.Call); ok {
+				target := p.FreeIdentifier(&token.Lineno)
+				pluralExpr = target
+				variables[name] = target
+				pluralExprAssignment = &nodes.Assign{
+					Target:     target,
+					Node:       variable,
+					StmtCommon: nodes.StmtCommon{Lineno: lineno},
+				}
+			} else {
+				pluralExpr = variable
+			}
+			numCalledNum = name == "num"
+		}
+	}
+	if _, err := stream.Expect(lexer.TokenBlockEnd); err != nil {
+		return nil, err
+	}
+
+	var plural *string
+	havePlural := false
+	var referenced []string
+
+	// now parse until endtrans or pluralize
+	singularNames, singular, err := parseTransBlock(p, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(singularNames) > 0 {
+		referenced = append(referenced, singularNames...)
+		if pluralExpr == nil {
+			pluralExpr = &nodes.Name{Name: singularNames[0], Ctx: "load", ExprCommon: nodes.ExprCommon{Lineno: lineno}}
+			numCalledNum = singularNames[0] == "num"
+		}
+	}
+
+	// if we have a pluralize block, we parse that too
+	if stream.Current().Test("name:pluralize") {
+		havePlural = true
+		stream.Next()
+		if stream.Current().Type != lexer.TokenBlockEnd {
+			token, err := stream.Expect(lexer.TokenName)
+			if err != nil {
+				return nil, err
+			}
+			name := token.Value.(string)
+			variable, ok := variables[name]
+			if !ok {
+				return nil, p.Fail(fmt.Sprintf("unknown variable '%s' for pluralization", name), &token.Lineno)
+			}
+			pluralExpr = variable
+			numCalledNum = name == "num"
+		}
+		if _, err := stream.Expect(lexer.TokenBlockEnd); err != nil {
+			return nil, err
+		}
+		pluralNames, pluralBody, err := parseTransBlock(p, false)
+		if err != nil {
+			return nil, err
+		}
+		stream.Next()
+		referenced = append(referenced, pluralNames...)
+		plural = &pluralBody
+	} else {
+		stream.Next()
+	}
+
+	// register free names as simple name expressions
+	for _, name := range referenced {
+		if _, ok := variables[name]; !ok {
+			variables[name] = &nodes.Name{Name: name, Ctx: "load", ExprCommon: nodes.ExprCommon{Lineno: lineno}}
+			variableNames = append(variableNames, name)
+		}
+	}
+
+	if !havePlural {
+		pluralExpr = nil
+	} else if pluralExpr == nil {
+		return nil, p.Fail("pluralize without variables", &lineno)
+	}
+
+	if trimmed == nil {
+		t, _ := e.env.Policies["ext.i18n.trimmed"].(bool)
+		trimmed = &t
+	}
+	if *trimmed {
+		singular = trimWhitespace(singular)
+		if plural != nil {
+			trimmedPlural := trimWhitespace(*plural)
+			plural = &trimmedPlural
+		}
+	}
+
+	node := makeTransNode(singular, plural, msgctxt, variables, variableNames, pluralExpr, numCalledNum && havePlural, lineno)
+	if pluralExprAssignment != nil {
+		return []nodes.Node{pluralExprAssignment, node}, nil
+	}
+	return []nodes.Node{node}, nil
+}
+
+var whitespaceRe = regexp.MustCompile(`\s*\n\s*`)
+
+func trimWhitespace(s string) string {
+	return whitespaceRe.ReplaceAllString(strings.TrimSpace(s), " ")
+}
+
+// parseTransBlock parses until the next `endtrans` or `pluralize` tag and
+// returns the names of the referenced variables and the message.
+func parseTransBlock(p extensions.IParser, allowPluralize bool) ([]string, string, error) {
+	stream := p.Stream()
+	var referenced []string
+	var buf strings.Builder
+
+	for {
+		switch current := stream.Current(); {
+		case current.Type == lexer.TokenData:
+			buf.WriteString(strings.ReplaceAll(current.Value.(string), "%", "%%"))
+			stream.Next()
+		case current.Type == lexer.TokenVariableBegin:
+			stream.Next()
+			token, err := stream.Expect(lexer.TokenName)
+			if err != nil {
+				return nil, "", err
+			}
+			name := token.Value.(string)
+			referenced = append(referenced, name)
+			buf.WriteString(fmt.Sprintf("%%(%s)s", name))
+			if _, err := stream.Expect(lexer.TokenVariableEnd); err != nil {
+				return nil, "", err
+			}
+		case current.Type == lexer.TokenBlockBegin:
+			stream.Next()
+			var blockName string
+			if stream.Current().Type == lexer.TokenName {
+				blockName = stream.Current().Value.(string)
+			}
+			switch blockName {
+			case "endtrans":
+				return referenced, buf.String(), nil
+			case "pluralize":
+				if allowPluralize {
+					return referenced, buf.String(), nil
+				}
+				return nil, "", p.Fail("a translatable section can have only one pluralize section", nil)
+			case "trans":
+				return nil, "", p.Fail("trans blocks can't be nested; did you mean `endtrans`?", nil)
+			}
+			return nil, "", p.Fail(fmt.Sprintf("control structures in translatable sections are not allowed; saw `%s`", blockName), nil)
+		case stream.Eos():
+			return nil, "", p.Fail("unclosed translation block", nil)
+		default:
+			return nil, "", p.Fail("internal parser error", nil)
+		}
+	}
+}
+
+// makeTransNode generates a useful node from the data provided.
+func makeTransNode(singular string, plural *string, msgctxt *string, variables map[string]nodes.Expr, variableNames []string, pluralExpr nodes.Expr, numCalledNum bool, lineno int) nodes.Node {
+	constant := func(v any) nodes.Expr {
+		return &nodes.Const{Value: v, LiteralCommon: nodes.LiteralCommon{Lineno: lineno}}
+	}
+
+	funcName := "gettext"
+	args := []nodes.Expr{constant(singular)}
+	if msgctxt != nil {
+		args = append([]nodes.Expr{constant(*msgctxt)}, args...)
+		funcName = "p" + funcName
+	}
+	if pluralExpr != nil {
+		funcName = "n" + funcName
+		args = append(args, constant(*plural), pluralExpr)
+	}
+
+	call := &nodes.Call{
+		Node:       &nodes.Name{Name: funcName, Ctx: "load", ExprCommon: nodes.ExprCommon{Lineno: lineno}},
+		Args:       args,
+		ExprCommon: nodes.ExprCommon{Lineno: lineno},
+	}
+	for _, name := range variableNames {
+		// the function adds that later anyway in case num was called num,
+		// so just skip it.
+		if numCalledNum && name == "num" {
+			continue
+		}
+		call.Kwargs = append(call.Kwargs, nodes.Keyword{
+			Key:          name,
+			Value:        variables[name],
+			HelperCommon: nodes.HelperCommon{Lineno: lineno},
+		})
+	}
+	return &nodes.Output{
+		Nodes:      []nodes.Expr{call},
+		StmtCommon: nodes.StmtCommon{Lineno: lineno},
+	}
+}
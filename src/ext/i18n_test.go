@@ -0,0 +1,222 @@
+package ext
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/runtime"
+)
+
+// buildMO creates the contents of a little endian `.mo` file with the messages.
+func buildMO(messages map[string]string) []byte {
+	keys := make([]string, 0, len(messages))
+	for k := range messages {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	n := uint32(len(keys))
+	origTable := uint32(28)
+	transTable := origTable + 8*n
+	offset := transTable + 8*n
+	header := make([]byte, offset)
+	var strs []byte
+	put := func(table uint32, i int, s string) {
+		binary.LittleEndian.PutUint32(header[table+uint32(i)*8:], uint32(len(s)))
+		binary.LittleEndian.PutUint32(header[table+uint32(i)*8+4:], offset+uint32(len(strs)))
+		strs = append(strs, s...)
+		strs = append(strs, 0)
+	}
+	binary.LittleEndian.PutUint32(header[0:], moMagic)
+	binary.LittleEndian.PutUint32(header[8:], n)
+	binary.LittleEndian.PutUint32(header[12:], origTable)
+	binary.LittleEndian.PutUint32(header[16:], transTable)
+	for i, k := range keys {
+		put(origTable, i, k)
+	}
+	for i, k := range keys {
+		put(transTable, i, messages[k])
+	}
+	return append(header, strs...)
+}
+
+var germanMessages = map[string]string{
+	"":                                "Content-Type: text/plain; charset=UTF-8\nPlural-Forms: nplurals=2; plural=(n != 1);\n",
+	"Hello %(user)s!":                 "Hallo %(user)s!",
+	"%(num)s apple\x00%(num)s apples": "%(num)s Apfel\x00%(num)s Äpfel",
+	"menu\x04Open":                    "Öffnen",
+	"Watch out":                       "Achtung",
+}
+
+func newI18nEnv(t *testing.T, autoescape bool) *environment.Environment {
+	opts := environment.DefaultEnvOpts()
+	opts.AutoEscape = autoescape
+	opts.Extensions = map[string]func(*environment.Environment) extensions.IExtension{"i18n": I18n}
+	env, err := environment.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func render(t *testing.T, env *environment.Environment, source string, vars map[string]any) string {
+	tmpl, err := env.FromString(source, nil)
+	if err != nil {
+		t.Fatalf("%q: %v", source, err)
+	}
+	res, err := tmpl.Render(vars)
+	if err != nil {
+		t.Fatalf("%q: %v", source, err)
+	}
+	return res
+}
+
+func TestTrans(t *testing.T) {
+	catalog, err := ParseMO(buildMO(germanMessages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := newI18nEnv(t, false)
+	env.Extensions["i18n"].(*InternationalizationExtension).InstallGettextTranslations(catalog)
+	env.Filters["length"] = func(args []any, _ map[string]any) any {
+		n, err := runtime.Len(args[0])
+		if err != nil {
+			return err
+		}
+		return n
+	}
+
+	cases := []struct {
+		source   string
+		vars     map[string]any
+		expected string
+	}{
+		{"{% trans %}Hello {{ user }}!{% endtrans %}", map[string]any{"user": "Ann"}, "Hallo Ann!"},
+		{"{% trans user=name %}Hello {{ user }}!{% endtrans %}", map[string]any{"name": "Bob"}, "Hallo Bob!"},
+		{"{% trans %}Untranslated 100%{% endtrans %}", nil, "Untranslated 100%"},
+		{"{% trans count=1 %}{{ count }} apple{% pluralize %}{{ count }} apples{% endtrans %}", nil, "1 apple"},
+		{"{% trans num=n %}{{ num }} apple{% pluralize %}{{ num }} apples{% endtrans %}", map[string]any{"n": 1}, "1 Apfel"},
+		{"{% trans num=n %}{{ num }} apple{% pluralize %}{{ num }} apples{% endtrans %}", map[string]any{"n": 3}, "3 Äpfel"},
+		{"{% trans num=items|length %}{{ num }} apple{% pluralize num %}{{ num }} apples{% endtrans %}", map[string]any{"items": []int{1, 2}}, "2 Äpfel"},
+		{"{% trans num=count() %}{{ num }} apple{% pluralize %}{{ num }} apples{% endtrans %}", map[string]any{"count": func() int { return 5 }}, "5 Äpfel"},
+		{"{% trans trimmed %}\n  Watch\n  out\n{% endtrans %}", nil, "Achtung"},
+		{"{% trans notrimmed %}\n  Watch out{% endtrans %}", nil, "\n  Watch out"},
+		{`{% trans "menu" %}Open{% endtrans %}`, nil, "Öffnen"},
+		{"{{ _('Hello %(user)s!', user='Eve') }}", nil, "Hallo Eve!"},
+		{"{{ gettext('Watch out') }}", nil, "Achtung"},
+		{"{{ ngettext('%(num)s apple', '%(num)s apples', 2) }}", nil, "2 Äpfel"},
+		{"{{ pgettext('menu', 'Open') }}", nil, "Öffnen"},
+		{"{{ npgettext('menu', 'file', 'files', 2) }}", nil, "files"},
+	}
+	for _, c := range cases {
+		if res := render(t, env, c.source, c.vars); res != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, res)
+		}
+	}
+}
+
+func TestTransTrimmedPolicy(t *testing.T) {
+	env := newI18nEnv(t, false)
+	env.Extensions["i18n"].(*InternationalizationExtension).InstallNullTranslations()
+	env.Policies["ext.i18n.trimmed"] = true
+	if res := render(t, env, "{% trans %}  a\n  b  {% endtrans %}", nil); res != "a b" {
+		t.Fatalf("unexpected output %q", res)
+	}
+	if res := render(t, env, "{% trans notrimmed %} a {% endtrans %}", nil); res != " a " {
+		t.Fatalf("unexpected output %q", res)
+	}
+}
+
+func TestTransAutoescape(t *testing.T) {
+	env := newI18nEnv(t, true)
+	env.Extensions["i18n"].(*InternationalizationExtension).InstallNullTranslations()
+	res := render(t, env, "{% trans %}<b>{{ user }}</b>{% endtrans %}", map[string]any{"user": "<i>"})
+	if res != "<b>&lt;i&gt;</b>" {
+		t.Fatalf("unexpected output %q", res)
+	}
+}
+
+func TestTransErrors(t *testing.T) {
+	env := newI18nEnv(t, false)
+	cases := map[string]string{
+		"{% trans %}{% if x %}{% endif %}{% endtrans %}":             "control structures in translatable sections are not allowed",
+		"{% trans %}{% trans %}{% endtrans %}":                       "trans blocks can't be nested",
+		"{% trans a=1, a=2 %}{% endtrans %}":                         "translatable variable 'a' defined twice",
+		"{% trans %}a{% pluralize x %}b{% endtrans %}":               "unknown variable 'x' for pluralization",
+		"{% trans %}a{% pluralize %}b{% endtrans %}":                 "pluralize without variables",
+		"{% trans %}a{% pluralize %}b{% pluralize %}c{% endtrans %}": "only one pluralize section",
+		"{% trans %}unclosed":                                        "unclosed translation block",
+	}
+	for source, msg := range cases {
+		if _, err := env.FromString(source, nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: expected error containing %q, got %v", source, msg, err)
+		}
+	}
+
+	tmpl, err := env.FromString("{% trans %}a{% endtrans %}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(nil); err == nil {
+		t.Fatal("expected error for uninstalled translations")
+	}
+}
+
+func TestPluralForms(t *testing.T) {
+	polish, err := compilePluralForms("(n==1 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int64]int64{1: 0, 2: 1, 4: 1, 5: 2, 12: 2, 22: 1, 25: 2, 0: 2}
+	for n, form := range expected {
+		if got := polish(n); got != form {
+			t.Errorf("n=%d: expected form %d, got %d", n, form, got)
+		}
+	}
+	if _, err := compilePluralForms("n ? 1"); err == nil {
+		t.Fatal("expected error for invalid expression")
+	}
+}
+
+func TestLoadTranslations(t *testing.T) {
+	dir := t.TempDir()
+	write := func(lang string, messages map[string]string) {
+		path := filepath.Join(dir, lang, "LC_MESSAGES")
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "messages.mo"), buildMO(messages), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("de", germanMessages)
+	write("de_AT", map[string]string{"Watch out": "Obacht"})
+
+	catalog, err := LoadTranslations(dir, "messages", "de_AT.UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := catalog.Gettext("Watch out"); res != "Obacht" {
+		t.Fatalf("expected translation of the most specific locale, got %q", res)
+	}
+	if res := catalog.Gettext("Hello %(user)s!"); res != "Hallo %(user)s!" {
+		t.Fatalf("expected fallback translation, got %q", res)
+	}
+	if res := catalog.Ngettext("%(num)s apple", "%(num)s apples", 1); res != "%(num)s Apfel" {
+		t.Fatalf("unexpected plural translation %q", res)
+	}
+
+	_, err = LoadTranslations(dir, "messages", "fr")
+	if err == nil {
+		t.Fatal("expected not found error for a missing locale")
+	}
+	if !os.IsNotExist(err) && !strings.Contains(err.Error(), "no translation file found") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package ext
+
+import (
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/extensions"
+	"github.com/gojinja/gojinja/src/nodes"
+)
+
+// LoopControlsExtension adds `{% break %}` and `{% continue %}` support to
+// the template engine. Using them outside of a for loop is a syntax error.
+type LoopControlsExtension struct {
+	extensions.Extension
+}
+
+var _ extensions.IExtension = &LoopControlsExtension{}
+
+// LoopControls creates the loop controls extension, use it as a constructor in `EnvOpts.Extensions`.
+func LoopControls(*environment.Environment) extensions.IExtension {
+	return &LoopControlsExtension{}
+}
+
+func (*LoopControlsExtension) Tags() []string {
+	return []string{"break", "continue"}
+}
+
+func (*LoopControlsExtension) Parse(p extensions.IParser) ([]nodes.Node, error) {
+	token := p.Stream().Next()
+	if token.Value == "break" {
+		return []nodes.Node{&nodes.Break{StmtCommon: nodes.StmtCommon{Lineno: token.Lineno}}}, nil
+	}
+	return []nodes.Node{&nodes.Continue{StmtCommon: nodes.StmtCommon{Lineno: token.Lineno}}}, nil
+}
@@ -0,0 +1,78 @@
+package ext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gojinja/gojinja/src/environment"
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/extensions"
+)
+
+func newLoopControlsEnv(t *testing.T) *environment.Environment {
+	opts := environment.DefaultEnvOpts()
+	opts.Extensions = map[string]func(*environment.Environment) extensions.IExtension{"loopcontrols": LoopControls}
+	env, err := environment.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestLoopControls(t *testing.T) {
+	env := newLoopControlsEnv(t)
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"{% for i in range %}{% if i == 3 %}{% break %}{% endif %}{{ i }}{% endfor %}", "012"},
+		{"{% for i in range %}{% if i is odd %}{% continue %}{% endif %}{{ i }}{% endfor %}", "024"},
+		{"{% for i in range %}{% with x = i %}{% if x > 1 %}{% break %}{% endif %}{{ x }}{% endwith %}{% endfor %}", "01"},
+		{"{% for i in range %}{% for j in range %}{% if j > i %}{% break %}{% endif %}{{ j }}{% endfor %};{% endfor %}", "0;01;012;0123;01234;"},
+		{"{% for i in range %}{% break %}{% else %}empty{% endfor %}done", "done"},
+		{"{% for i in range %}{% set x %}{{ i }}{% continue %}{% endset %}{{ i }}{% endfor %}", ""},
+		{"{% for i in range if i is odd %}{{ i }}{{ '.' if loop.last else ',' }}{% endfor %}", "1,3."},
+		{"{% for i in range %}{% for j in [] %}{% else %}{% if i == 1 %}{% break %}{% endif %}{% endfor %}{{ i }}{% endfor %}", "0"},
+	}
+	for _, c := range cases {
+		if res := render(t, env, c.source, map[string]any{"range": []int{0, 1, 2, 3, 4}}); res != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, res)
+		}
+	}
+}
+
+func TestBreakInfiniteIterator(t *testing.T) {
+	env := newLoopControlsEnv(t)
+	stopped := make(chan struct{})
+	naturals := func(yield func(int) bool) {
+		defer close(stopped)
+		for i := 0; yield(i); i++ {
+		}
+	}
+	res := render(t, env, "{% for i in naturals %}{% if loop.index > 3 %}{% break %}{% endif %}{{ i }}{% endfor %}", map[string]any{"naturals": naturals})
+	if res != "012" {
+		t.Fatalf("unexpected output %q", res)
+	}
+	<-stopped
+}
+
+func TestLoopControlsOutsideLoop(t *testing.T) {
+	env := newLoopControlsEnv(t)
+	cases := map[string]int{
+		"{% break %}": 1,
+		"{% for i in x %}\n{% else %}\n{% continue %}\n{% endfor %}":             3,
+		"{% for i in x %}\n{% block b %}\n{% break %}{% endblock %}{% endfor %}": 3,
+		"a\n{% if x %}\n{% continue %}{% endif %}":                               3,
+	}
+	for source, lineno := range cases {
+		_, err := env.FromString(source, nil)
+		syntaxErr, ok := err.(*errors.TemplateSyntaxError)
+		if !ok {
+			t.Errorf("%q: expected syntax error, got %v", source, err)
+			continue
+		}
+		if syntaxErr.Lineno != lineno || !strings.Contains(err.Error(), "outside of a loop") {
+			t.Errorf("%q: unexpected error %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package ext
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keywordSpecs describe which arguments of the default gettext functions hold
+// the message id, the plural and the context (1-based, 0 if not present).
+var keywordSpecs = map[string]struct{ id, plural, context int }{
+	"_":         {id: 1},
+	"gettext":   {id: 1},
+	"ngettext":  {id: 1, plural: 2},
+	"pgettext":  {id: 2, context: 1},
+	"npgettext": {id: 2, plural: 3, context: 1},
+}
+
+// pythonFormatRe matches the placeholders interpolated by the newstyle gettext functions.
+var pythonFormatRe = regexp.MustCompile(`%\([^)]+\)[sdi]`)
+
+type potLocation struct {
+	filename string
+	lineno   int
+}
+
+type potEntry struct {
+	id        string
+	plural    *string
+	context   *string
+	locations []potLocation
+	comments  []string
+}
+
+// Pot collects extracted messages and writes them as a gettext `.pot` file.
+// Messages with the same id, plural and context are merged.
+type Pot struct {
+	// Project is written to the `Project-Id-Version` header.
+	Project string
+	// Version is written to the `Project-Id-Version` header.
+	Version string
+	// CreationDate is written to the `POT-Creation-Date` header, the time
+	// of writing if zero.
+	CreationDate time.Time
+
+	entries []*potEntry
+	index   map[string]*potEntry
+}
+
+// NewPot creates an empty `.pot` file.
+func NewPot() *Pot {
+	return &Pot{index: map[string]*potEntry{}}
+}
+
+// Add adds the messages extracted from the file. Functions other than the
+// default gettext functions take the message id from their first argument.
+// Messages with a non constant id, plural or context are skipped.
+func (p *Pot) Add(filename string, messages []ExtractedMessage) {
+	arg := func(m ExtractedMessage, i int) *string {
+		if i == 0 || i > len(m.Strings) {
+			return nil
+		}
+		return m.Strings[i-1]
+	}
+
+	for _, m := range messages {
+		spec, ok := keywordSpecs[m.Function]
+		if !ok {
+			spec.id = 1
+		}
+		id := arg(m, spec.id)
+		if id == nil || *id == "" {
+			continue
+		}
+		plural := arg(m, spec.plural)
+		context := arg(m, spec.context)
+		if (spec.plural != 0 && plural == nil) || (spec.context != 0 && context == nil) {
+			continue
+		}
+
+		key := *id
+		if plural != nil {
+			key += "\x00" + *plural
+		}
+		if context != nil {
+			key = *context + "\x04" + key
+		}
+		entry, ok := p.index[key]
+		if !ok {
+			entry = &potEntry{id: *id, plural: plural, context: context}
+			p.index[key] = entry
+			p.entries = append(p.entries, entry)
+		}
+		entry.locations = append(entry.locations, potLocation{filename: filename, lineno: m.Lineno})
+		for _, comment := range m.Comments {
+			if !containsString(entry.comments, comment) {
+				entry.comments = append(entry.comments, comment)
+			}
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo writes the `.pot` file. The messages are written in the order they were first added.
+func (p *Pot) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	b := bufio.NewWriter(cw)
+
+	project, version := p.Project, p.Version
+	if project == "" {
+		project = "PROJECT"
+	}
+	if version == "" {
+		version = "VERSION"
+	}
+	date := p.CreationDate
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	fmt.Fprintf(b, "# Translations template for %s.\n", project)
+	b.WriteString("#\n#, fuzzy\n")
+	writePoString(b, "msgid", "")
+	writePoString(b, "msgstr", strings.Join([]string{
+		fmt.Sprintf("Project-Id-Version: %s %s\n", project, version),
+		"Report-Msgid-Bugs-To: \n",
+		fmt.Sprintf("POT-Creation-Date: %s\n", date.Format("2006-01-02 15:04-0700")),
+		"PO-Revision-Date: YEAR-MO-DA HO:MI+ZONE\n",
+		"Last-Translator: FULL NAME <EMAIL@ADDRESS>\n",
+		"Language-Team: LANGUAGE <LL@li.org>\n",
+		"MIME-Version: 1.0\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"Content-Transfer-Encoding: 8bit\n",
+	}, ""))
+
+	for _, entry := range p.entries {
+		b.WriteString("\n")
+		for _, comment := range entry.comments {
+			for _, line := range strings.Split(comment, "\n") {
+				fmt.Fprintf(b, "#. %s\n", strings.TrimSpace(line))
+			}
+		}
+		locations := make([]string, len(entry.locations))
+		for i, loc := range entry.locations {
+			locations[i] = loc.filename + ":" + strconv.Itoa(loc.lineno)
+		}
+		fmt.Fprintf(b, "#: %s\n", strings.Join(locations, " "))
+		if pythonFormatRe.MatchString(entry.id) || (entry.plural != nil && pythonFormatRe.MatchString(*entry.plural)) {
+			b.WriteString("#, python-format\n")
+		}
+		if entry.context != nil {
+			writePoString(b, "msgctxt", *entry.context)
+		}
+		writePoString(b, "msgid", entry.id)
+		if entry.plural != nil {
+			writePoString(b, "msgid_plural", *entry.plural)
+			writePoString(b, "msgstr[0]", "")
+			writePoString(b, "msgstr[1]", "")
+		} else {
+			writePoString(b, "msgstr", "")
+		}
+	}
+
+	if err := b.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\t", `\t`, "\r", `\r`, "\n", `\n`)
+
+// writePoString writes a keyword with a quoted string. Multiline strings are
+// split after each newline.
+func writePoString(w *bufio.Writer, keyword string, s string) {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= 1 {
+		fmt.Fprintf(w, "%s \"%s\"\n", keyword, poEscaper.Replace(s))
+		return
+	}
+	fmt.Fprintf(w, "%s \"\"\n", keyword)
+	for _, line := range lines {
+		fmt.Fprintf(w, "\"%s\"\n", poEscaper.Replace(line))
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,12 +1,63 @@
 package extensions
 
-import "github.com/gojinja/gojinja/src/nodes"
+import (
+	"github.com/gojinja/gojinja/src/lexer"
+	"github.com/gojinja/gojinja/src/nodes"
+)
 
+// IParser is the part of the parser that is available to extensions.
 type IParser interface {
 	Parse() (*nodes.Template, error)
+	Stream() *lexer.TokenStream
+	ParseExpression(withCondexpr bool) (nodes.Expr, error)
+	ParseTuple(simplified bool, withCondexpr bool, extraEndRules []string, explicitParentheses bool) (nodes.Expr, error)
+	ParseStatements(endTokens []string, dropNeedle bool) ([]nodes.Node, error)
+	Fail(msg string, lineno *int) error
+	FreeIdentifier(lineno *int) *nodes.InternalName
 }
 
+// IExtension is implemented by extensions. Extensions are created with the
+// environment they are bound to, that is the place to add filters, tests,
+// globals or environment attributes the extension needs.
+//
+// Embed `Extension` to get default implementations of all methods.
 type IExtension interface {
+	// Tags returns the names of the tags the extension parses.
 	Tags() []string
+	// Parse is called with the parser if one of the tags of the extension
+	// matched. The current token of the parser's stream is the name token
+	// of the matched tag.
 	Parse(p IParser) ([]nodes.Node, error)
+	// Preprocess is called before the actual lexing and can be used to
+	// preprocess the source.
+	Preprocess(source string, name *string, filename *string) string
+	// FilterStream is passed the token stream that can be used to filter
+	// tokens returned. It returns either the same or a new token stream.
+	FilterStream(stream *lexer.TokenStream) (*lexer.TokenStream, error)
+	// Priority returns the priority of the extension. Extensions with lower
+	// priorities run first.
+	Priority() int
+}
+
+// Extension provides no-op implementations of the methods of `IExtension`.
+type Extension struct{}
+
+func (Extension) Tags() []string {
+	return nil
+}
+
+func (Extension) Parse(p IParser) ([]nodes.Node, error) {
+	return nil, p.Fail("extension doesn't parse any tags", nil)
+}
+
+func (Extension) Preprocess(source string, _ *string, _ *string) string {
+	return source
+}
+
+func (Extension) FilterStream(stream *lexer.TokenStream) (*lexer.TokenStream, error) {
+	return stream, nil
+}
+
+func (Extension) Priority() int {
+	return 100
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/gojinja/gojinja/src/utils/identifier"
 	"github.com/gojinja/gojinja/src/utils/stack"
 	"github.com/hashicorp/golang-lru"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -181,10 +182,11 @@ func (l *Lexer) Tokenize(source string, name *string, filename *string, state *s
 	return NewTokenStream(wrapped, name, filename), nil
 }
 
-type tokenRaw struct {
-	lineno   int
-	token    string
-	valueStr string
+// RawToken is a token as returned by `Tokeniter`, before its value is converted.
+type RawToken struct {
+	Lineno int
+	Type   string
+	Value  string
 }
 
 // OptionalLStrip is used for marking a point in the state that can have lstrip applied.
@@ -192,16 +194,16 @@ type OptionalLStrip struct{ data []string }
 
 // Wrap is called with the stream as returned by `tokenize` and wraps
 // every token in a `Token` and converts the value.
-func (l *Lexer) Wrap(stream []tokenRaw, name *string, filename *string) ([]Token, error) {
+func (l *Lexer) Wrap(stream []RawToken, name *string, filename *string) ([]Token, error) {
 	ret := make([]Token, 0, len(stream))
 	for _, raw := range stream {
-		if ignoredTokens.Has(raw.token) {
+		if ignoredTokens.Has(raw.Type) {
 			continue
 		}
 
-		var value any = raw.valueStr
-		token := raw.token
-		switch raw.token {
+		var value any = raw.Value
+		token := raw.Type
+		switch raw.Type {
 		case TokenLinestatementBegin:
 			token = TokenBlockBegin
 		case TokenLinestatementEnd:
@@ -209,39 +211,45 @@ func (l *Lexer) Wrap(stream []tokenRaw, name *string, filename *string) ([]Token
 		case TokenRawBegin, TokenRawEnd:
 			continue
 		case TokenData:
-			value = l.normalizeNewlines(raw.valueStr)
+			value = l.normalizeNewlines(raw.Value)
 		case "keyword":
-			token = raw.valueStr
+			token = raw.Value
 		case TokenName:
-			if !identifier.IsIdentifier(raw.valueStr) {
-				return nil, errors.TemplateSyntaxError("Invalid character in identifier", raw.lineno, name, filename)
+			if !identifier.IsIdentifier(raw.Value) {
+				return nil, errors.NewTemplateSyntaxError("Invalid character in identifier", raw.Lineno, name, filename)
 			}
 		case TokenString:
-			value = unescapeString(l.normalizeNewlines(raw.valueStr[1 : len(raw.valueStr)-1]))
+			value = unescapeString(l.normalizeNewlines(raw.Value[1 : len(raw.Value)-1]))
 		case TokenInteger:
-			v, err := strconv.ParseInt(strings.Replace(raw.valueStr, "_", "", -1), 0, 64)
-			if err != nil {
+			v, err := strconv.ParseInt(strings.Replace(raw.Value, "_", "", -1), 0, 64)
+			if err == nil {
+				value = v
+				break
+			}
+			// Integers that don't fit into an int64 become arbitrary precision.
+			b, ok := new(big.Int).SetString(strings.Replace(raw.Value, "_", "", -1), 0)
+			if !ok {
 				return nil, err
 			}
-			value = v
+			value = b
 		case TokenFloat:
 			// TODO change to `ast.literal_eval`
-			v, err := strconv.ParseFloat(strings.Replace(raw.valueStr, "_", "", -1), 64)
+			v, err := strconv.ParseFloat(strings.Replace(raw.Value, "_", "", -1), 64)
 			if err != nil {
 				return nil, err
 			}
 			value = v
 		case TokenOperator:
-			token = operators[raw.valueStr]
+			token = operators[raw.Value]
 		}
-		ret = append(ret, Token{raw.lineno, token, value})
+		ret = append(ret, Token{raw.Lineno, token, value})
 	}
 	return ret, nil
 }
 
 // Tokeniter tokenizes the text and returns the tokens.
 // Use this method if you just want to tokenize a template.
-func (l *Lexer) Tokeniter(source string, name *string, filename *string, state *string) (ret []tokenRaw, err error) {
+func (l *Lexer) Tokeniter(source string, name *string, filename *string, state *string) (ret []RawToken, err error) {
 	lines := newlineRe.Split(source, -1)
 	if !l.keepTrailingNewline && lines[len(lines)-1] == "" {
 		lines = lines[:len(lines)-1]
@@ -340,7 +348,7 @@ func (l *Lexer) Tokeniter(source string, name *string, filename *string, state *
 						found := false
 						for i := 0; i < len(names); i++ {
 							if names[i] != "" && groups[i] != "" {
-								ret = append(ret, tokenRaw{lineno, names[i], groups[i]})
+								ret = append(ret, RawToken{lineno, names[i], groups[i]})
 								lineno += strings.Count(groups[i], "\n")
 								found = true
 								break
@@ -353,7 +361,7 @@ func (l *Lexer) Tokeniter(source string, name *string, filename *string, state *
 						// normal group
 						data := groups[idx]
 						if data != "" || !ignoreIfEmpty.Has(token) {
-							ret = append(ret, tokenRaw{lineno, token, data})
+							ret = append(ret, RawToken{lineno, token, data})
 						}
 						lineno += strings.Count(data, "\n") + newlinesStripped
 						newlinesStripped = 0
@@ -376,17 +384,17 @@ func (l *Lexer) Tokeniter(source string, name *string, filename *string, state *
 					case "}", ")", "]":
 						exOp := balancingStack.Pop()
 						if exOp == nil {
-							return nil, errors.TemplateSyntaxError(fmt.Sprintf("unexpected '%s'", data), lineno, name, filename)
+							return nil, errors.NewTemplateSyntaxError(fmt.Sprintf("unexpected '%s'", data), lineno, name, filename)
 						}
 						if *exOp != data {
-							return nil, errors.TemplateSyntaxError(fmt.Sprintf("unexpected '%s', expected '%s'", data, *exOp), lineno, name, filename)
+							return nil, errors.NewTemplateSyntaxError(fmt.Sprintf("unexpected '%s', expected '%s'", data, *exOp), lineno, name, filename)
 						}
 					}
 				}
 
 				// yield items
 				if data != "" || !ignoreIfEmpty.Has(toks) {
-					ret = append(ret, tokenRaw{lineno, toks, data})
+					ret = append(ret, RawToken{lineno, toks, data})
 				}
 				lineno += strings.Count(data, "\n")
 			} else {
@@ -441,7 +449,7 @@ func (l *Lexer) Tokeniter(source string, name *string, filename *string, state *
 	if pos >= sourceLength {
 		return
 	}
-	return nil, errors.TemplateSyntaxError(fmt.Sprintf("unexpected char '%s' at %d", string(source[pos]), pos), lineno, name, filename)
+	return nil, errors.NewTemplateSyntaxError(fmt.Sprintf("unexpected char '%s' at %d", string(source[pos]), pos), lineno, name, filename)
 }
 
 // Failure is used by the `Lexer` to specify known errors.
@@ -451,7 +459,7 @@ type Failure struct {
 
 func (f Failure) Error(lineno int, filename *string) error {
 	// I do not undestand why filename is passed as name and not filename but that what jinja does.
-	return errors.TemplateSyntaxError(f.msg, lineno, filename, filename)
+	return errors.NewTemplateSyntaxError(f.msg, lineno, filename, filename)
 }
 
 func toToks(tokens any) ([]string, bool) {
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"math/big"
 	"reflect"
 	"testing"
 )
@@ -45,6 +46,16 @@ my name is {{ name }}
 			{4, TokenVariableEnd, "}}"},
 		},
 	},
+	{input: `{{ 9223372036854775807 }}{{ 9_223_372_036_854_775_808 }}`,
+		res: []Token{
+			{1, TokenVariableBegin, "{{"},
+			{1, TokenInteger, int64(9223372036854775807)},
+			{1, TokenVariableEnd, "}}"},
+			{1, TokenVariableBegin, "{{"},
+			{1, TokenInteger, new(big.Int).Lsh(big.NewInt(1), 63)},
+			{1, TokenVariableEnd, "}}"},
+		},
+	},
 }
 
 func Test(t *testing.T) {
@@ -60,10 +60,10 @@ func (ts TokenStream) Current() Token {
 }
 
 func (ts TokenStream) Look() Token {
-	if ts.idx+1 < len(ts.tokens) {
-		return ts.tokens[ts.idx+1]
+	if ts.idx < len(ts.tokens) {
+		return ts.tokens[ts.idx]
 	}
-	return ts.current
+	return Token{ts.current.Lineno, TokenEOF, ""}
 }
 
 func (ts *TokenStream) Skip(n int) {
@@ -89,14 +89,14 @@ func (ts *TokenStream) Expect(expr string) (*Token, error) {
 		desc := DescribeTokenExpr(expr)
 
 		if ts.current.Type == TokenEOF {
-			return nil, errors.TemplateSyntaxError(
+			return nil, errors.NewTemplateSyntaxError(
 				fmt.Sprintf("unexpected end of template, expected '%s'.", desc),
 				ts.current.Lineno,
 				ts.name,
 				ts.filename,
 			)
 		}
-		return nil, errors.TemplateSyntaxError(
+		return nil, errors.NewTemplateSyntaxError(
 			fmt.Sprintf("expected token '%s', got '%s'", desc, DescribeToken(ts.current)),
 			ts.current.Lineno,
 			ts.name,
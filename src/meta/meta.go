@@ -0,0 +1,45 @@
+// Package meta contains functions that help inspecting the abstract syntax
+// tree of templates, for example to find out which other templates a
+// template depends on.
+package meta
+
+import (
+	"github.com/gojinja/gojinja/src/nodes"
+)
+
+// FindReferencedTemplates finds all the referenced templates from the AST.
+// Only names given as constant strings (or tuples of constant strings) are
+// returned, as dynamic inheritance or inclusion cannot be resolved without
+// rendering the template.
+func FindReferencedTemplates(ast *nodes.Template) []string {
+	var found []string
+	addConst := func(expr nodes.Expr) {
+		switch v := expr.(type) {
+		case *nodes.Const:
+			if s, ok := v.Value.(string); ok {
+				found = append(found, s)
+			}
+		case *nodes.Tuple:
+			for _, item := range v.Items {
+				if c, ok := item.(*nodes.Const); ok {
+					if s, ok := c.Value.(string); ok {
+						found = append(found, s)
+					}
+				}
+			}
+		}
+	}
+
+	nodes.Walk(ast, func(node nodes.Node) bool {
+		switch n := node.(type) {
+		case *nodes.Extends:
+			addConst(n.Template)
+		case *nodes.Include:
+			addConst(n.Template)
+		case *nodes.Import:
+			addConst(n.Template)
+		}
+		return true
+	})
+	return found
+}
@@ -183,6 +183,15 @@ func (t *Tuple) SetCtx(ctx string) {
 	t.Ctx = ctx
 }
 
+func (t *Tuple) CanAssign() bool {
+	for _, item := range t.Items {
+		if !item.CanAssign() {
+			return false
+		}
+	}
+	return true
+}
+
 type Const struct {
 	Value any
 	LiteralCommon
@@ -208,6 +217,36 @@ func (n Name) GetName() string {
 	return n.Name
 }
 
+// InternalName is an internal name in the template. It's created by calling
+// `FreeIdentifier` on the parser and can't be referenced by template code.
+type InternalName struct {
+	Name string
+	ExprCommon
+}
+
+func (n *InternalName) SetCtx(string) {}
+
+func (n *InternalName) GetName() string {
+	return n.Name
+}
+
+// ContextReference returns the current template context. It can be used
+// like a `Name` node to pass the context to a function.
+type ContextReference struct {
+	ExprCommon
+}
+
+func (*ContextReference) SetCtx(string) {}
+
+// DerivedContextReference returns the current template context including
+// the local variables. Unlike `ContextReference` it also sees the variables
+// defined in the current scope, e.g. the target of a for loop.
+type DerivedContextReference struct {
+	ExprCommon
+}
+
+func (*DerivedContextReference) SetCtx(string) {}
+
 type NSRef struct {
 	Name string
 	Attr string
@@ -477,6 +516,64 @@ func (f *Filter) SetCtx(ctx string) {
 	}
 }
 
+type Test struct {
+	FilterTestCommon
+}
+
+func (t *Test) SetCtx(ctx string) {
+	if t.Node != nil {
+		(*t.Node).SetCtx(ctx)
+	}
+	for _, n := range t.Args {
+		n.SetCtx(ctx)
+	}
+	for _, n := range t.Kwargs {
+		n.SetCtx(ctx)
+	}
+	if t.DynArgs != nil {
+		(*t.DynArgs).SetCtx(ctx)
+	}
+	if t.DynKwargs != nil {
+		(*t.DynKwargs).SetCtx(ctx)
+	}
+}
+
+// List is any list literal such as `[1, 2, 3]`.
+type List struct {
+	Items []Expr
+	LiteralCommon
+}
+
+func (l *List) SetCtx(ctx string) {
+	for _, n := range l.Items {
+		n.SetCtx(ctx)
+	}
+}
+
+// Pair is a key, value pair for dicts.
+type Pair struct {
+	Key   Expr
+	Value Expr
+	HelperCommon
+}
+
+func (p *Pair) SetCtx(ctx string) {
+	p.Key.SetCtx(ctx)
+	p.Value.SetCtx(ctx)
+}
+
+// Dict is any dict literal such as `{1: 2, 3: 4}`.
+type Dict struct {
+	Items []Pair
+	LiteralCommon
+}
+
+func (d *Dict) SetCtx(ctx string) {
+	for _, n := range d.Items {
+		n.SetCtx(ctx)
+	}
+}
+
 type Keyword struct {
 	Key   string
 	Value Expr
@@ -528,6 +625,21 @@ func (c *CallBlock) SetCtx(ctx string) {
 	}
 }
 
+// Block is a node that represents a block.
+type Block struct {
+	Name     string
+	Body     []Node
+	Scoped   bool
+	Required bool
+	StmtCommon
+}
+
+func (b *Block) SetCtx(ctx string) {
+	for _, n := range b.Body {
+		n.SetCtx(ctx)
+	}
+}
+
 type For struct {
 	Target    Node
 	Iter      Node
@@ -552,10 +664,37 @@ func (f *For) SetCtx(ctx string) {
 	}
 }
 
+// Break breaks out of the innermost loop.
+type Break struct {
+	StmtCommon
+}
+
+func (*Break) SetCtx(string) {}
+
+// Continue continues with the next iteration of the innermost loop.
+type Continue struct {
+	StmtCommon
+}
+
+func (*Continue) SetCtx(string) {}
+
+// ExprStmt evaluates an expression and discards the result.
+type ExprStmt struct {
+	Node Expr
+	StmtCommon
+}
+
+func (e *ExprStmt) SetCtx(ctx string) {
+	e.Node.SetCtx(ctx)
+}
+
 // Assert all types of nodes implement Node interface.
 var _ Node = &Template{}
 
 var _ Stmt = &Extends{}
+var _ Stmt = &Break{}
+var _ Stmt = &Continue{}
+var _ Stmt = &ExprStmt{}
 var _ Stmt = &Macro{}
 var _ Stmt = &Scope{}
 var _ Stmt = &FilterBlock{}
@@ -571,6 +710,7 @@ var _ Stmt = &Assign{}
 var _ Stmt = &AssignBlock{}
 var _ Stmt = &With{}
 var _ Stmt = &For{}
+var _ Stmt = &Block{}
 
 var _ SetWithContexter = &Include{}
 var _ SetWithContexter = &Import{}
@@ -582,18 +722,25 @@ var _ Expr = &Compare{}
 var _ Expr = &Concat{}
 var _ Expr = &Call{}
 var _ Expr = &Filter{}
+var _ Expr = &Test{}
 var _ Expr = &Name{}
 var _ Expr = &NSRef{}
+var _ Expr = &ContextReference{}
+var _ Expr = &DerivedContextReference{}
 var _ Expr = &Getattr{}
 var _ Expr = &Getitem{}
 var _ Expr = &Slice{}
 
 var _ ExprWithName = &Name{}
 var _ ExprWithName = &NSRef{}
+var _ ExprWithName = &InternalName{}
 
 var _ Literal = &Const{}
 var _ Literal = &Tuple{}
 var _ Literal = &TemplateData{}
+var _ Literal = &List{}
+var _ Literal = &Dict{}
 
 var _ Helper = &Keyword{}
 var _ Helper = &Operand{}
+var _ Helper = &Pair{}
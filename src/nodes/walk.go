@@ -0,0 +1,161 @@
+package nodes
+
+// IterChildNodes returns all direct child nodes of the node, in the order
+// they appear in the template.
+func IterChildNodes(node Node) []Node {
+	var children []Node
+	addExprs := func(exprs ...Expr) {
+		for _, e := range exprs {
+			if e != nil {
+				children = append(children, e)
+			}
+		}
+	}
+	addOptional := func(exprs ...*Expr) {
+		for _, e := range exprs {
+			if e != nil {
+				children = append(children, *e)
+			}
+		}
+	}
+	addKeywords := func(kws []Keyword) {
+		for i := range kws {
+			children = append(children, &kws[i])
+		}
+	}
+	addNames := func(names []Name) {
+		for i := range names {
+			children = append(children, &names[i])
+		}
+	}
+
+	switch n := node.(type) {
+	case *Template:
+		children = append(children, n.Body...)
+	case *Output:
+		addExprs(n.Nodes...)
+	case *Extends:
+		addExprs(n.Template)
+	case *ExprStmt:
+		addExprs(n.Node)
+	case *Macro:
+		addNames(n.Args)
+		addExprs(n.Defaults...)
+		children = append(children, n.Body...)
+	case *ScopedEvalContextModifier:
+		addKeywords(n.Options)
+		children = append(children, n.Body...)
+	case *EvalContextModifier:
+		addKeywords(n.Options)
+	case *Scope:
+		children = append(children, n.Body...)
+	case *FilterBlock:
+		children = append(children, n.Body...)
+		if n.Filter != nil {
+			children = append(children, n.Filter)
+		}
+	case *Tuple:
+		addExprs(n.Items...)
+	case *CondExpr:
+		addExprs(n.Test, n.Expr1)
+		addOptional(n.Expr2)
+	case *Operand:
+		if n.Expr != nil {
+			children = append(children, n.Expr)
+		}
+	case *Compare:
+		addExprs(n.Expr)
+		for i := range n.Ops {
+			children = append(children, &n.Ops[i])
+		}
+	case *BinExpr:
+		addExprs(n.Left, n.Right)
+	case *Concat:
+		addExprs(n.Nodes...)
+	case *UnaryExpr:
+		addExprs(n.Node)
+	case *Getattr:
+		addExprs(n.Node)
+	case *Getitem:
+		addExprs(n.Node, n.Arg)
+	case *Slice:
+		addOptional(n.Start, n.Stop, n.Step)
+	case *Call:
+		addExprs(n.Node)
+		addExprs(n.Args...)
+		addKeywords(n.Kwargs)
+		addOptional(n.DynArgs, n.DynKwargs)
+	case *Include:
+		addExprs(n.Template)
+	case *Assign:
+		addExprs(n.Target)
+		if n.Node != nil {
+			children = append(children, n.Node)
+		}
+	case *AssignBlock:
+		addExprs(n.Target)
+		children = append(children, n.Body...)
+		if n.Filter != nil {
+			children = append(children, n.Filter)
+		}
+	case *With:
+		addExprs(n.Targets...)
+		addExprs(n.Values...)
+		children = append(children, n.Body...)
+	case *Import:
+		addExprs(n.Template)
+	case *Filter:
+		addOptional(n.Node)
+		addExprs(n.Args...)
+		addKeywords(n.Kwargs)
+		addOptional(n.DynArgs, n.DynKwargs)
+	case *Test:
+		addOptional(n.Node)
+		addExprs(n.Args...)
+		addKeywords(n.Kwargs)
+		addOptional(n.DynArgs, n.DynKwargs)
+	case *List:
+		addExprs(n.Items...)
+	case *Pair:
+		addExprs(n.Key, n.Value)
+	case *Dict:
+		for i := range n.Items {
+			children = append(children, &n.Items[i])
+		}
+	case *Block:
+		children = append(children, n.Body...)
+	case *Keyword:
+		addExprs(n.Value)
+	case *If:
+		children = append(children, n.Test)
+		children = append(children, n.Body...)
+		for i := range n.Elif {
+			children = append(children, &n.Elif[i])
+		}
+		children = append(children, n.Else...)
+	case *CallBlock:
+		children = append(children, &n.Call)
+		addNames(n.Args)
+		addExprs(n.Defaults...)
+		children = append(children, n.Body...)
+	case *For:
+		children = append(children, n.Target, n.Iter)
+		if n.Test != nil {
+			children = append(children, *n.Test)
+		}
+		children = append(children, n.Body...)
+		children = append(children, n.Else...)
+	}
+	return children
+}
+
+// Walk traverses the tree rooted at node in depth-first order. If f returns
+// false for a node its children are skipped.
+func Walk(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	for _, child := range IterChildNodes(node) {
+		Walk(child, f)
+	}
+}
@@ -43,7 +43,9 @@ func makeBinaryOpExpr(left, right nodes.Expr, op string, lineno int) nodes.Expr
 
 type extensionParser = func(p extensions.IParser) ([]nodes.Node, error)
 
-type parser struct {
+// Parser is the central parsing class Jinja uses. It's passed to
+// extensions and can be used to parse expressions or statements.
+type Parser struct {
 	stream                *lexer.TokenStream
 	name, filename, state *string
 	closed                bool
@@ -53,9 +55,9 @@ type parser struct {
 	endTokenStack         *stack.Stack[[]string]
 }
 
-var _ extensions.IParser = &parser{}
+var _ extensions.IParser = &Parser{}
 
-func NewParser(stream *lexer.TokenStream, extensions []extensions.IExtension, name, filename, state *string) *parser {
+func NewParser(stream *lexer.TokenStream, extensions []extensions.IExtension, name, filename, state *string) *Parser {
 	taggedExtensions := make(map[string]extensionParser, 0)
 	for _, extension := range extensions {
 		for _, tag := range extension.Tags() {
@@ -63,7 +65,7 @@ func NewParser(stream *lexer.TokenStream, extensions []extensions.IExtension, na
 		}
 	}
 
-	return &parser{
+	return &Parser{
 		stream:         stream,
 		name:           name,
 		filename:       filename,
@@ -77,7 +79,7 @@ func NewParser(stream *lexer.TokenStream, extensions []extensions.IExtension, na
 }
 
 // Parse parses the whole template into a `Template` node.
-func (p *parser) Parse() (*nodes.Template, error) {
+func (p *Parser) Parse() (*nodes.Template, error) {
 	body, err := p.subparse(nil)
 	if err != nil {
 		return nil, err
@@ -90,7 +92,7 @@ func (p *parser) Parse() (*nodes.Template, error) {
 	}, nil
 }
 
-func (p *parser) subparse(endTokens []string) ([]nodes.Node, error) {
+func (p *Parser) subparse(endTokens []string) ([]nodes.Node, error) {
 	body := make([]nodes.Node, 0)
 	dataBuffer := make([]nodes.Expr, 0)
 	addData := func(node nodes.Expr) {
@@ -127,7 +129,7 @@ func (p *parser) subparse(endTokens []string) ([]nodes.Node, error) {
 			p.stream.Next()
 		case lexer.TokenVariableBegin:
 			p.stream.Next()
-			tuple, err := p.parseTuple(false, true, nil, false)
+			tuple, err := p.ParseTuple(false, true, nil, false)
 			if err != nil {
 				return nil, err
 			}
@@ -150,7 +152,7 @@ func (p *parser) subparse(endTokens []string) ([]nodes.Node, error) {
 				return nil, err
 			}
 		default:
-			return nil, p.fail("internal parsing error", nil)
+			return nil, p.Fail("internal parsing error", nil)
 		}
 	}
 
@@ -158,14 +160,22 @@ func (p *parser) subparse(endTokens []string) ([]nodes.Node, error) {
 	return body, nil
 }
 
-func (p *parser) parseTuple(simplified bool, withCondexpr bool, extraEndRules []string, explicitParentheses bool) (nodes.Expr, error) {
+// ParseTuple works like `ParseExpression` but if multiple expressions are
+// delimited by a comma a `Tuple` node is created. This method could also
+// return a regular expression instead of a tuple if no commas were found.
+//
+// The default parsing mode is a full tuple. If `simplified` is true only
+// names and literals are parsed. `extraEndRules` lists tokens that end the
+// tuple in addition to the default ones, and `explicitParentheses` makes an
+// empty tuple without parentheses an error.
+func (p *Parser) ParseTuple(simplified bool, withCondexpr bool, extraEndRules []string, explicitParentheses bool) (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	var parse func() (nodes.Expr, error)
 	if simplified {
 		parse = p.parsePrimary
 	} else {
 		parse = func() (nodes.Expr, error) {
-			return p.parseExpression(withCondexpr)
+			return p.ParseExpression(withCondexpr)
 		}
 	}
 
@@ -204,7 +214,7 @@ func (p *parser) parseTuple(simplified bool, withCondexpr bool, extraEndRules []
 		// nothing) in the spot of an expression would be an empty
 		// tuple.
 		if !explicitParentheses {
-			return nil, p.fail(fmt.Sprintf("Expected an expression, got %s", p.stream.Current()), nil)
+			return nil, p.Fail(fmt.Sprintf("Expected an expression, got %s", p.stream.Current()), nil)
 		}
 	}
 
@@ -215,7 +225,7 @@ func (p *parser) parseTuple(simplified bool, withCondexpr bool, extraEndRules []
 	}, nil
 }
 
-func (p *parser) parsePrimary() (nodes.Expr, error) {
+func (p *Parser) parsePrimary() (nodes.Expr, error) {
 	token := p.stream.Current()
 	var node nodes.Expr
 
@@ -260,7 +270,7 @@ func (p *parser) parsePrimary() (nodes.Expr, error) {
 		}, nil
 	case lexer.TokenLParen:
 		p.stream.Next()
-		node, err := p.parseTuple(false, true, nil, true)
+		node, err := p.ParseTuple(false, true, nil, true)
 		if err != nil {
 			return nil, err
 		}
@@ -273,18 +283,39 @@ func (p *parser) parsePrimary() (nodes.Expr, error) {
 	case lexer.TokenLBrace:
 		return p.parseDict()
 	default:
-		return nil, p.fail(fmt.Sprintf("unexpected %q", lexer.DescribeToken(token)), &token.Lineno)
+		return nil, p.Fail(fmt.Sprintf("unexpected %q", lexer.DescribeToken(token)), &token.Lineno)
 	}
 }
 
-func (p *parser) parseExpression(withCondexpr bool) (nodes.Expr, error) {
+// FreeIdentifier returns a new free identifier as `InternalName`.
+func (p *Parser) FreeIdentifier(lineno *int) *nodes.InternalName {
+	p.lastIdentifier++
+	l := p.stream.Current().Lineno
+	if lineno != nil {
+		l = *lineno
+	}
+	return &nodes.InternalName{
+		Name:       fmt.Sprintf("fi%d", p.lastIdentifier),
+		ExprCommon: nodes.ExprCommon{Lineno: l},
+	}
+}
+
+// Stream returns the token stream the parser consumes.
+func (p *Parser) Stream() *lexer.TokenStream {
+	return p.stream
+}
+
+// ParseExpression parses an expression. Per default all expressions are
+// parsed, if the optional `withCondexpr` parameter is set to false conditional
+// expressions are not parsed.
+func (p *Parser) ParseExpression(withCondexpr bool) (nodes.Expr, error) {
 	if withCondexpr {
 		return p.parseCondexpr()
 	}
 	return p.parseOr()
 }
 
-func (p *parser) parseCondexpr() (nodes.Expr, error) {
+func (p *Parser) parseCondexpr() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	expr1, err := p.parseOr()
 	if err != nil {
@@ -318,7 +349,7 @@ func (p *parser) parseCondexpr() (nodes.Expr, error) {
 	return expr1, nil
 }
 
-func (p *parser) parseOr() (nodes.Expr, error) {
+func (p *Parser) parseOr() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	left, err := p.parseAnd()
 	if err != nil {
@@ -335,7 +366,7 @@ func (p *parser) parseOr() (nodes.Expr, error) {
 	return left, nil
 }
 
-func (p *parser) parseAnd() (nodes.Expr, error) {
+func (p *Parser) parseAnd() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	left, err := p.parseNot()
 	if err != nil {
@@ -352,7 +383,7 @@ func (p *parser) parseAnd() (nodes.Expr, error) {
 	return left, nil
 }
 
-func (p *parser) parseNot() (nodes.Expr, error) {
+func (p *Parser) parseNot() (nodes.Expr, error) {
 	if p.stream.Current().Test("name:not") {
 		lineno := p.stream.Next().Lineno
 		n, err := p.parseNot()
@@ -368,7 +399,7 @@ func (p *parser) parseNot() (nodes.Expr, error) {
 	return p.parseCompare()
 }
 
-func (p *parser) parseCompare() (nodes.Expr, error) {
+func (p *Parser) parseCompare() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	expr, err := p.parseMath1()
 	if err != nil {
@@ -421,7 +452,7 @@ func (p *parser) parseCompare() (nodes.Expr, error) {
 	}, nil
 }
 
-func (p *parser) parseMath1() (nodes.Expr, error) {
+func (p *Parser) parseMath1() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	left, err := p.parseConcat()
 	if err != nil {
@@ -441,7 +472,7 @@ func (p *parser) parseMath1() (nodes.Expr, error) {
 	return left, nil
 }
 
-func (p *parser) parseMath2() (nodes.Expr, error) {
+func (p *Parser) parseMath2() (nodes.Expr, error) {
 	// TODO it's almost identical as parseMath1
 	lineno := p.stream.Current().Lineno
 	left, err := p.parsePow()
@@ -463,7 +494,7 @@ func (p *parser) parseMath2() (nodes.Expr, error) {
 
 }
 
-func (p *parser) parseConcat() (nodes.Expr, error) {
+func (p *Parser) parseConcat() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	left, err := p.parseMath2()
 	if err != nil {
@@ -488,7 +519,7 @@ func (p *parser) parseConcat() (nodes.Expr, error) {
 	}, nil
 }
 
-func (p *parser) parsePow() (nodes.Expr, error) {
+func (p *Parser) parsePow() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	left, err := p.parseUnary(true)
 	if err != nil {
@@ -507,7 +538,7 @@ func (p *parser) parsePow() (nodes.Expr, error) {
 	return left, nil
 }
 
-func (p *parser) parseUnary(withFilter bool) (node nodes.Expr, err error) {
+func (p *Parser) parseUnary(withFilter bool) (node nodes.Expr, err error) {
 	lineno := p.stream.Current().Lineno
 	tokenType := p.stream.Current().Type
 
@@ -541,7 +572,7 @@ func (p *parser) parseUnary(withFilter bool) (node nodes.Expr, err error) {
 	return
 }
 
-func (p *parser) parsePostfix(node nodes.Expr) (nodes.Expr, error) {
+func (p *Parser) parsePostfix(node nodes.Expr) (nodes.Expr, error) {
 	var err error
 
 	for {
@@ -564,13 +595,14 @@ func (p *parser) parsePostfix(node nodes.Expr) (nodes.Expr, error) {
 	return node, nil
 }
 
-func (p *parser) parseFilterExpr(node nodes.Expr) (nodes.Expr, error) {
+func (p *Parser) parseFilterExpr(node nodes.Expr) (nodes.Expr, error) {
 	var err error
 
 	for {
 		tokenType := p.stream.Current().Type
 		if tokenType == lexer.TokenPipe {
-			nP, err := p.parseFilter(&node, false)
+			inner := node
+			nP, err := p.parseFilter(&inner, false)
 			if err != nil {
 				return nil, err
 			}
@@ -593,7 +625,7 @@ func (p *parser) parseFilterExpr(node nodes.Expr) (nodes.Expr, error) {
 	return node, nil
 }
 
-func (p *parser) parseSubscript(node nodes.Expr) (nodes.Expr, error) {
+func (p *Parser) parseSubscript(node nodes.Expr) (nodes.Expr, error) {
 	token := p.stream.Next()
 	var arg nodes.Expr
 
@@ -608,7 +640,7 @@ func (p *parser) parseSubscript(node nodes.Expr) (nodes.Expr, error) {
 				ExprCommon: nodes.ExprCommon{Lineno: attrToken.Lineno},
 			}, nil
 		} else if attrToken.Type != lexer.TokenInteger {
-			return nil, p.fail(fmt.Sprintf("expected name or number, got %s", attrToken.Type), &attrToken.Lineno)
+			return nil, p.Fail(fmt.Sprintf("expected name or number, got %s", attrToken.Type), &attrToken.Lineno)
 		}
 		arg = &nodes.Const{
 			Value:         attrToken.Value,
@@ -656,10 +688,10 @@ func (p *parser) parseSubscript(node nodes.Expr) (nodes.Expr, error) {
 		}, nil
 	}
 
-	return nil, p.fail("expected subscript expression", &token.Lineno)
+	return nil, p.Fail("expected subscript expression", &token.Lineno)
 }
 
-func (p *parser) parseSubscribed() (nodes.Expr, error) {
+func (p *Parser) parseSubscribed() (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	var args []*nodes.Expr
 
@@ -667,7 +699,7 @@ func (p *parser) parseSubscribed() (nodes.Expr, error) {
 		p.stream.Next()
 		args = []*nodes.Expr{nil}
 	} else {
-		node, err := p.parseExpression(true)
+		node, err := p.ParseExpression(true)
 		if err != nil {
 			return nil, err
 		}
@@ -681,7 +713,7 @@ func (p *parser) parseSubscribed() (nodes.Expr, error) {
 	if p.stream.Current().Type == lexer.TokenColon {
 		args = append(args, nil)
 	} else if p.stream.Current().Type != lexer.TokenRBracket && p.stream.Current().Type != lexer.TokenComma {
-		arg, err := p.parseExpression(true)
+		arg, err := p.ParseExpression(true)
 		if err != nil {
 			return nil, err
 		}
@@ -693,7 +725,7 @@ func (p *parser) parseSubscribed() (nodes.Expr, error) {
 	if p.stream.Current().Type == lexer.TokenColon {
 		p.stream.Next()
 		if p.stream.Current().Type != lexer.TokenRBracket && p.stream.Current().Type != lexer.TokenComma {
-			arg, err := p.parseExpression(true)
+			arg, err := p.ParseExpression(true)
 			if err != nil {
 				return nil, err
 			}
@@ -723,7 +755,7 @@ func (p *parser) parseSubscribed() (nodes.Expr, error) {
 	}, nil
 }
 
-func (p *parser) parseCall(node nodes.Expr) (nodes.Expr, error) {
+func (p *Parser) parseCall(node nodes.Expr) (nodes.Expr, error) {
 	lineno := p.stream.Current().Lineno
 	args, kwargs, dynArgs, dynKwargs, err := p.parseCallArgs()
 	if err != nil {
@@ -739,7 +771,7 @@ func (p *parser) parseCall(node nodes.Expr) (nodes.Expr, error) {
 	}, nil
 }
 
-func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dynArgs *nodes.Expr, dynKwargs *nodes.Expr, err error) {
+func (p *Parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dynArgs *nodes.Expr, dynKwargs *nodes.Expr, err error) {
 	var token *lexer.Token
 	token, err = p.stream.Expect(lexer.TokenLParen)
 	if err != nil {
@@ -750,7 +782,7 @@ func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dyn
 
 	ensure := func(expr bool) error {
 		if !expr {
-			return p.fail("invalid syntax for function call expression", &token.Lineno)
+			return p.Fail("invalid syntax for function call expression", &token.Lineno)
 		}
 		return nil
 	}
@@ -772,7 +804,7 @@ func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dyn
 				return
 			}
 			p.stream.Next()
-			expr, err = p.parseExpression(true)
+			expr, err = p.ParseExpression(true)
 			if err != nil {
 				return
 			}
@@ -782,7 +814,7 @@ func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dyn
 				return
 			}
 			p.stream.Next()
-			expr, err = p.parseExpression(true)
+			expr, err = p.ParseExpression(true)
 			if err != nil {
 				return
 			}
@@ -795,7 +827,7 @@ func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dyn
 				}
 				key := p.stream.Current().Value
 				p.stream.Skip(2)
-				expr, err = p.parseExpression(true)
+				expr, err = p.ParseExpression(true)
 				if err != nil {
 					return
 				}
@@ -809,7 +841,7 @@ func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dyn
 				if err = ensure(dynArgs == nil && dynKwargs == nil && len(kwargs) == 0); err != nil {
 					return
 				}
-				expr, err = p.parseExpression(true)
+				expr, err = p.ParseExpression(true)
 				if err != nil {
 					return
 				}
@@ -824,7 +856,7 @@ func (p *parser) parseCallArgs() (args []nodes.Expr, kwargs []nodes.Keyword, dyn
 	return
 }
 
-func (p *parser) parseFilter(node *nodes.Expr, startInline bool) (*nodes.Expr, error) {
+func (p *Parser) parseFilter(node *nodes.Expr, startInline bool) (*nodes.Expr, error) {
 	for p.stream.Current().Type == lexer.TokenPipe || startInline {
 		if !startInline {
 			p.stream.Next()
@@ -873,22 +905,142 @@ func (p *parser) parseFilter(node *nodes.Expr, startInline bool) (*nodes.Expr, e
 	return node, nil
 }
 
-func (p *parser) parseTest(node nodes.Node) (nodes.Expr, error) {
-	// TODO
-	panic("not implemented")
+func (p *Parser) parseTest(node nodes.Expr) (nodes.Expr, error) {
+	token := p.stream.Next()
+	negated := p.stream.SkipIf("name:not")
+
+	nameToken, err := p.stream.Expect(lexer.TokenName)
+	if err != nil {
+		return nil, err
+	}
+	name := nameToken.Value.(string)
+	for p.stream.Current().Type == lexer.TokenDot {
+		p.stream.Next()
+		nameToken, err = p.stream.Expect(lexer.TokenName)
+		if err != nil {
+			return nil, err
+		}
+		name += "." + nameToken.Value.(string)
+	}
+
+	var args []nodes.Expr
+	var kwargs []nodes.Keyword
+	var dynArgs, dynKwargs *nodes.Expr
+	current := p.stream.Current()
+	if current.Type == lexer.TokenLParen {
+		args, kwargs, dynArgs, dynKwargs, err = p.parseCallArgs()
+		if err != nil {
+			return nil, err
+		}
+	} else if slices.Contains([]string{lexer.TokenName, lexer.TokenString, lexer.TokenInteger, lexer.TokenFloat, lexer.TokenLParen, lexer.TokenLBracket, lexer.TokenLBrace}, current.Type) &&
+		!current.TestAny("name:else", "name:or", "name:and") {
+		if current.Test("name:is") {
+			return nil, p.Fail("You cannot chain multiple tests with is", nil)
+		}
+		argNode, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		argNode, err = p.parsePostfix(argNode)
+		if err != nil {
+			return nil, err
+		}
+		args = []nodes.Expr{argNode}
+	}
+
+	var result nodes.Expr = &nodes.Test{
+		FilterTestCommon: nodes.FilterTestCommon{
+			Node:       &node,
+			Name:       name,
+			Args:       args,
+			Kwargs:     kwargs,
+			DynArgs:    dynArgs,
+			DynKwargs:  dynKwargs,
+			ExprCommon: nodes.ExprCommon{Lineno: token.Lineno},
+		},
+	}
+	if negated {
+		result = &nodes.UnaryExpr{
+			Node:       result,
+			Op:         "not",
+			ExprCommon: nodes.ExprCommon{Lineno: token.Lineno},
+		}
+	}
+	return result, nil
 }
 
-func (p *parser) parseList() (nodes.Expr, error) {
-	// TODO
-	panic("not implemented")
+func (p *Parser) parseList() (nodes.Expr, error) {
+	token, err := p.stream.Expect(lexer.TokenLBracket)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]nodes.Expr, 0)
+	for p.stream.Current().Type != lexer.TokenRBracket {
+		if len(items) > 0 {
+			if _, err := p.stream.Expect(lexer.TokenComma); err != nil {
+				return nil, err
+			}
+		}
+		if p.stream.Current().Type == lexer.TokenRBracket {
+			break
+		}
+		item, err := p.ParseExpression(true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if _, err := p.stream.Expect(lexer.TokenRBracket); err != nil {
+		return nil, err
+	}
+	return &nodes.List{
+		Items:         items,
+		LiteralCommon: nodes.LiteralCommon{Lineno: token.Lineno},
+	}, nil
 }
 
-func (p *parser) parseDict() (nodes.Expr, error) {
-	// TODO
-	panic("not implemented")
+func (p *Parser) parseDict() (nodes.Expr, error) {
+	token, err := p.stream.Expect(lexer.TokenLBrace)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]nodes.Pair, 0)
+	for p.stream.Current().Type != lexer.TokenRBrace {
+		if len(items) > 0 {
+			if _, err := p.stream.Expect(lexer.TokenComma); err != nil {
+				return nil, err
+			}
+		}
+		if p.stream.Current().Type == lexer.TokenRBrace {
+			break
+		}
+		key, err := p.ParseExpression(true)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.stream.Expect(lexer.TokenColon); err != nil {
+			return nil, err
+		}
+		value, err := p.ParseExpression(true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, nodes.Pair{
+			Key:          key,
+			Value:        value,
+			HelperCommon: nodes.HelperCommon{Lineno: key.GetLineno()},
+		})
+	}
+	if _, err := p.stream.Expect(lexer.TokenRBrace); err != nil {
+		return nil, err
+	}
+	return &nodes.Dict{
+		Items:         items,
+		LiteralCommon: nodes.LiteralCommon{Lineno: token.Lineno},
+	}, nil
 }
 
-func (p *parser) isTupleEnd(extraEndRules []string) bool {
+func (p *Parser) isTupleEnd(extraEndRules []string) bool {
 	current := p.stream.Current()
 	if slices.Contains([]string{lexer.TokenVariableEnd, lexer.TokenBlockEnd, lexer.TokenRParen}, current.Type) {
 		return true
@@ -898,10 +1050,10 @@ func (p *parser) isTupleEnd(extraEndRules []string) bool {
 	return false
 }
 
-func (p *parser) parseStatement() ([]nodes.Node, error) {
+func (p *Parser) parseStatement() ([]nodes.Node, error) {
 	token := p.stream.Current()
 	if token.Type != lexer.TokenName {
-		return nil, p.fail("tag name expected", &token.Lineno)
+		return nil, p.Fail("tag name expected", &token.Lineno)
 	}
 	p.tagStack.Push(token.Value.(string))
 	popTag := true
@@ -970,7 +1122,7 @@ func (p *parser) parseStatement() ([]nodes.Node, error) {
 	return nil, p.failUnknownTag(token.Value.(string), &token.Lineno)
 }
 
-func (p *parser) parseFor() (nodes.Node, error) {
+func (p *Parser) parseFor() (nodes.Node, error) {
 	forToken, err := p.stream.Expect("name:for")
 	if err != nil {
 		return nil, err
@@ -983,25 +1135,25 @@ func (p *parser) parseFor() (nodes.Node, error) {
 	if _, err = p.stream.Expect("name:in"); err != nil {
 		return nil, err
 	}
-	node.Iter, err = p.parseTuple(false, false, []string{"name:recursive"}, false)
+	node.Iter, err = p.ParseTuple(false, false, []string{"name:recursive"}, false)
 	if err != nil {
 		return nil, err
 	}
 	if p.stream.SkipIf("name:if") {
 		var test nodes.Node
-		test, err = p.parseExpression(true)
+		test, err = p.ParseExpression(true)
 		if err != nil {
 			return nil, err
 		}
 		node.Test = &test
 	}
 	node.Recursive = p.stream.SkipIf("name:recursive")
-	node.Body, err = p.parseStatements([]string{"name:endfor", "name:else"}, false)
+	node.Body, err = p.ParseStatements([]string{"name:endfor", "name:else"}, false)
 	if err != nil {
 		return nil, err
 	}
 	if p.stream.Next().Value != "endfor" {
-		node.Else, err = p.parseStatements([]string{"name:endfor"}, true)
+		node.Else, err = p.ParseStatements([]string{"name:endfor"}, true)
 		if err != nil {
 			return nil, err
 		}
@@ -1009,7 +1161,7 @@ func (p *parser) parseFor() (nodes.Node, error) {
 	return node, nil
 }
 
-func (p *parser) parseIf() (nodes.Node, error) {
+func (p *Parser) parseIf() (nodes.Node, error) {
 	tok, err := p.stream.Expect("name:if")
 	if err != nil {
 		return nil, err
@@ -1020,25 +1172,27 @@ func (p *parser) parseIf() (nodes.Node, error) {
 	result := node
 
 	for {
-		node.Test, err = p.parseTuple(false, false, nil, false)
+		node.Test, err = p.ParseTuple(false, false, nil, false)
 		if err != nil {
 			return nil, err
 		}
-		node.Body, err = p.parseStatements([]string{"name:elif", "name:else", "name:endif"}, false)
+		node.Body, err = p.ParseStatements([]string{"name:elif", "name:else", "name:endif"}, false)
 		if err != nil {
 			return nil, err
 		}
 		node.Elif = []nodes.If{}
 		node.Else = []nodes.Node{}
+		if node != result {
+			result.Elif = append(result.Elif, *node)
+		}
 		token := p.stream.Next()
 		if token.Test("name:elif") {
 			node = &nodes.If{
 				StmtCommon: nodes.StmtCommon{Lineno: token.Lineno},
 			}
-			result.Elif = append(result.Elif, *node)
 			continue
 		} else if token.Test("name:else") {
-			result.Else, err = p.parseStatements([]string{"name:endif"}, true)
+			result.Else, err = p.ParseStatements([]string{"name:endif"}, true)
 			if err != nil {
 				return nil, err
 			}
@@ -1049,7 +1203,15 @@ func (p *parser) parseIf() (nodes.Node, error) {
 	return result, nil
 }
 
-func (p *parser) parseStatements(endTokens []string, dropNeedle bool) ([]nodes.Node, error) {
+// ParseStatements parses multiple statements into a list until one of the end
+// tokens is reached. This is used to parse the body of statements as it also
+// parses template data if appropriate. The parser checks first if the current
+// token is a colon and skips it if there is one. Then it checks for the block
+// end and parses until one of the end tokens is reached. Per default the
+// active token in the stream at the end of the call is the matched end token.
+// If this is not wanted `dropNeedle` can be set to true and the end token is
+// removed.
+func (p *Parser) ParseStatements(endTokens []string, dropNeedle bool) ([]nodes.Node, error) {
 	p.stream.SkipIf(lexer.TokenColon)
 	if _, err := p.stream.Expect(lexer.TokenBlockEnd); err != nil {
 		return nil, err
@@ -1072,24 +1234,63 @@ func (p *parser) parseStatements(endTokens []string, dropNeedle bool) ([]nodes.N
 	return result, nil
 }
 
-func (p *parser) parseBlock() (nodes.Node, error) {
-	// TODO
-	panic("not implemented")
+func (p *Parser) parseBlock() (nodes.Node, error) {
+	node := &nodes.Block{StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno}}
+	nameToken, err := p.stream.Expect(lexer.TokenName)
+	if err != nil {
+		return nil, err
+	}
+	node.Name = nameToken.Value.(string)
+	node.Scoped = p.stream.SkipIf("name:scoped")
+	node.Required = p.stream.SkipIf("name:required")
+
+	// common problem people encounter when switching from django
+	// to jinja.  we do not support hyphens in block names, so let's
+	// raise a nicer error message in that case.
+	if p.stream.Current().Type == lexer.TokenSub {
+		return nil, p.Fail("Block names in Jinja have to be valid Python identifiers and may not contain hyphens, use an underscore instead.", nil)
+	}
+
+	node.Body, err = p.ParseStatements([]string{"name:endblock"}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// enforce that required blocks only contain whitespace or comments
+	// by asserting that the body, if not empty, is just TemplateData nodes
+	// with whitespace data
+	if node.Required {
+		for _, body := range node.Body {
+			output, ok := body.(*nodes.Output)
+			if !ok {
+				return nil, p.Fail("Required blocks can only contain comments or whitespace", nil)
+			}
+			for _, child := range output.Nodes {
+				data, ok := child.(*nodes.TemplateData)
+				if !ok || strings.TrimSpace(data.Data) != "" {
+					return nil, p.Fail("Required blocks can only contain comments or whitespace", nil)
+				}
+			}
+		}
+	}
+
+	p.stream.SkipIf("name:" + node.Name)
+	return node, nil
 }
 
-func (p *parser) parseExtends() (nodes.Node, error) {
+func (p *Parser) parseExtends() (nodes.Node, error) {
 	node := &nodes.Extends{
 		StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno},
 	}
 	var err error
-	node.Template, err = p.parseExpression(true)
+	node.Template, err = p.ParseExpression(true)
 	if err != nil {
 		return nil, err
 	}
 	return node, nil
 }
 
-func (p *parser) parsePrint() (nodes.Node, error) {
+func (p *Parser) parsePrint() (nodes.Node, error) {
 	node := &nodes.Output{
 		StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno},
 		Nodes:      make([]nodes.Expr, 0),
@@ -1101,7 +1302,7 @@ func (p *parser) parsePrint() (nodes.Node, error) {
 				return nil, err
 			}
 		}
-		n, err := p.parseExpression(true)
+		n, err := p.ParseExpression(true)
 		if err != nil {
 			return nil, err
 		}
@@ -1110,7 +1311,7 @@ func (p *parser) parsePrint() (nodes.Node, error) {
 	return node, nil
 }
 
-func (p *parser) parseMacro() (nodes.Node, error) {
+func (p *Parser) parseMacro() (nodes.Node, error) {
 	n := &nodes.Macro{StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno}}
 
 	name, err := p.parseAssignTargetName()
@@ -1121,7 +1322,7 @@ func (p *parser) parseMacro() (nodes.Node, error) {
 	if err = p.parseSignature(&n.MacroCall); err != nil {
 		return nil, err
 	}
-	n.Body, err = p.parseStatements([]string{"name:endmacro"}, true)
+	n.Body, err = p.ParseStatements([]string{"name:endmacro"}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -1129,7 +1330,7 @@ func (p *parser) parseMacro() (nodes.Node, error) {
 	return n, nil
 }
 
-func (p *parser) parseImportContext(node nodes.SetWithContexter, def bool) (nodes.Node, error) {
+func (p *Parser) parseImportContext(node nodes.SetWithContexter, def bool) (nodes.Node, error) {
 	if p.stream.Current().TestAny("name:with", "name:without") &&
 		p.stream.Look().Test("name:context") {
 		node.SetWithContext(p.stream.Next().Value == "with")
@@ -1140,10 +1341,10 @@ func (p *parser) parseImportContext(node nodes.SetWithContexter, def bool) (node
 	return node, nil
 }
 
-func (p *parser) parseInclude() (nodes.Node, error) {
+func (p *Parser) parseInclude() (nodes.Node, error) {
 	node := &nodes.Include{StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno}}
 	var err error
-	node.Template, err = p.parseExpression(true)
+	node.Template, err = p.ParseExpression(true)
 	if err != nil {
 		return nil, err
 	}
@@ -1154,15 +1355,15 @@ func (p *parser) parseInclude() (nodes.Node, error) {
 	return p.parseImportContext(node, true)
 }
 
-func (p *parser) parseFrom() (nodes.Node, error) {
+func (p *Parser) parseFrom() (nodes.Node, error) {
 	// TODO
 	panic("not implemented")
 }
 
-func (p *parser) parseImport() (nodes.Node, error) {
+func (p *Parser) parseImport() (nodes.Node, error) {
 	node := &nodes.Import{StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno}}
 	var err error
-	node.Template, err = p.parseExpression(true)
+	node.Template, err = p.ParseExpression(true)
 	if err != nil {
 		return nil, err
 	}
@@ -1178,14 +1379,14 @@ func (p *parser) parseImport() (nodes.Node, error) {
 	return p.parseImportContext(node, false)
 }
 
-func (p *parser) parseSet() (nodes.Node, error) {
+func (p *Parser) parseSet() (nodes.Node, error) {
 	lineno := p.stream.Next().Lineno
 	target, err := p.parseAssignTargetNameNamespace()
 	if err != nil {
 		return nil, err
 	}
 	if p.stream.SkipIf(lexer.TokenAssign) {
-		expr, err := p.parseTuple(false, true, nil, false)
+		expr, err := p.ParseTuple(false, true, nil, false)
 		if err != nil {
 			return nil, err
 		}
@@ -1199,24 +1400,28 @@ func (p *parser) parseSet() (nodes.Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	if f, ok := (*filter).(*nodes.Filter); ok {
-		body, err := p.parseStatements([]string{"name:endset"}, true)
-		if err != nil {
-			return nil, err
+	var f *nodes.Filter
+	if filter != nil {
+		var ok bool
+		if f, ok = (*filter).(*nodes.Filter); !ok {
+			return nil, fmt.Errorf("couldn't parse filter")
 		}
-		return &nodes.AssignBlock{
-			Target: target,
-			Body:   body,
-			Filter: f,
-			StmtCommon: nodes.StmtCommon{
-				Lineno: lineno,
-			},
-		}, nil
 	}
-	return nil, fmt.Errorf("couldn't parse filter")
+	body, err := p.ParseStatements([]string{"name:endset"}, true)
+	if err != nil {
+		return nil, err
+	}
+	return &nodes.AssignBlock{
+		Target: target,
+		Body:   body,
+		Filter: f,
+		StmtCommon: nodes.StmtCommon{
+			Lineno: lineno,
+		},
+	}, nil
 }
 
-func (p *parser) parseWith() (nodes.Node, error) {
+func (p *Parser) parseWith() (nodes.Node, error) {
 	node := &nodes.With{
 		Targets:    make([]nodes.Expr, 0),
 		Values:     make([]nodes.Expr, 0),
@@ -1238,7 +1443,7 @@ func (p *parser) parseWith() (nodes.Node, error) {
 		if _, err := p.stream.Expect(lexer.TokenAssign); err != nil {
 			return nil, err
 		}
-		expr, err := p.parseExpression(true)
+		expr, err := p.ParseExpression(true)
 		if err != nil {
 			return nil, err
 		}
@@ -1246,21 +1451,21 @@ func (p *parser) parseWith() (nodes.Node, error) {
 	}
 
 	var err error
-	node.Body, err = p.parseStatements([]string{"name:endwith"}, true)
+	node.Body, err = p.ParseStatements([]string{"name:endwith"}, true)
 	if err != nil {
 		return nil, err
 	}
 	return node, nil
 }
 
-func (p *parser) parseAutoescape() (nodes.Node, error) {
+func (p *Parser) parseAutoescape() (nodes.Node, error) {
 	node := &nodes.ScopedEvalContextModifier{
 		EvalContextModifier: nodes.EvalContextModifier{
 			Options:    make([]nodes.Keyword, 1),
 			StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno},
 		},
 	}
-	optsExpr, err := p.parseExpression(true)
+	optsExpr, err := p.ParseExpression(true)
 	if err != nil {
 		return nil, err
 	}
@@ -1269,7 +1474,7 @@ func (p *parser) parseAutoescape() (nodes.Node, error) {
 		Value:        optsExpr,
 		HelperCommon: nodes.HelperCommon{Lineno: optsExpr.GetLineno()},
 	}
-	node.Body, err = p.parseStatements([]string{"name:endautoescape"}, true)
+	node.Body, err = p.ParseStatements([]string{"name:endautoescape"}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -1278,7 +1483,7 @@ func (p *parser) parseAutoescape() (nodes.Node, error) {
 	}, nil
 }
 
-func (p *parser) parseCallBlock() (nodes.Node, error) {
+func (p *Parser) parseCallBlock() (nodes.Node, error) {
 	node := &nodes.CallBlock{StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno}}
 	if p.stream.Current().Type == lexer.TokenLParen {
 		if err := p.parseSignature(&node.MacroCall); err != nil {
@@ -1286,16 +1491,16 @@ func (p *parser) parseCallBlock() (nodes.Node, error) {
 		}
 	}
 
-	callNode, err := p.parseExpression(true)
+	callNode, err := p.ParseExpression(true)
 	if err != nil {
 		return nil, err
 	}
 	if call, ok := callNode.(*nodes.Call); ok {
 		node.Call = *call
 	} else {
-		return nil, p.fail("expected call", &node.Lineno)
+		return nil, p.Fail("expected call", &node.Lineno)
 	}
-	node.Body, err = p.parseStatements([]string{"name:endcall"}, true)
+	node.Body, err = p.ParseStatements([]string{"name:endcall"}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -1303,7 +1508,7 @@ func (p *parser) parseCallBlock() (nodes.Node, error) {
 	return node, nil
 }
 
-func (p *parser) parseFilterBlock() (nodes.Node, error) {
+func (p *Parser) parseFilterBlock() (nodes.Node, error) {
 	node := &nodes.FilterBlock{
 		StmtCommon: nodes.StmtCommon{Lineno: p.stream.Next().Lineno},
 	}
@@ -1322,7 +1527,7 @@ func (p *parser) parseFilterBlock() (nodes.Node, error) {
 		return nil, fmt.Errorf("couldn't parse filter")
 	}
 
-	node.Body, err = p.parseStatements([]string{"name:endfilter"}, true)
+	node.Body, err = p.ParseStatements([]string{"name:endfilter"}, true)
 	if err != nil {
 		return nil, err
 	}
@@ -1330,7 +1535,7 @@ func (p *parser) parseFilterBlock() (nodes.Node, error) {
 	return node, nil
 }
 
-func (p *parser) parseAssignTargetName() (target *nodes.Name, err error) {
+func (p *Parser) parseAssignTargetName() (target *nodes.Name, err error) {
 	token, err := p.stream.Expect(lexer.TokenName)
 	if err != nil {
 		return nil, err
@@ -1342,42 +1547,45 @@ func (p *parser) parseAssignTargetName() (target *nodes.Name, err error) {
 	}
 	if !target.CanAssign() {
 		lineno := target.GetLineno()
-		return nil, p.fail(fmt.Sprintf("can't assign to %s", reflect.TypeOf(target).Name()), &lineno)
+		return nil, p.Fail(fmt.Sprintf("can't assign to %s", reflect.TypeOf(target).Name()), &lineno)
 	}
 
 	return
 }
 
-func (p *parser) parseAssignTargetTuple(extraEndRules []string) (target nodes.Expr, err error) {
-	target, err = p.parseTuple(true, true, extraEndRules, false)
+func (p *Parser) parseAssignTargetTuple(extraEndRules []string) (target nodes.Expr, err error) {
+	target, err = p.ParseTuple(true, true, extraEndRules, false)
+	if err != nil {
+		return nil, err
+	}
 	target.SetCtx("store")
 
 	if !target.CanAssign() {
 		lineno := target.GetLineno()
-		return nil, p.fail(fmt.Sprintf("can't assign to %s", reflect.TypeOf(target).Name()), &lineno)
+		return nil, p.Fail(fmt.Sprintf("can't assign to %s", reflect.TypeOf(target).Name()), &lineno)
 	}
 
 	return
 }
 
-func (p *parser) parseAssignTargetNameNamespace() (target nodes.ExprWithName, err error) {
+func (p *Parser) parseAssignTargetNameNamespace() (target nodes.Expr, err error) {
 	if p.stream.Look().Type == lexer.TokenDot {
 		target, err = p.parseNSRef()
 	} else {
-		target, err = p.parseAssignTargetName()
+		target, err = p.parseAssignTargetTuple(nil)
 	}
 	if err != nil {
 		return nil, err
 	}
 	if !target.CanAssign() {
 		lineno := target.GetLineno()
-		return nil, p.fail(fmt.Sprintf("can't assign to %s", reflect.TypeOf(target).Name()), &lineno)
+		return nil, p.Fail(fmt.Sprintf("can't assign to %s", reflect.TypeOf(target).Name()), &lineno)
 	}
 
 	return
 }
 
-func (p *parser) parseNSRef() (*nodes.NSRef, error) {
+func (p *Parser) parseNSRef() (*nodes.NSRef, error) {
 	token, err := p.stream.Expect(lexer.TokenName)
 	if err != nil {
 		return nil, err
@@ -1397,7 +1605,7 @@ func (p *parser) parseNSRef() (*nodes.NSRef, error) {
 	}, nil
 }
 
-func (p *parser) parseSignature(n *nodes.MacroCall) error {
+func (p *Parser) parseSignature(n *nodes.MacroCall) error {
 	n.Args = make([]nodes.Name, 0)
 	n.Defaults = make([]nodes.Expr, 0)
 	if _, err := p.stream.Expect(lexer.TokenLParen); err != nil {
@@ -1415,13 +1623,13 @@ func (p *parser) parseSignature(n *nodes.MacroCall) error {
 		}
 		arg.SetCtx("param")
 		if p.stream.SkipIf(lexer.TokenAssign) {
-			expr, err := p.parseExpression(true)
+			expr, err := p.ParseExpression(true)
 			if err != nil {
 				return err
 			}
 			n.Defaults = append(n.Defaults, expr)
 		} else if len(n.Defaults) != 0 {
-			return p.fail("non-default argument follows default argument", nil)
+			return p.Fail("non-default argument follows default argument", nil)
 		}
 		n.Args = append(n.Args, *arg)
 	}
@@ -1429,28 +1637,30 @@ func (p *parser) parseSignature(n *nodes.MacroCall) error {
 	return err
 }
 
-func (p *parser) fail(msg string, lineno *int) error {
+// Fail returns a `TemplateSyntaxError` with the message. If no line number
+// is given, the line number of the current token is used.
+func (p *Parser) Fail(msg string, lineno *int) error {
 	var lineNumber int
 	if lineno == nil {
 		lineNumber = p.stream.Current().Lineno
 	} else {
 		lineNumber = *lineno
 	}
-	return errors.TemplateSyntaxError(msg, lineNumber, p.name, p.filename)
+	return errors.NewTemplateSyntaxError(msg, lineNumber, p.name, p.filename)
 }
 
-func (p *parser) failUnknownTag(name string, lineno *int) error {
+func (p *Parser) failUnknownTag(name string, lineno *int) error {
 	return p.failUtEof(&name, p.endTokenStack, lineno)
 }
 
-func (p *parser) failEOF(endTokens []string, lineno *int) error {
+func (p *Parser) failEOF(endTokens []string, lineno *int) error {
 	if endTokens != nil {
 		p.endTokenStack.Push(endTokens)
 	}
 	return p.failUtEof(nil, p.endTokenStack, lineno)
 }
 
-func (p *parser) failUtEof(name *string, endTokenStack *stack.Stack[[]string], lineno *int) error {
+func (p *Parser) failUtEof(name *string, endTokenStack *stack.Stack[[]string], lineno *int) error {
 	endTokenStackSlice := endTokenStack.Iter()
 	expected := set.New[string]()
 
@@ -1490,5 +1700,5 @@ func (p *parser) failUtEof(name *string, endTokenStack *stack.Stack[[]string], l
 		messages = append(messages, fmt.Sprintf("The innermost block that needs to be closed is %q.", *lastTag))
 	}
 
-	return p.fail(strings.Join(messages, " "), lineno)
+	return p.Fail(strings.Join(messages, " "), lineno)
 }
@@ -0,0 +1,269 @@
+package runtime
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// AttrLookup configures how the attribute names used in templates are mapped
+// to the methods and struct fields of Go values.
+type AttrLookup struct {
+	// Exact maps names to methods and fields with the same name, e.g.
+	// `user.FirstName`.
+	Exact bool
+	// SnakeCase maps snake_case names to methods and fields with the
+	// CamelCase name, e.g. `user.first_name` to `FirstName` and `user.id`
+	// to `ID`.
+	SnakeCase bool
+	// Tags are the struct tags whose names map to fields, e.g. "json" maps
+	// `user.first_name` to a field tagged with `json:"first_name"`.
+	Tags []string
+}
+
+// DefaultAttrLookup is the attribute lookup used by `GetAttr`, it maps exact
+// names, snake_case names and `json` tag names.
+var DefaultAttrLookup = AttrLookup{Exact: true, SnakeCase: true, Tags: []string{"json"}}
+
+// GetAttr looks up the attribute of the object with `DefaultAttrLookup`.
+func GetAttr(obj any, name string) (any, bool) {
+	return DefaultAttrLookup.GetAttr(obj, name)
+}
+
+// GetAttr looks up the attribute of the object. Methods, the python methods
+// of lists and dicts, map keys and exported struct fields, also the ones of
+// embedded structs, are considered in this order. Pointers are dereferenced,
+// methods with pointer receivers are called on a copy of values that aren't
+// addressable. Missing attributes are reported by returning false.
+func (l AttrLookup) GetAttr(obj any, name string) (any, bool) {
+	if obj == nil {
+		return nil, false
+	}
+	if m, _, ok := l.method(reflect.ValueOf(obj), name); ok {
+		return m.Interface(), true
+	}
+	if m, ok := pyMethod(obj, name); ok {
+		return m, true
+	}
+	rv := indirect(reflect.ValueOf(obj))
+	switch rv.Kind() {
+	case reflect.Map:
+		return mapIndex(rv, name)
+	case reflect.Struct:
+		index, ok := l.field(rv.Type(), name)
+		if !ok {
+			return nil, false
+		}
+		f, err := rv.FieldByIndexErr(index)
+		if err != nil {
+			// the field is in a nil embedded struct
+			return nil, false
+		}
+		return f.Interface(), true
+	}
+	return nil, false
+}
+
+// MethodName returns the name of the Go method of the object the attribute
+// name maps to.
+func (l AttrLookup) MethodName(obj any, name string) (string, bool) {
+	if obj == nil {
+		return "", false
+	}
+	_, methodName, ok := l.method(reflect.ValueOf(obj), name)
+	return methodName, ok
+}
+
+// method looks up the method on the value and all values it points to. Nil
+// pointers have no methods, calling methods with value receivers on them
+// would panic.
+func (l AttrLookup) method(rv reflect.Value, name string) (reflect.Value, string, bool) {
+	for rv.IsValid() {
+		if rv.Kind() == reflect.Pointer && rv.IsNil() {
+			break
+		}
+		if rv.Kind() != reflect.Interface {
+			if methodName, ok := l.methodName(rv.Type(), name); ok {
+				return rv.MethodByName(methodName), methodName, true
+			}
+			if rv.Kind() != reflect.Pointer && rv.Kind() != reflect.Interface {
+				// methods with pointer receivers of values that aren't addressable
+				ptr := reflect.PointerTo(rv.Type())
+				if methodName, ok := l.methodName(ptr, name); ok {
+					if rv.CanAddr() {
+						return rv.Addr().MethodByName(methodName), methodName, true
+					}
+					cp := reflect.New(rv.Type())
+					cp.Elem().Set(rv)
+					return cp.MethodByName(methodName), methodName, true
+				}
+				break
+			}
+		}
+		if rv.IsNil() {
+			break
+		}
+		rv = rv.Elem()
+	}
+	return reflect.Value{}, "", false
+}
+
+type lookupKey struct {
+	t         reflect.Type
+	exact     bool
+	snakeCase bool
+	tags      string
+}
+
+// attrIndex maps the attribute names of a type to the names of its methods
+// and the indexes of its fields.
+type attrIndex struct {
+	methods map[string]string
+	fields  map[string][]int
+}
+
+// lookupCache caches the attribute index of every type. The index is built
+// once per type, so the cache doesn't grow with the names templates look up.
+var lookupCache sync.Map
+
+// index returns the attribute index of the type.
+func (l AttrLookup) index(t reflect.Type) *attrIndex {
+	key := lookupKey{t: t, exact: l.Exact, snakeCase: l.SnakeCase, tags: strings.Join(l.Tags, ",")}
+	if v, ok := lookupCache.Load(key); ok {
+		return v.(*attrIndex)
+	}
+	idx := &attrIndex{methods: make(map[string]string), fields: make(map[string][]int)}
+	// exact names take precedence over snake_case names
+	if l.Exact {
+		for i := 0; i < t.NumMethod(); i++ {
+			if m := t.Method(i); m.IsExported() {
+				idx.methods[m.Name] = m.Name
+			}
+		}
+	}
+	if l.SnakeCase {
+		for i := 0; i < t.NumMethod(); i++ {
+			if m := t.Method(i); m.IsExported() {
+				if _, ok := idx.methods[ToSnakeCase(m.Name)]; !ok {
+					idx.methods[ToSnakeCase(m.Name)] = m.Name
+				}
+			}
+		}
+	}
+	if t.Kind() == reflect.Struct {
+		l.indexFields(t, idx.fields)
+	}
+	v, _ := lookupCache.LoadOrStore(key, idx)
+	return v.(*attrIndex)
+}
+
+// indexFields adds the exported fields of the struct type to the index.
+// Exact names take precedence over tag names and snake_case names.
+func (l AttrLookup) indexFields(t reflect.Type, fields map[string][]int) {
+	var visibleFields []reflect.StructField
+	for _, f := range reflect.VisibleFields(t) {
+		// promoted fields have longer indexes than the fields they are
+		// shadowed by, those aren't accessible
+		if f.IsExported() && !f.Anonymous && visible(t, f) {
+			visibleFields = append(visibleFields, f)
+		}
+	}
+	names := []func(f reflect.StructField) []string{
+		func(f reflect.StructField) []string {
+			if l.Exact {
+				return []string{f.Name}
+			}
+			return nil
+		},
+		func(f reflect.StructField) []string {
+			var res []string
+			for _, tag := range l.Tags {
+				if name := tagName(f, tag); name != "" {
+					res = append(res, name)
+				}
+			}
+			return res
+		},
+		func(f reflect.StructField) []string {
+			if l.SnakeCase {
+				return []string{ToSnakeCase(f.Name)}
+			}
+			return nil
+		},
+	}
+	for _, fieldNames := range names {
+		level := make(map[string][]int)
+		for _, f := range visibleFields {
+			for _, name := range fieldNames(f) {
+				if _, ok := fields[name]; !ok {
+					if _, ok := level[name]; !ok {
+						level[name] = f.Index
+					}
+				}
+			}
+		}
+		for name, index := range level {
+			fields[name] = index
+		}
+	}
+}
+
+// methodName returns the name of the exported method of the type the name maps to.
+func (l AttrLookup) methodName(t reflect.Type, name string) (string, bool) {
+	if t.NumMethod() == 0 {
+		return "", false
+	}
+	res, ok := l.index(t).methods[name]
+	return res, ok
+}
+
+// field returns the index of the exported field of the struct type the name
+// maps to.
+func (l AttrLookup) field(t reflect.Type, name string) ([]int, bool) {
+	res, ok := l.index(t).fields[name]
+	return res, ok
+}
+
+// visible reports whether the field isn't shadowed by a field with the same
+// name on a shallower level.
+func visible(t reflect.Type, f reflect.StructField) bool {
+	sf, ok := t.FieldByName(f.Name)
+	return ok && len(sf.Index) == len(f.Index)
+}
+
+// tagName returns the name of the field in the struct tag, e.g. "name" for
+// `json:"name,omitempty"`.
+func tagName(f reflect.StructField, tag string) string {
+	value, ok := f.Tag.Lookup(tag)
+	if !ok {
+		return ""
+	}
+	name, _, _ := strings.Cut(value, ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// ToSnakeCase converts a CamelCase Go name to snake_case, keeping initialisms
+// together, e.g. "UserID" to "user_id" and "HTTPServer" to "http_server".
+func ToSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
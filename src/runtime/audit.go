@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/utils"
+)
+
+// UndefinedAccess is a lookup of an undefined variable, attribute or item.
+type UndefinedAccess struct {
+	// Template is the name of the template, empty if it has none.
+	Template string
+	Lineno   int
+	// Name is the name of the variable or attribute, or the representation
+	// of the key of the item.
+	Name string
+	// Object is the type of the object the attribute or item was looked up
+	// on, e.g. "dict object", it's empty for variables and undefined values
+	// with only a hint, like missing macro parameters.
+	Object string
+	// Message is the message of the error undefined values fail with.
+	Message string
+}
+
+func (a UndefinedAccess) String() string {
+	if a.Template != "" {
+		return fmt.Sprintf("%s:%d: %s", a.Template, a.Lineno, a.Message)
+	}
+	return fmt.Sprintf("line %d: %s", a.Lineno, a.Message)
+}
+
+// UndefinedReport collects the undefined variables, attributes and items
+// looked up during a render. Every lookup is reported once per line.
+type UndefinedReport struct {
+	Accesses []UndefinedAccess
+
+	seen map[UndefinedAccess]struct{}
+}
+
+// Record reports the lookup of the undefined value with the hint, object and
+// name it's created with.
+func (r *UndefinedReport) Record(template string, lineno int, hint *string, obj any, name any) {
+	access := UndefinedAccess{
+		Template: template,
+		Lineno:   lineno,
+		Name:     undefinedName(name),
+		Message:  BaseUndefined{hint: hint, obj: obj, name: name}.Message(),
+	}
+	if _, missing := obj.(utils.Missing); !missing && obj != nil {
+		access.Object = ObjectTypeRepr(obj)
+	}
+	if r.seen == nil {
+		r.seen = make(map[UndefinedAccess]struct{})
+	}
+	if _, ok := r.seen[access]; ok {
+		return
+	}
+	r.seen[access] = struct{}{}
+	r.Accesses = append(r.Accesses, access)
+}
+
+// Empty reports whether no undefined value was looked up.
+func (r *UndefinedReport) Empty() bool {
+	return r == nil || len(r.Accesses) == 0
+}
+
+// String returns the accesses, one per line.
+func (r *UndefinedReport) String() string {
+	if r == nil {
+		return ""
+	}
+	lines := make([]string, len(r.Accesses))
+	for i, access := range r.Accesses {
+		lines[i] = access.String()
+	}
+	return strings.Join(lines, "\n")
+}
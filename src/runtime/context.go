@@ -1,5 +1,96 @@
 package runtime
 
-type Context struct{}
+import (
+	"io"
+
+	"github.com/gojinja/gojinja/src/utils"
+	"github.com/gojinja/gojinja/src/utils/set"
+)
+
+// EvalContext holds evaluation time information, like whether output is
+// autoescaped. It may change during rendering, e.g. inside of
+// `{% autoescape %}` blocks.
+type EvalContext struct {
+	AutoEscape bool
+	Volatile   bool
+}
+
+// BlockFunc renders a single block with the given context into w.
+type BlockFunc func(ctx *Context, w io.Writer) error
+
+// Context is the template context. It holds the variables of a template, it stores
+// the values passed to the template and also the names the template exports.
+//
+// The template context supports read only operations; modifications are only done
+// by the template itself for variables assigned on the top level.
+type Context struct {
+	Parent       map[string]any
+	Vars         map[string]any
+	ExportedVars set.Set[string]
+	Name         *string
+	Blocks       map[string][]BlockFunc
+	EvalCtx      *EvalContext
+	// State is shared by all contexts of a render, it's nil outside of renders.
+	State *RenderState
+}
 
 type ContextClass struct{}
+
+// ContextFunction is a function that is passed the active context as first
+// argument when called from a template.
+type ContextFunction func(ctx *Context, args []any, kwargs map[string]any) (any, error)
+
+// NewContext creates a context with the given parent (globals and the variables passed to the template).
+func NewContext(parent map[string]any, name *string, blocks map[string][]BlockFunc, evalCtx *EvalContext) *Context {
+	if blocks == nil {
+		blocks = make(map[string][]BlockFunc)
+	}
+	return &Context{
+		Parent:       parent,
+		Vars:         make(map[string]any),
+		ExportedVars: set.New[string](),
+		Name:         name,
+		Blocks:       blocks,
+		EvalCtx:      evalCtx,
+	}
+}
+
+// ResolveOrMissing looks up a variable like `Resolve` but returns `utils.Missing` if the lookup failed.
+func (c *Context) ResolveOrMissing(key string) any {
+	if v, ok := c.Vars[key]; ok {
+		return v
+	}
+	if v, ok := c.Parent[key]; ok {
+		return v
+	}
+	return utils.GetMissing()
+}
+
+// GetExported returns a map with the exported variables.
+func (c *Context) GetExported() map[string]any {
+	res := make(map[string]any, len(c.ExportedVars))
+	for k := range c.ExportedVars {
+		res[k] = c.Vars[k]
+	}
+	return res
+}
+
+// GetAll returns the complete context as a map including the exported variables.
+func (c *Context) GetAll() map[string]any {
+	res := make(map[string]any, len(c.Parent)+len(c.Vars))
+	for k, v := range c.Parent {
+		res[k] = v
+	}
+	for k, v := range c.Vars {
+		res[k] = v
+	}
+	return res
+}
+
+// Call calls the value with the arguments. Context functions are passed the context.
+func (c *Context) Call(fn any, args []any, kwargs map[string]any) (any, error) {
+	if f, ok := fn.(ContextFunction); ok {
+		return f(c, args, kwargs)
+	}
+	return Call(fn, args, kwargs)
+}
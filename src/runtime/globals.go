@@ -0,0 +1,337 @@
+package runtime
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gojinja/gojinja/src/errors"
+)
+
+// MaxRange is the default maximum number of items `Range` produces.
+const MaxRange = 100000
+
+// Range works like python's `range`: `range(stop)`, `range(start, stop)` or
+// `range(start, stop, step)`. Ranges with more than `MaxRange` items are
+// rejected.
+func Range(args []any, kwargs map[string]any) (any, error) {
+	return rangeLimited(args, kwargs, MaxRange)
+}
+
+// ContextRange is `Range` as a context function, the maximum size of ranges
+// is taken from the render state of the context.
+func ContextRange(ctx *Context, args []any, kwargs map[string]any) (any, error) {
+	return rangeLimited(args, kwargs, ctx.State.maxRange())
+}
+
+func rangeLimited(args []any, kwargs map[string]any, max int) (any, error) {
+	if len(kwargs) > 0 {
+		return nil, typeError("range() takes no keyword arguments")
+	}
+	if len(args) < 1 || len(args) > 3 {
+		return nil, typeError("range expected 1 to 3 arguments, got %d", len(args))
+	}
+	ints := make([]int64, len(args))
+	for i, arg := range args {
+		n, ok := ToInt(arg)
+		if !ok {
+			if b, isBool := arg.(bool); isBool {
+				n, ok = map[bool]int64{false: 0, true: 1}[b], true
+			}
+		}
+		if !ok {
+			return nil, typeError("'%s' object cannot be interpreted as an integer", TypeName(arg))
+		}
+		ints[i] = n
+	}
+
+	start, stop, step := int64(0), ints[0], int64(1)
+	if len(ints) > 1 {
+		start, stop = ints[0], ints[1]
+	}
+	if len(ints) > 2 {
+		step = ints[2]
+	}
+	if step == 0 {
+		return nil, typeError("range() arg 3 must not be zero")
+	}
+
+	// The span and the step are computed as unsigned integers, as they don't
+	// fit into an int64 for ranges close to the limits.
+	var length uint64
+	if step > 0 && start < stop {
+		length = (uint64(stop)-uint64(start)-1)/uint64(step) + 1
+	} else if step < 0 && start > stop {
+		length = (uint64(start)-uint64(stop)-1)/(0-uint64(step)) + 1
+	}
+	if length > uint64(max) {
+		return nil, errors.NewLimitExceeded("range", max, fmt.Sprintf("Range too big. The sandbox blocks ranges larger than MAX_RANGE (%d).", max))
+	}
+	res := make([]any, length)
+	for i := range res {
+		res[i] = start + int64(i)*step
+	}
+	return res, nil
+}
+
+// Dict works like python's `dict`: it creates a dict from an optional mapping
+// or iterable of pairs and the keyword arguments.
+func Dict(args []any, kwargs map[string]any) (any, error) {
+	if len(args) > 1 {
+		return nil, typeError("dict expected at most 1 argument, got %d", len(args))
+	}
+	res := make(map[any]any, len(kwargs))
+	if len(args) == 1 {
+		update, _ := pyMethod(res, "update")
+		if _, err := update.(method)(args, nil); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range kwargs {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// Cycler cycles through values by yielding them one at a time, then
+// restarting once the end is reached.
+type Cycler struct {
+	items []any
+	pos   int
+}
+
+// NewCycler creates a cycler over the items, at least one is required.
+func NewCycler(items ...any) (*Cycler, error) {
+	if len(items) == 0 {
+		return nil, typeError("at least one item has to be provided")
+	}
+	return &Cycler{items: items}, nil
+}
+
+// Reset resets the current item to the first item.
+func (c *Cycler) Reset() {
+	c.pos = 0
+}
+
+// Current returns the current item. Equivalent to the item that will be
+// returned next time `Next` is called.
+func (c *Cycler) Current() any {
+	return c.items[c.pos]
+}
+
+// Next returns the current item, then advances `Current` to the next item.
+func (c *Cycler) Next() any {
+	rv := c.Current()
+	c.pos = (c.pos + 1) % len(c.items)
+	return rv
+}
+
+// GetAttr returns the attributes of the cycler by their template names.
+func (c *Cycler) GetAttr(name string) (any, bool) {
+	switch name {
+	case "current":
+		return c.Current(), true
+	case "next":
+		return func() any { return c.Next() }, true
+	case "reset":
+		return func() { c.Reset() }, true
+	case "items":
+		return c.items, true
+	}
+	return nil, false
+}
+
+// Joiner is a callable that returns an empty string the first time it's
+// called and the separator every time after that. It can be used to join
+// things, e.g. `{{ pipe() }}` between the items of a loop.
+type Joiner struct {
+	sep  string
+	used bool
+}
+
+// NewJoiner creates a joiner with the separator.
+func NewJoiner(sep string) *Joiner {
+	return &Joiner{sep: sep}
+}
+
+// Call returns the separator, or an empty string on the first call.
+func (j *Joiner) Call(args []any, kwargs map[string]any) (any, error) {
+	if len(args) > 0 || len(kwargs) > 0 {
+		return nil, typeError("joiner takes no arguments")
+	}
+	if !j.used {
+		j.used = true
+		return "", nil
+	}
+	return j.sep, nil
+}
+
+// Namespace is a container with attributes that can be assigned with the
+// `{% set ns.attr = value %}` syntax, also from inside of loops and blocks.
+type Namespace struct {
+	attrs map[string]any
+}
+
+// NewNamespace creates a namespace with the initial attributes.
+func NewNamespace(attrs map[string]any) *Namespace {
+	ns := &Namespace{attrs: make(map[string]any, len(attrs))}
+	for k, v := range attrs {
+		ns.attrs[k] = v
+	}
+	return ns
+}
+
+// Get returns the attribute.
+func (ns *Namespace) Get(name string) (any, bool) {
+	v, ok := ns.attrs[name]
+	return v, ok
+}
+
+// Set sets the attribute.
+func (ns *Namespace) Set(name string, value any) {
+	ns.attrs[name] = value
+}
+
+// GetAttr returns the attribute, false if it isn't set.
+func (ns *Namespace) GetAttr(name string) (any, bool) {
+	return ns.Get(name)
+}
+
+func (ns *Namespace) String() string {
+	return fmt.Sprintf("<Namespace %s>", Repr(ns.attrs))
+}
+
+// loremIpsumWords are the words used by `GenerateLoremIpsum`.
+var loremIpsumWords = strings.Fields(`a ac accumsan ad adipiscing aenean aliquam aliquet amet ante
+aptent arcu at auctor augue bibendum blandit class commodo condimentum congue
+consectetuer consequat conubia convallis cras cubilia cum curabitur curae cursus
+dapibus diam dictum dictumst dignissim dis dolor donec dui duis egestas eget
+eleifend elementum elit enim erat eros est et etiam eu euismod facilisi
+facilisis fames faucibus felis fermentum feugiat fringilla fusce gravida
+habitant habitasse hac hendrerit hymenaeos iaculis id imperdiet in inceptos
+integer interdum ipsum justo lacinia lacus laoreet lectus leo libero ligula
+litora lobortis lorem luctus maecenas magna magnis malesuada massa mattis
+mauris metus mi molestie mollis montes morbi mus nam nascetur natoque nec neque
+netus nibh nisi nisl non nonummy nostra nulla nullam nunc odio orci ornare
+parturient pede pellentesque penatibus per pharetra phasellus placerat platea
+porta porttitor posuere potenti praesent pretium primis proin pulvinar purus
+quam quis quisque rhoncus ridiculus risus rutrum sagittis sapien scelerisque
+sed sem semper senectus sit sociis sociosqu sodales sollicitudin suscipit
+suspendisse taciti tellus tempor tempus tincidunt torquent tortor tristique
+turpis ullamcorper ultrices ultricies urna ut varius vehicula vel velit
+venenatis vestibulum vitae vivamus viverra volutpat vulputate`)
+
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + strings.ToLower(s[size:])
+}
+
+// randRange returns a random number in [min, max).
+func randRange(rnd *rand.Rand, min, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + rnd.Intn(max-min)
+}
+
+// GenerateLoremIpsum generates n paragraphs of lorem ipsum with min to max
+// words each. The paragraphs are returned as HTML markup or as plain text
+// separated by blank lines.
+func GenerateLoremIpsum(rnd *rand.Rand, n int, html bool, min, max int) any {
+	var result []string
+	for i := 0; i < n; i++ {
+		nextCapitalized := true
+		lastComma, lastFullstop := 0, 0
+		last := ""
+		var p []string
+
+		// each paragraph contains out of min to max words.
+		count := randRange(rnd, min, max)
+		for idx := 0; idx < count; idx++ {
+			var word string
+			for {
+				word = loremIpsumWords[rnd.Intn(len(loremIpsumWords))]
+				if word != last {
+					last = word
+					break
+				}
+			}
+			if nextCapitalized {
+				word = capitalize(word)
+				nextCapitalized = false
+			}
+			// add commas
+			if idx-randRange(rnd, 3, 8) > lastComma {
+				lastComma = idx
+				lastFullstop += 2
+				word += ","
+			}
+			// add end of sentences
+			if idx-randRange(rnd, 10, 20) > lastFullstop {
+				lastComma, lastFullstop = idx, idx
+				word += "."
+				nextCapitalized = true
+			}
+			p = append(p, word)
+		}
+
+		// ensure that the paragraph ends with a dot.
+		paragraph := strings.Join(p, " ")
+		if strings.HasSuffix(paragraph, ",") {
+			paragraph = paragraph[:len(paragraph)-1] + "."
+		} else if !strings.HasSuffix(paragraph, ".") {
+			paragraph += "."
+		}
+		result = append(result, paragraph)
+	}
+
+	if !html {
+		return strings.Join(result, "\n\n")
+	}
+	for i, p := range result {
+		result[i] = "<p>" + string(EscapeString(p)) + "</p>"
+	}
+	return Markup(strings.Join(result, "\n"))
+}
+
+// Lipsum is the `lipsum(n=5, html=True, min=20, max=100)` template function
+// generating lorem ipsum paragraphs.
+func Lipsum(args []any, kwargs map[string]any) (any, error) {
+	names := []string{"n", "html", "min", "max"}
+	values := map[string]any{"n": 5, "html": true, "min": 20, "max": 100}
+	if len(args) > len(names) {
+		return nil, typeError("lipsum() takes at most %d arguments (%d given)", len(names), len(args))
+	}
+	for i, arg := range args {
+		values[names[i]] = arg
+	}
+	keys := make([]string, 0, len(kwargs))
+	for k := range kwargs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := values[k]; !ok {
+			return nil, typeError("lipsum() got an unexpected keyword argument '%s'", k)
+		}
+		values[k] = kwargs[k]
+	}
+
+	ints := make(map[string]int, 3)
+	for _, name := range []string{"n", "min", "max"} {
+		v, ok := ToInt(values[name])
+		if !ok {
+			return nil, typeError("lipsum() argument '%s' must be an integer, not %s", name, TypeName(values[name]))
+		}
+		ints[name] = int(v)
+	}
+	html, err := Bool(values["html"])
+	if err != nil {
+		return nil, err
+	}
+	return GenerateLoremIpsum(rand.New(rand.NewSource(time.Now().UnixNano())), ints["n"], html, ints["min"], ints["max"]), nil
+}
@@ -0,0 +1,201 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Iterator iterates lazily over a sequence of items. Values implementing it
+// can be iterated in for loops without buffering all items. If the iterator
+// also has a `Close()` method it's called when the loop stops early.
+type Iterator interface {
+	// Next returns the next item, ok is false if there are no more items.
+	Next() (item any, ok bool, err error)
+}
+
+// sliceIterator iterates over already known items.
+type sliceIterator struct {
+	items []any
+}
+
+func (it *sliceIterator) Next() (any, bool, error) {
+	if len(it.items) == 0 {
+		return nil, false, nil
+	}
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, true, nil
+}
+
+// Remaining returns the number of items left.
+func (it *sliceIterator) Remaining() int {
+	return len(it.items)
+}
+
+// chanIterator receives the items from a channel until it's closed.
+type chanIterator struct {
+	ch reflect.Value
+}
+
+func (it *chanIterator) Next() (any, bool, error) {
+	item, ok := it.ch.Recv()
+	if !ok {
+		return nil, false, nil
+	}
+	return item.Interface(), true, nil
+}
+
+// seqIterator pulls the items from a push style iterator function, like
+// `func(yield func(T) bool)`, that runs in its own goroutine.
+type seqIterator struct {
+	fn      reflect.Value
+	items   chan any
+	done    chan struct{}
+	err     error
+	started bool
+	closed  bool
+}
+
+func (it *seqIterator) start() {
+	it.started = true
+	it.items = make(chan any)
+	it.done = make(chan struct{})
+	yieldType := it.fn.Type().In(0)
+	yield := reflect.MakeFunc(yieldType, func(args []reflect.Value) []reflect.Value {
+		var item any
+		if len(args) == 1 {
+			item = args[0].Interface()
+		} else {
+			pair := make([]any, len(args))
+			for i, arg := range args {
+				pair[i] = arg.Interface()
+			}
+			item = pair
+		}
+		select {
+		case it.items <- item:
+			return []reflect.Value{reflect.ValueOf(true)}
+		case <-it.done:
+			return []reflect.Value{reflect.ValueOf(false)}
+		}
+	})
+	go func() {
+		defer close(it.items)
+		defer func() {
+			if r := recover(); r != nil {
+				it.err = fmt.Errorf("iterator panicked: %v", r)
+			}
+		}()
+		it.fn.Call([]reflect.Value{yield})
+	}()
+}
+
+func (it *seqIterator) Next() (any, bool, error) {
+	if it.closed {
+		return nil, false, nil
+	}
+	if !it.started {
+		it.start()
+	}
+	item, ok := <-it.items
+	if !ok {
+		it.closed = true
+		return nil, false, it.err
+	}
+	return item, true, nil
+}
+
+// Close stops the iterator function if it's still running.
+func (it *seqIterator) Close() {
+	if it.started && !it.closed {
+		it.closed = true
+		close(it.done)
+		for range it.items {
+		}
+	}
+}
+
+// isSeq reports whether the function type is a push style iterator,
+// `func(yield func(T) bool)` or `func(yield func(K, V) bool)`.
+func isSeq(t reflect.Type) bool {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 0 {
+		return false
+	}
+	yield := t.In(0)
+	return yield.Kind() == reflect.Func && (yield.NumIn() == 1 || yield.NumIn() == 2) &&
+		yield.NumOut() == 1 && yield.Out(0).Kind() == reflect.Bool
+}
+
+// Iter returns an iterator over the value. Channels, `Iterator` values and
+// iterator functions like `func(yield func(T) bool)` are iterated lazily,
+// other values are iterated like `Iterate` does. Iterator functions yielding
+// two values produce `[]any{k, v}` pairs.
+func Iter(v any) (Iterator, error) {
+	if it, ok := v.(Iterator); ok {
+		return it, nil
+	}
+	if v != nil {
+		rv := reflect.ValueOf(v)
+		switch {
+		case rv.Kind() == reflect.Chan:
+			return &chanIterator{ch: rv}, nil
+		case isSeq(rv.Type()) && !rv.IsNil():
+			return &seqIterator{fn: rv}, nil
+		}
+	}
+	items, err := Iterate(v)
+	if err != nil {
+		return nil, err
+	}
+	return &sliceIterator{items: items}, nil
+}
+
+// CloseIterator closes the iterator if it has a `Close()` method.
+func CloseIterator(it Iterator) {
+	if c, ok := it.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// filterIterator skips the items the predicate doesn't accept.
+type filterIterator struct {
+	it   Iterator
+	pred func(item any) (bool, error)
+}
+
+func (it *filterIterator) Next() (any, bool, error) {
+	for {
+		item, ok, err := it.it.Next()
+		if !ok || err != nil {
+			return nil, false, err
+		}
+		accept, err := it.pred(item)
+		if err != nil {
+			return nil, false, err
+		}
+		if accept {
+			return item, true, nil
+		}
+	}
+}
+
+func (it *filterIterator) Close() {
+	CloseIterator(it.it)
+}
+
+// FilterIterator returns an iterator over the items of it the predicate accepts.
+func FilterIterator(it Iterator, pred func(item any) (bool, error)) Iterator {
+	return &filterIterator{it: it, pred: pred}
+}
+
+// drain consumes all items of the iterator.
+func drain(it Iterator) ([]any, error) {
+	var res []any
+	for {
+		item, ok, err := it.Next()
+		if err != nil || !ok {
+			return res, err
+		}
+		res = append(res, item)
+	}
+}
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/gojinja/gojinja/src/errors"
+)
+
+// RenderState tracks the resources used by a single render against its
+// limits. It's shared by all contexts of the render, e.g. the ones of
+// included templates. Limits of 0 are unlimited.
+type RenderState struct {
+	// Ctx aborts the render if it's canceled.
+	Ctx               context.Context
+	MaxSteps          int
+	MaxRecursionDepth int
+	// MaxOutputBytes is the maximum size of the output and of every string
+	// built during the render, like captured blocks.
+	MaxOutputBytes int
+	// MaxRange is the maximum size of ranges, `MaxRange` if 0.
+	MaxRange int
+	// Undefined collects the undefined values looked up if it's not nil.
+	Undefined *UndefinedReport
+
+	steps int
+	depth int
+}
+
+// Step counts an evaluation step of the render. It fails if the render used
+// up all steps or its context is done. It's a no-op on a nil state.
+func (s *RenderState) Step() error {
+	if s == nil {
+		return nil
+	}
+	s.steps++
+	if s.MaxSteps > 0 && s.steps > s.MaxSteps {
+		return errors.NewLimitExceeded("steps", s.MaxSteps, "")
+	}
+	if s.Ctx != nil {
+		select {
+		case <-s.Ctx.Done():
+			return errors.NewCanceled(s.Ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// Enter counts a level of recursion, like a macro call or an include, it
+// fails if the render is nested too deeply. Every successful call must be
+// followed by a call to `Leave`.
+func (s *RenderState) Enter() error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxRecursionDepth > 0 && s.depth >= s.MaxRecursionDepth {
+		return errors.NewLimitExceeded("recursion depth", s.MaxRecursionDepth, "")
+	}
+	s.depth++
+	return nil
+}
+
+// Leave leaves a level of recursion entered with `Enter`.
+func (s *RenderState) Leave() {
+	if s != nil {
+		s.depth--
+	}
+}
+
+// CheckOutput fails if n bytes exceed the maximum output size. It's a no-op
+// on a nil state.
+func (s *RenderState) CheckOutput(n int) error {
+	if s != nil && s.MaxOutputBytes > 0 && n > s.MaxOutputBytes {
+		return errors.NewLimitExceeded("output bytes", s.MaxOutputBytes, "")
+	}
+	return nil
+}
+
+// maxRange returns the maximum size of ranges.
+func (s *RenderState) maxRange() int {
+	if s == nil || s.MaxRange <= 0 {
+		return MaxRange
+	}
+	return s.MaxRange
+}
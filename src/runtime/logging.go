@@ -0,0 +1,312 @@
+package runtime
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gojinja/gojinja/src/errors"
+	"github.com/gojinja/gojinja/src/utils"
+)
+
+// LocatedUndefined is implemented by undefined values recording where they
+// were created. The renderer passes the name of the template and the line of
+// the expression accessing the missing value to `WithLocation`.
+type LocatedUndefined interface {
+	IUndefined
+	WithLocation(template string, lineno int) IUndefined
+}
+
+// LoggingUndefined wraps an undefined value and logs when it's printed,
+// iterated over or used as a bool, and when an operation on it fails. It's
+// created by the constructor returned from `MakeLoggingUndefined`.
+type LoggingUndefined struct {
+	base     IUndefined
+	logger   *slog.Logger
+	hint     *string
+	obj      any
+	name     any
+	template string
+	lineno   int
+}
+
+// MakeLoggingUndefined returns a constructor of undefined values behaving like
+// the ones created by base (`NewUndefined` if nil) and logging to the logger
+// (`slog.Default()` if nil). Using the values is logged as a warning, failing
+// operations as an error, the records have the template name, the line and
+// the name of the missing variable as attributes.
+func MakeLoggingUndefined(logger *slog.Logger, base UndefinedConstructor) UndefinedConstructor {
+	if base == nil {
+		base = NewUndefined
+	}
+	return func(hint *string, obj any, name any, exc func(msg string) error) IUndefined {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		return LoggingUndefined{
+			base:   base(hint, obj, name, exc),
+			logger: l,
+			hint:   hint,
+			obj:    obj,
+			name:   name,
+		}
+	}
+}
+
+var (
+	_ LocatedUndefined = LoggingUndefined{}
+	_ AddOp            = LoggingUndefined{}
+	_ RAddOp           = LoggingUndefined{}
+	_ EqOp             = LoggingUndefined{}
+	_ LtOp             = LoggingUndefined{}
+	_ NegOp            = LoggingUndefined{}
+	_ ContainsOp       = LoggingUndefined{}
+	_ LenOp            = LoggingUndefined{}
+	_ IterOp           = LoggingUndefined{}
+	_ GetItemOp        = LoggingUndefined{}
+	_ CallOp           = LoggingUndefined{}
+	_ BoolOp           = LoggingUndefined{}
+	_ Escaped          = LoggingUndefined{}
+)
+
+func (LoggingUndefined) Undefined() {}
+
+// WithLocation returns a copy of the value created at the line of the template.
+func (u LoggingUndefined) WithLocation(template string, lineno int) IUndefined {
+	u.template = template
+	u.lineno = lineno
+	return u
+}
+
+// Base returns the wrapped undefined value.
+func (u LoggingUndefined) Base() IUndefined {
+	return u.base
+}
+
+// Message returns the message of the error returned by failing operations.
+func (u LoggingUndefined) Message() string {
+	if m, ok := u.base.(interface{ Message() string }); ok {
+		return m.Message()
+	}
+	return BaseUndefined{hint: u.hint, obj: u.obj, name: u.name}.Message()
+}
+
+// Fail returns the error of failing operations.
+func (u LoggingUndefined) Fail() error {
+	if f, ok := u.base.(interface{ Fail() error }); ok {
+		return f.Fail()
+	}
+	return errors.NewUndefinedError(u.Message())
+}
+
+func (u LoggingUndefined) log(level slog.Level, msg string, op string, err error) {
+	attrs := []slog.Attr{
+		slog.String("template", u.template),
+		slog.Int("line", u.lineno),
+	}
+	if u.name != nil {
+		attrs = append(attrs, slog.String("variable", undefinedName(u.name)))
+	}
+	if _, missing := u.obj.(utils.Missing); !missing && u.obj != nil {
+		attrs = append(attrs, slog.String("object", ObjectTypeRepr(u.obj)))
+	}
+	attrs = append(attrs, slog.String("operation", op))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else {
+		attrs = append(attrs, slog.String("message", u.Message()))
+	}
+	u.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// used logs the operation, it's logged as a failure if err isn't nil.
+func (u LoggingUndefined) used(op string, err error) error {
+	if err != nil {
+		u.log(slog.LevelError, "undefined value operation failed", op, err)
+	} else {
+		u.log(slog.LevelWarn, "undefined value used", op, nil)
+	}
+	return err
+}
+
+// failed logs the operation if err isn't nil.
+func (u LoggingUndefined) failed(op string, err error) error {
+	if err != nil {
+		u.log(slog.LevelError, "undefined value operation failed", op, err)
+	}
+	return err
+}
+
+// wrap makes undefined values returned by the wrapped value log too, e.g. the
+// attributes of `ChainableUndefined`.
+func (u LoggingUndefined) wrap(v any) any {
+	if un, ok := v.(IUndefined); ok {
+		if _, ok := un.(LoggingUndefined); !ok {
+			u.base = un
+			return u
+		}
+	}
+	return v
+}
+
+func unwrapLogging(v any) any {
+	if u, ok := v.(LoggingUndefined); ok {
+		return u.base
+	}
+	return v
+}
+
+// binary applies the method of the wrapped value implementing the operator.
+func (u LoggingUndefined) binary(op string, method func(string, any) func(any) (any, error), other any) (any, error) {
+	m := method(op, u.base)
+	if m == nil {
+		return NotImplemented, nil
+	}
+	res, err := m(unwrapLogging(other))
+	return res, u.failed(op, err)
+}
+
+func (u LoggingUndefined) Add(other any) (any, error) {
+	return u.binary("add", leftMethod, other)
+}
+
+func (u LoggingUndefined) RAdd(other any) (any, error) {
+	return u.binary("add", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) Sub(other any) (any, error) {
+	return u.binary("sub", leftMethod, other)
+}
+
+func (u LoggingUndefined) RSub(other any) (any, error) {
+	return u.binary("sub", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) Mul(other any) (any, error) {
+	return u.binary("mul", leftMethod, other)
+}
+
+func (u LoggingUndefined) RMul(other any) (any, error) {
+	return u.binary("mul", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) Div(other any) (any, error) {
+	return u.binary("div", leftMethod, other)
+}
+
+func (u LoggingUndefined) RDiv(other any) (any, error) {
+	return u.binary("div", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) FloorDiv(other any) (any, error) {
+	return u.binary("floordiv", leftMethod, other)
+}
+
+func (u LoggingUndefined) RFloorDiv(other any) (any, error) {
+	return u.binary("floordiv", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) Mod(other any) (any, error) {
+	return u.binary("mod", leftMethod, other)
+}
+
+func (u LoggingUndefined) RMod(other any) (any, error) {
+	return u.binary("mod", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) Pow(other any) (any, error) {
+	return u.binary("pow", leftMethod, other)
+}
+
+func (u LoggingUndefined) RPow(other any) (any, error) {
+	return u.binary("pow", reflectedMethod, other)
+}
+
+func (u LoggingUndefined) Eq(other any) (any, error) {
+	return u.binary("eq", leftMethod, other)
+}
+
+func (u LoggingUndefined) Ne(other any) (any, error) {
+	return u.binary("ne", leftMethod, other)
+}
+
+func (u LoggingUndefined) Lt(other any) (any, error) {
+	return u.binary("lt", leftMethod, other)
+}
+
+func (u LoggingUndefined) Le(other any) (any, error) {
+	return u.binary("lteq", leftMethod, other)
+}
+
+func (u LoggingUndefined) Gt(other any) (any, error) {
+	return u.binary("gt", leftMethod, other)
+}
+
+func (u LoggingUndefined) Ge(other any) (any, error) {
+	return u.binary("gteq", leftMethod, other)
+}
+
+func (u LoggingUndefined) Neg() (any, error) {
+	res, err := Neg(u.base)
+	return res, u.failed("neg", err)
+}
+
+func (u LoggingUndefined) Pos() (any, error) {
+	res, err := Pos(u.base)
+	return res, u.failed("pos", err)
+}
+
+func (u LoggingUndefined) GetAttr(name string) (any, error) {
+	if g, ok := u.base.(interface{ GetAttr(string) (any, error) }); ok {
+		res, err := g.GetAttr(name)
+		return u.wrap(res), u.failed("getattr", err)
+	}
+	return nil, u.failed("getattr", u.Fail())
+}
+
+func (u LoggingUndefined) GetItem(key any) (any, error) {
+	if g, ok := u.base.(GetItemOp); ok {
+		res, err := g.GetItem(key)
+		return u.wrap(res), u.failed("getitem", err)
+	}
+	return nil, u.failed("getitem", u.Fail())
+}
+
+func (u LoggingUndefined) Call(args []any, kwargs map[string]any) (any, error) {
+	res, err := Call(u.base, args, kwargs)
+	return u.wrap(res), u.failed("call", err)
+}
+
+func (u LoggingUndefined) Contains(item any) (bool, error) {
+	res, err := Contains(u.base, item)
+	return res, u.used("contains", err)
+}
+
+func (u LoggingUndefined) Bool() (bool, error) {
+	res, err := Bool(u.base)
+	return res, u.used("bool", err)
+}
+
+func (u LoggingUndefined) Len() (int, error) {
+	res, err := Len(u.base)
+	return res, u.used("len", err)
+}
+
+func (u LoggingUndefined) Iter() ([]any, error) {
+	res, err := Iterate(u.base)
+	return res, u.used("iter", err)
+}
+
+func (u LoggingUndefined) String_() (string, error) {
+	res, err := ToString(u.base)
+	return res, u.used("str", err)
+}
+
+func (u LoggingUndefined) HTML() (string, error) {
+	res, err := Escape(u.base)
+	return string(res), u.used("str", err)
+}
+
+func (u LoggingUndefined) Repr() string {
+	return Repr(u.base)
+}
@@ -0,0 +1,250 @@
+package runtime
+
+import "fmt"
+
+// LoopContext is the `loop` variable available in the body of for loops.
+// The items are consumed lazily, only `length`, `revindex` and `revindex0`
+// need to know all remaining items. Filtered loops only see the items that
+// passed the filter, so `loop.last` and `loop.length` refer to the filtered
+// items.
+type LoopContext struct {
+	it        Iterator
+	index0    int
+	depth0    int
+	length    int
+	hasLength bool
+	before    any
+	current   any
+	after     any
+	hasAfter  bool
+	peeked    bool
+
+	lastChanged    []any
+	hasLastChanged bool
+
+	undefined func(hint *string, obj any, name any) IUndefined
+	// Recurse renders the loop body for the items of a nested level in
+	// recursive loops. It's nil for loops not marked as recursive.
+	Recurse func(iterable any) (any, error)
+}
+
+// NewLoopContext creates the loop context for iterating over the items of a
+// loop of the given depth (0 for the outermost loop). The undefined function
+// creates the undefined values, e.g. `previtem` on the first iteration.
+func NewLoopContext(it Iterator, depth0 int, undefined func(hint *string, obj any, name any) IUndefined) *LoopContext {
+	l := &LoopContext{it: it, index0: -1, depth0: depth0, undefined: undefined}
+	if s, ok := it.(interface{ Remaining() int }); ok {
+		l.length = s.Remaining()
+		l.hasLength = true
+	}
+	return l
+}
+
+func (l *LoopContext) newUndefined(hint string) IUndefined {
+	if l.undefined == nil {
+		return NewUndefined(&hint, nil, nil, nil)
+	}
+	return l.undefined(&hint, nil, nil)
+}
+
+// peekNext returns the next item without consuming it.
+func (l *LoopContext) peekNext() (any, bool, error) {
+	if !l.peeked {
+		item, ok, err := l.it.Next()
+		if err != nil {
+			return nil, false, err
+		}
+		l.after, l.hasAfter, l.peeked = item, ok, true
+	}
+	return l.after, l.hasAfter, nil
+}
+
+// Next advances the loop to the next item and returns it. The second result
+// is false if there are no more items.
+func (l *LoopContext) Next() (any, bool, error) {
+	item, ok, err := l.peekNext()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	l.peeked = false
+	if l.index0 >= 0 {
+		l.before = l.current
+	}
+	l.current = item
+	l.index0++
+	return item, true, nil
+}
+
+// Index0 returns the current iteration of the loop, 0 indexed.
+func (l *LoopContext) Index0() int {
+	return l.index0
+}
+
+// Index returns the current iteration of the loop, 1 indexed.
+func (l *LoopContext) Index() int {
+	return l.index0 + 1
+}
+
+// Depth0 returns how deep in a recursive loop the rendering is, starting at 0.
+func (l *LoopContext) Depth0() int {
+	return l.depth0
+}
+
+// Depth returns how deep in a recursive loop the rendering is, starting at 1.
+func (l *LoopContext) Depth() int {
+	return l.depth0 + 1
+}
+
+// Length returns the number of items in the loop. If the items are iterated
+// lazily, the remaining items are consumed and buffered.
+func (l *LoopContext) Length() (int, error) {
+	if l.hasLength {
+		return l.length, nil
+	}
+	var rest []any
+	if l.peeked && l.hasAfter {
+		rest = append(rest, l.after)
+	}
+	for {
+		item, ok, err := l.it.Next()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		rest = append(rest, item)
+	}
+	l.peeked = false
+	l.it = &sliceIterator{items: rest}
+	l.length = len(rest) + l.index0 + 1
+	l.hasLength = true
+	return l.length, nil
+}
+
+// RevIndex0 returns the number of iterations from the end of the loop, 0 indexed.
+func (l *LoopContext) RevIndex0() (int, error) {
+	length, err := l.Length()
+	return length - l.index0 - 1, err
+}
+
+// RevIndex returns the number of iterations from the end of the loop, 1 indexed.
+func (l *LoopContext) RevIndex() (int, error) {
+	length, err := l.Length()
+	return length - l.index0, err
+}
+
+// First reports whether this is the first iteration.
+func (l *LoopContext) First() bool {
+	return l.index0 == 0
+}
+
+// Last reports whether this is the last iteration. Only the next item is
+// consumed to find out.
+func (l *LoopContext) Last() (bool, error) {
+	_, ok, err := l.peekNext()
+	return !ok, err
+}
+
+// PrevItem returns the item of the previous iteration, undefined during the
+// first iteration.
+func (l *LoopContext) PrevItem() any {
+	if l.First() {
+		return l.newUndefined("there is no previous item")
+	}
+	return l.before
+}
+
+// NextItem returns the item of the next iteration, undefined during the last
+// iteration.
+func (l *LoopContext) NextItem() (any, error) {
+	item, ok, err := l.peekNext()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return l.newUndefined("there is no next item"), nil
+	}
+	return item, nil
+}
+
+// Cycle returns the argument at the current index modulo the number of arguments.
+func (l *LoopContext) Cycle(args ...any) (any, error) {
+	if len(args) == 0 {
+		return nil, typeError("no items for cycling given")
+	}
+	return args[l.index0%len(args)], nil
+}
+
+// Changed returns true if the values are different from the values of the
+// last call, or if it's called for the first time.
+func (l *LoopContext) Changed(values ...any) bool {
+	if l.hasLastChanged && Equal(l.lastChanged, values) {
+		return false
+	}
+	l.lastChanged, l.hasLastChanged = values, true
+	return true
+}
+
+// Call renders the loop body for the items of the argument, one level
+// deeper. It's only possible in loops marked as recursive.
+func (l *LoopContext) Call(args []any, kwargs map[string]any) (any, error) {
+	if l.Recurse == nil {
+		return nil, typeError("The loop must be marked as 'recursive' to call it.")
+	}
+	if len(args) != 1 || len(kwargs) > 0 {
+		return nil, typeError("loop() takes exactly one argument (%d given)", len(args)+len(kwargs))
+	}
+	return l.Recurse(args[0])
+}
+
+// GetAttr returns the loop attributes by their template names, e.g. `loop.index0`.
+func (l *LoopContext) GetAttr(name string) (any, error) {
+	switch name {
+	case "index0":
+		return l.Index0(), nil
+	case "index":
+		return l.Index(), nil
+	case "depth0":
+		return l.Depth0(), nil
+	case "depth":
+		return l.Depth(), nil
+	case "revindex0":
+		return l.RevIndex0()
+	case "revindex":
+		return l.RevIndex()
+	case "first":
+		return l.First(), nil
+	case "last":
+		return l.Last()
+	case "length":
+		return l.Length()
+	case "previtem":
+		return l.PrevItem(), nil
+	case "nextitem":
+		return l.NextItem()
+	case "cycle":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if len(kwargs) > 0 {
+				return nil, typeError("cycle() takes no keyword arguments")
+			}
+			return l.Cycle(args...)
+		}, nil
+	case "changed":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if len(kwargs) > 0 {
+				return nil, typeError("changed() takes no keyword arguments")
+			}
+			return l.Changed(args...), nil
+		}, nil
+	}
+	if l.undefined == nil {
+		return NewUndefined(nil, l, name, nil), nil
+	}
+	return l.undefined(nil, l, name), nil
+}
+
+func (l *LoopContext) String() string {
+	length, _ := l.Length()
+	return fmt.Sprintf("<LoopContext %d/%d>", l.Index(), length)
+}
@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gojinja/gojinja/src/utils"
+)
+
+// Macro is a macro defined with `{% macro %}`, or the `caller` of a
+// `{% call %}` block. Calling it renders its body with the arguments.
+type Macro struct {
+	Name      string
+	Arguments []string
+	// CatchKwargs and CatchVarargs are true if the body uses the special
+	// `kwargs` and `varargs` variables, extra arguments are collected then.
+	CatchKwargs  bool
+	CatchVarargs bool
+	// Caller is true if the body uses the special `caller` variable.
+	Caller bool
+	// Lineno is the line the macro is defined on.
+	Lineno int
+	// Invoke renders the body. The arguments have the order of `Arguments`,
+	// arguments that were not passed are `utils.Missing`. special holds the
+	// used special variables: `caller` (possibly `utils.Missing`), `kwargs`
+	// and `varargs`.
+	Invoke func(arguments []any, special map[string]any) (any, error)
+}
+
+func (m *Macro) explicitCaller() bool {
+	for _, name := range m.Arguments {
+		if name == "caller" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Macro) errorf(format string, args ...any) error {
+	return typeError("macro '%s' (line %d) %s", m.Name, m.Lineno, fmt.Sprintf(format, args...))
+}
+
+// Call calls the macro like python's jinja does: positional arguments are
+// consumed first, the remaining arguments are taken from the keyword
+// arguments.
+func (m *Macro) Call(args []any, kwargs map[string]any) (any, error) {
+	rest := make(map[string]any, len(kwargs))
+	for k, v := range kwargs {
+		rest[k] = v
+	}
+
+	count := len(m.Arguments)
+	n := len(args)
+	if n > count {
+		n = count
+	}
+	arguments := append(make([]any, 0, count), args[:n]...)
+	foundCaller := false
+	if n != count {
+		for _, name := range m.Arguments[n:] {
+			value, ok := rest[name]
+			if ok {
+				delete(rest, name)
+			} else {
+				value = utils.GetMissing()
+			}
+			if name == "caller" {
+				foundCaller = true
+			}
+			arguments = append(arguments, value)
+		}
+	} else {
+		foundCaller = m.explicitCaller()
+	}
+
+	special := make(map[string]any)
+	if m.Caller && !foundCaller {
+		caller, ok := rest["caller"]
+		delete(rest, "caller")
+		if !ok || caller == nil {
+			caller = utils.GetMissing()
+		}
+		special["caller"] = caller
+	}
+
+	if m.CatchKwargs {
+		special["kwargs"] = rest
+	} else if len(rest) > 0 {
+		if _, ok := rest["caller"]; ok {
+			return nil, m.errorf("was invoked with two values for the special caller argument. This is most likely a bug.")
+		}
+		names := make([]string, 0, len(rest))
+		for k := range rest {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, m.errorf("takes no keyword argument '%s'", names[0])
+	}
+
+	if m.CatchVarargs {
+		varargs := []any{}
+		if len(args) > count {
+			varargs = append(varargs, args[count:]...)
+		}
+		special["varargs"] = varargs
+	} else if len(args) > count {
+		return nil, m.errorf("takes not more than %d argument(s)", count)
+	}
+
+	return m.Invoke(arguments, special)
+}
+
+// GetAttr returns the attributes of the macro by their template names.
+func (m *Macro) GetAttr(name string) (any, bool) {
+	switch name {
+	case "name":
+		return m.Name, true
+	case "arguments":
+		arguments := make([]any, len(m.Arguments))
+		for i, arg := range m.Arguments {
+			arguments[i] = arg
+		}
+		return arguments, true
+	case "catch_kwargs":
+		return m.CatchKwargs, true
+	case "catch_varargs":
+		return m.CatchVarargs, true
+	case "caller":
+		return m.Caller, true
+	}
+	return nil, false
+}
+
+func (m *Macro) String() string {
+	if m.Name == "" {
+		return "<Macro anonymous>"
+	}
+	return fmt.Sprintf("<Macro '%s'>", m.Name)
+}
@@ -0,0 +1,47 @@
+package runtime
+
+import "strings"
+
+// Escaped is implemented by values that know their own HTML representation.
+// Such values are never escaped again.
+type Escaped interface {
+	HTML() (string, error)
+}
+
+// Markup is a string that is ready to be safely inserted into an HTML or XML
+// document, either because it was escaped or because it was marked safe.
+type Markup string
+
+func (m Markup) HTML() (string, error) {
+	return string(m), nil
+}
+
+var _ Escaped = Markup("")
+
+var htmlReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`'`, "&#39;",
+	`"`, "&#34;",
+)
+
+// EscapeString replaces the characters `&`, `<`, `>`, `'`, and `"` in the
+// string with HTML-safe sequences.
+func EscapeString(s string) Markup {
+	return Markup(htmlReplacer.Replace(s))
+}
+
+// Escape converts the value to a string and escapes it. Values implementing
+// `Escaped` are not escaped but their HTML representation is used instead.
+func Escape(v any) (Markup, error) {
+	if e, ok := v.(Escaped); ok {
+		s, err := e.HTML()
+		return Markup(s), err
+	}
+	s, err := ToString(v)
+	if err != nil {
+		return "", err
+	}
+	return EscapeString(s), nil
+}
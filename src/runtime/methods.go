@@ -0,0 +1,352 @@
+package runtime
+
+import (
+	"reflect"
+)
+
+// method is the signature of the python methods of lists and dicts.
+type method = func(args []any, kwargs map[string]any) (any, error)
+
+// pyMethod returns the python method of lists and dicts with the name. The
+// methods modifying lists, like `append`, are only available for pointers to
+// slices, like the lists of list literals, as other slices can't be modified
+// in place.
+func pyMethod(obj any, name string) (any, bool) {
+	rv := reflect.ValueOf(obj)
+	isPtr := rv.Kind() == reflect.Pointer
+	rv = indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if m := readListMethod(rv, name); m != nil {
+			return m, true
+		}
+		if isPtr && rv.Kind() == reflect.Slice && rv.CanSet() {
+			if m := listMethod(rv, name); m != nil {
+				return m, true
+			}
+		}
+	case reflect.Map:
+		if m := dictMethod(rv, name); m != nil {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+func checkArgs(name string, args []any, kwargs map[string]any, min, max int) error {
+	if len(kwargs) > 0 {
+		return typeError("%s() takes no keyword arguments", name)
+	}
+	if len(args) < min || len(args) > max {
+		switch {
+		case max == 0:
+			return typeError("%s() takes no arguments (%d given)", name, len(args))
+		case min == 1 && max == 1:
+			return typeError("%s() takes exactly one argument (%d given)", name, len(args))
+		case min == max:
+			return typeError("%s() takes exactly %d arguments (%d given)", name, min, len(args))
+		}
+		return typeError("%s() takes from %d to %d arguments (%d given)", name, min, max, len(args))
+	}
+	return nil
+}
+
+// normalizeIndex converts a python index to an index into a list of the length.
+func normalizeIndex(index any, length int) (int, error) {
+	i, ok := ToInt(index)
+	if !ok {
+		return 0, typeError("'%s' object cannot be interpreted as an integer", TypeName(index))
+	}
+	if i < 0 {
+		i += int64(length)
+	}
+	return int(i), nil
+}
+
+func readListMethod(rv reflect.Value, name string) method {
+	switch name {
+	case "index":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("index", args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+			for i := 0; i < rv.Len(); i++ {
+				if Equal(rv.Index(i).Interface(), args[0]) {
+					return i, nil
+				}
+			}
+			return nil, typeError("%s is not in list", Repr(args[0]))
+		}
+	case "count":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("count", args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+			n := 0
+			for i := 0; i < rv.Len(); i++ {
+				if Equal(rv.Index(i).Interface(), args[0]) {
+					n++
+				}
+			}
+			return n, nil
+		}
+	}
+	return nil
+}
+
+func listMethod(rv reflect.Value, name string) method {
+	elemType := rv.Type().Elem()
+	switch name {
+	case "append":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("append", args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+			v, err := convertArg(args[0], elemType)
+			if err != nil {
+				return nil, err
+			}
+			rv.Set(reflect.Append(rv, v))
+			return nil, nil
+		}
+	case "extend":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("extend", args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+			items, err := Iterate(args[0])
+			if err != nil {
+				return nil, err
+			}
+			values := make([]reflect.Value, len(items))
+			for i, item := range items {
+				if values[i], err = convertArg(item, elemType); err != nil {
+					return nil, err
+				}
+			}
+			rv.Set(reflect.Append(rv, values...))
+			return nil, nil
+		}
+	case "insert":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("insert", args, kwargs, 2, 2); err != nil {
+				return nil, err
+			}
+			i, err := normalizeIndex(args[0], rv.Len())
+			if err != nil {
+				return nil, err
+			}
+			if i < 0 {
+				i = 0
+			} else if i > rv.Len() {
+				i = rv.Len()
+			}
+			v, err := convertArg(args[1], elemType)
+			if err != nil {
+				return nil, err
+			}
+			rv.Set(reflect.Append(rv, reflect.Zero(elemType)))
+			reflect.Copy(rv.Slice(i+1, rv.Len()), rv.Slice(i, rv.Len()-1))
+			rv.Index(i).Set(v)
+			return nil, nil
+		}
+	case "pop":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("pop", args, kwargs, 0, 1); err != nil {
+				return nil, err
+			}
+			if rv.Len() == 0 {
+				return nil, typeError("pop from empty list")
+			}
+			i := rv.Len() - 1
+			if len(args) == 1 {
+				var err error
+				if i, err = normalizeIndex(args[0], rv.Len()); err != nil {
+					return nil, err
+				}
+				if i < 0 || i >= rv.Len() {
+					return nil, typeError("pop index out of range")
+				}
+			}
+			item := rv.Index(i).Interface()
+			removeIndex(rv, i)
+			return item, nil
+		}
+	case "remove":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("remove", args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+			for i := 0; i < rv.Len(); i++ {
+				if Equal(rv.Index(i).Interface(), args[0]) {
+					removeIndex(rv, i)
+					return nil, nil
+				}
+			}
+			return nil, typeError("list.remove(x): x not in list")
+		}
+	case "clear":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("clear", args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+			rv.Set(rv.Slice(0, 0))
+			return nil, nil
+		}
+	case "reverse":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("reverse", args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+			swap := reflect.Swapper(rv.Interface())
+			for i, j := 0, rv.Len()-1; i < j; i, j = i+1, j-1 {
+				swap(i, j)
+			}
+			return nil, nil
+		}
+	}
+	return nil
+}
+
+func removeIndex(rv reflect.Value, i int) {
+	reflect.Copy(rv.Slice(i, rv.Len()), rv.Slice(i+1, rv.Len()))
+	rv.Index(rv.Len() - 1).Set(reflect.Zero(rv.Type().Elem()))
+	rv.Set(rv.Slice(0, rv.Len()-1))
+}
+
+func dictMethod(rv reflect.Value, name string) method {
+	keyType, elemType := rv.Type().Key(), rv.Type().Elem()
+	set := func(key, value any) error {
+		k, err := convertArg(key, keyType)
+		if err != nil {
+			return err
+		}
+		v, err := convertArg(value, elemType)
+		if err != nil {
+			return err
+		}
+		if rv.IsNil() {
+			return typeError("assignment to entry in nil map")
+		}
+		rv.SetMapIndex(k, v)
+		return nil
+	}
+
+	switch name {
+	case "get":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("get", args, kwargs, 1, 2); err != nil {
+				return nil, err
+			}
+			if v, ok := mapIndex(rv, args[0]); ok {
+				return v, nil
+			}
+			if len(args) == 2 {
+				return args[1], nil
+			}
+			return nil, nil
+		}
+	case "keys", "values", "items":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs(name, args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+			keys := sortedMapKeys(rv)
+			res := make([]any, len(keys))
+			for i, k := range keys {
+				switch name {
+				case "keys":
+					res[i] = k.Interface()
+				case "values":
+					res[i] = rv.MapIndex(k).Interface()
+				default:
+					res[i] = []any{k.Interface(), rv.MapIndex(k).Interface()}
+				}
+			}
+			return res, nil
+		}
+	case "update":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if len(args) > 1 {
+				return nil, typeError("update expected at most 1 argument, got %d", len(args))
+			}
+			if len(args) == 1 {
+				other := indirect(reflect.ValueOf(args[0]))
+				if other.Kind() == reflect.Map {
+					for _, k := range sortedMapKeys(other) {
+						if err := set(k.Interface(), other.MapIndex(k).Interface()); err != nil {
+							return nil, err
+						}
+					}
+				} else {
+					items, err := Iterate(args[0])
+					if err != nil {
+						return nil, err
+					}
+					for _, item := range items {
+						pair, err := Iterate(item)
+						if err != nil || len(pair) != 2 {
+							return nil, typeError("dictionary update sequence element has wrong length")
+						}
+						if err := set(pair[0], pair[1]); err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+			for k, v := range kwargs {
+				if err := set(k, v); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}
+	case "pop":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("pop", args, kwargs, 1, 2); err != nil {
+				return nil, err
+			}
+			v, ok := mapIndex(rv, args[0])
+			if !ok {
+				if len(args) == 2 {
+					return args[1], nil
+				}
+				return nil, typeError("key %s not found", Repr(args[0]))
+			}
+			k, err := convertArg(args[0], keyType)
+			if err != nil {
+				return nil, err
+			}
+			rv.SetMapIndex(k, reflect.Value{})
+			return v, nil
+		}
+	case "setdefault":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("setdefault", args, kwargs, 1, 2); err != nil {
+				return nil, err
+			}
+			if v, ok := mapIndex(rv, args[0]); ok {
+				return v, nil
+			}
+			var value any
+			if len(args) == 2 {
+				value = args[1]
+			}
+			if err := set(args[0], value); err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+	case "clear":
+		return func(args []any, kwargs map[string]any) (any, error) {
+			if err := checkArgs("clear", args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+			for _, k := range rv.MapKeys() {
+				rv.SetMapIndex(k, reflect.Value{})
+			}
+			return nil, nil
+		}
+	}
+	return nil
+}
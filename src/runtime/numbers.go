@@ -0,0 +1,331 @@
+package runtime
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/gojinja/gojinja/src/errors"
+)
+
+// The arithmetic of templates follows python: integers don't overflow but
+// become big integers, `/` always divides to a float, `//` and `%` round
+// towards negative infinity and booleans count as the integers 0 and 1.
+// Integers are int64 if they fit, *big.Int otherwise.
+
+type numberKind int
+
+const (
+	notNumber numberKind = iota
+	intNumber
+	bigNumber
+	floatNumber
+)
+
+// number is a numeric value converted to one of the representations used by
+// the arithmetic.
+type number struct {
+	kind numberKind
+	i    int64
+	b    *big.Int
+	f    float64
+}
+
+// toNumber converts integers of any size, floats and booleans to a number.
+func toNumber(v any) number {
+	switch val := v.(type) {
+	case nil:
+		return number{}
+	case bool:
+		if val {
+			return number{kind: intNumber, i: 1}
+		}
+		return number{kind: intNumber}
+	case *big.Int:
+		if val == nil {
+			return number{}
+		}
+		return number{kind: bigNumber, b: val}
+	case big.Int:
+		return number{kind: bigNumber, b: &val}
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: intNumber, i: rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u > math.MaxInt64 {
+			return number{kind: bigNumber, b: new(big.Int).SetUint64(u)}
+		}
+		return number{kind: intNumber, i: int64(rv.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return number{kind: floatNumber, f: rv.Float()}
+	}
+	return number{}
+}
+
+func (n number) isNumber() bool {
+	return n.kind != notNumber
+}
+
+func (n number) bigInt() *big.Int {
+	if n.kind == bigNumber {
+		return n.b
+	}
+	return big.NewInt(n.i)
+}
+
+func (n number) float() (float64, error) {
+	switch n.kind {
+	case intNumber:
+		return float64(n.i), nil
+	case bigNumber:
+		f, _ := new(big.Float).SetInt(n.b).Float64()
+		if math.IsInf(f, 0) {
+			return 0, typeError("int too large to convert to float")
+		}
+		return f, nil
+	}
+	return n.f, nil
+}
+
+// bigFloat returns the exact value of the number, it must not be NaN.
+func (n number) bigFloat() *big.Float {
+	switch n.kind {
+	case intNumber:
+		return new(big.Float).SetInt64(n.i)
+	case bigNumber:
+		return new(big.Float).SetInt(n.b)
+	}
+	return new(big.Float).SetFloat64(n.f)
+}
+
+func (n number) isNaN() bool {
+	return n.kind == floatNumber && math.IsNaN(n.f)
+}
+
+// ToBigInt converts integers of any size to a big integer.
+func ToBigInt(v any) (*big.Int, bool) {
+	n := toNumber(v)
+	if _, isBool := v.(bool); isBool || n.kind == notNumber || n.kind == floatNumber {
+		return nil, false
+	}
+	return n.bigInt(), true
+}
+
+// normalizeInt returns the big integer as int64 if it fits.
+func normalizeInt(b *big.Int) any {
+	if b.IsInt64() {
+		return b.Int64()
+	}
+	return b
+}
+
+// compareNumbers compares the numbers exactly, it reports false if they are
+// unordered because one of them is NaN.
+func compareNumbers(a, b number) (int, bool) {
+	if a.isNaN() || b.isNaN() {
+		return 0, false
+	}
+	if a.kind == intNumber && b.kind == intNumber {
+		switch {
+		case a.i < b.i:
+			return -1, true
+		case a.i > b.i:
+			return 1, true
+		}
+		return 0, true
+	}
+	if a.kind == floatNumber && b.kind == floatNumber {
+		switch {
+		case a.f < b.f:
+			return -1, true
+		case a.f > b.f:
+			return 1, true
+		}
+		return 0, true
+	}
+	return a.bigFloat().Cmp(b.bigFloat()), true
+}
+
+// numberOp applies the arithmetic operator to the numbers, it reports false
+// if one of the operands isn't a number.
+func numberOp(op string, a, b any) (any, bool, error) {
+	x, y := toNumber(a), toNumber(b)
+	if !x.isNumber() || !y.isNumber() {
+		return nil, false, nil
+	}
+	var res any
+	var err error
+	switch {
+	case x.kind == floatNumber || y.kind == floatNumber || op == "div":
+		var fx, fy float64
+		if fx, err = x.float(); err != nil {
+			return nil, true, err
+		}
+		if fy, err = y.float(); err != nil {
+			return nil, true, err
+		}
+		res, err = floatOp(op, fx, fy)
+	case x.kind == intNumber && y.kind == intNumber:
+		res, err = intOp(op, x.i, y.i)
+	default:
+		res, err = bigOp(op, x.bigInt(), y.bigInt())
+	}
+	return res, true, err
+}
+
+func intOp(op string, x, y int64) (any, error) {
+	switch op {
+	case "add":
+		if s := x + y; (s > x) == (y > 0) {
+			return s, nil
+		}
+	case "sub":
+		if d := x - y; (d < x) == (y > 0) {
+			return d, nil
+		}
+	case "mul":
+		if x == 0 || y == 0 {
+			return int64(0), nil
+		}
+		if p := x * y; p/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+			return p, nil
+		}
+	case "floordiv", "mod":
+		if y == 0 {
+			return nil, errZeroDivision
+		}
+		if x == math.MinInt64 && y == -1 {
+			break
+		}
+		q, r := x/y, x%y
+		if r != 0 && (r < 0) != (y < 0) {
+			q--
+			r += y
+		}
+		if op == "mod" {
+			return r, nil
+		}
+		return q, nil
+	case "pow":
+		if y < 0 {
+			return floatOp(op, float64(x), float64(y))
+		}
+	}
+	return bigOp(op, big.NewInt(x), big.NewInt(y))
+}
+
+func bigOp(op string, x, y *big.Int) (any, error) {
+	z := new(big.Int)
+	switch op {
+	case "add":
+		z.Add(x, y)
+	case "sub":
+		z.Sub(x, y)
+	case "mul":
+		z.Mul(x, y)
+	case "floordiv", "mod":
+		if y.Sign() == 0 {
+			return nil, errZeroDivision
+		}
+		r := new(big.Int)
+		z.QuoRem(x, y, r)
+		if r.Sign() != 0 && (r.Sign() < 0) != (y.Sign() < 0) {
+			z.Sub(z, big.NewInt(1))
+			r.Add(r, y)
+		}
+		if op == "mod" {
+			z = r
+		}
+	case "pow":
+		if y.Sign() < 0 {
+			fx, err := number{kind: bigNumber, b: x}.float()
+			if err != nil {
+				return nil, err
+			}
+			fy, err := number{kind: bigNumber, b: y}.float()
+			if err != nil {
+				return nil, err
+			}
+			return floatOp(op, fx, fy)
+		}
+		z.Exp(x, y, nil)
+	default:
+		return nil, typeError("unknown operator %q", op)
+	}
+	return normalizeInt(z), nil
+}
+
+func floatOp(op string, x, y float64) (any, error) {
+	switch op {
+	case "add":
+		return x + y, nil
+	case "sub":
+		return x - y, nil
+	case "mul":
+		return x * y, nil
+	case "div":
+		if y == 0 {
+			return nil, errZeroDivision
+		}
+		return x / y, nil
+	case "floordiv", "mod":
+		if y == 0 {
+			return nil, errZeroDivision
+		}
+		div, mod := floatDivmod(x, y)
+		if op == "mod" {
+			return mod, nil
+		}
+		return div, nil
+	case "pow":
+		if x == 0 && y < 0 {
+			return nil, errors.NewZeroDivisionError("0.0 cannot be raised to a negative power")
+		}
+		if x < 0 && y != math.Trunc(y) {
+			return nil, typeError("unsupported operand: negative number cannot be raised to a fractional power")
+		}
+		return math.Pow(x, y), nil
+	}
+	return nil, typeError("unknown operator %q", op)
+}
+
+// floatDivmod returns the floored quotient and the remainder of the floats
+// like python's `divmod` does, the remainder has the sign of the divisor.
+func floatDivmod(x, y float64) (float64, float64) {
+	mod := math.Mod(x, y)
+	div := (x - mod) / y
+	if mod != 0 {
+		if (y < 0) != (mod < 0) {
+			mod += y
+			div -= 1
+		}
+	} else {
+		mod = math.Copysign(0, y)
+	}
+	if div != 0 {
+		floor := math.Floor(div)
+		if div-floor > 0.5 {
+			floor += 1
+		}
+		div = floor
+	} else {
+		div = math.Copysign(0, x/y)
+	}
+	return div, mod
+}
+
+// negate returns the negated number.
+func negate(n number) any {
+	switch n.kind {
+	case intNumber:
+		if n.i == math.MinInt64 {
+			return new(big.Int).Neg(big.NewInt(n.i))
+		}
+		return -n.i
+	case bigNumber:
+		return normalizeInt(new(big.Int).Neg(n.b))
+	}
+	return -n.f
+}
@@ -0,0 +1,310 @@
+package runtime
+
+// The operator protocol lets Go types define how they behave in templates,
+// like python's special methods do. The binary operators first try the
+// method of the left operand and then the reflected method (`RAdd`, ...) of
+// the right operand, before falling back to the built-in handling of numbers,
+// strings, slices and maps. Methods return `NotImplemented` if they don't
+// support the other operand.
+
+type notImplemented struct{}
+
+func (notImplemented) String() string {
+	return "NotImplemented"
+}
+
+// NotImplemented is returned by the methods of the operator protocol if the
+// operation isn't supported for the other operand.
+var NotImplemented any = notImplemented{}
+
+// AddOp is implemented by values supporting `a + b` as the left operand.
+type AddOp interface {
+	Add(other any) (any, error)
+}
+
+// RAddOp is implemented by values supporting `a + b` as the right operand.
+type RAddOp interface {
+	RAdd(other any) (any, error)
+}
+
+// SubOp is implemented by values supporting `a - b` as the left operand.
+type SubOp interface {
+	Sub(other any) (any, error)
+}
+
+// RSubOp is implemented by values supporting `a - b` as the right operand.
+type RSubOp interface {
+	RSub(other any) (any, error)
+}
+
+// MulOp is implemented by values supporting `a * b` as the left operand.
+type MulOp interface {
+	Mul(other any) (any, error)
+}
+
+// RMulOp is implemented by values supporting `a * b` as the right operand.
+type RMulOp interface {
+	RMul(other any) (any, error)
+}
+
+// DivOp is implemented by values supporting `a / b` as the left operand.
+type DivOp interface {
+	Div(other any) (any, error)
+}
+
+// RDivOp is implemented by values supporting `a / b` as the right operand.
+type RDivOp interface {
+	RDiv(other any) (any, error)
+}
+
+// FloorDivOp is implemented by values supporting `a // b` as the left operand.
+type FloorDivOp interface {
+	FloorDiv(other any) (any, error)
+}
+
+// RFloorDivOp is implemented by values supporting `a // b` as the right operand.
+type RFloorDivOp interface {
+	RFloorDiv(other any) (any, error)
+}
+
+// ModOp is implemented by values supporting `a % b` as the left operand.
+type ModOp interface {
+	Mod(other any) (any, error)
+}
+
+// RModOp is implemented by values supporting `a % b` as the right operand.
+type RModOp interface {
+	RMod(other any) (any, error)
+}
+
+// PowOp is implemented by values supporting `a ** b` as the left operand.
+type PowOp interface {
+	Pow(other any) (any, error)
+}
+
+// RPowOp is implemented by values supporting `a ** b` as the right operand.
+type RPowOp interface {
+	RPow(other any) (any, error)
+}
+
+// NegOp is implemented by values supporting `-a`.
+type NegOp interface {
+	Neg() (any, error)
+}
+
+// PosOp is implemented by values supporting `+a`.
+type PosOp interface {
+	Pos() (any, error)
+}
+
+// EqOp is implemented by values defining `a == b`, the truth value of the
+// result is used.
+type EqOp interface {
+	Eq(other any) (any, error)
+}
+
+// NeOp is implemented by values defining `a != b`, values implementing only
+// `EqOp` are unequal if they aren't equal.
+type NeOp interface {
+	Ne(other any) (any, error)
+}
+
+// LtOp is implemented by values defining `a < b`.
+type LtOp interface {
+	Lt(other any) (any, error)
+}
+
+// LeOp is implemented by values defining `a <= b`.
+type LeOp interface {
+	Le(other any) (any, error)
+}
+
+// GtOp is implemented by values defining `a > b`.
+type GtOp interface {
+	Gt(other any) (any, error)
+}
+
+// GeOp is implemented by values defining `a >= b`.
+type GeOp interface {
+	Ge(other any) (any, error)
+}
+
+// ContainsOp is implemented by containers defining `item in container`.
+type ContainsOp interface {
+	Contains(item any) (bool, error)
+}
+
+// LenOp is implemented by values with a length.
+type LenOp interface {
+	Len() (int, error)
+}
+
+// IterOp is implemented by values that can be iterated over.
+type IterOp interface {
+	Iter() ([]any, error)
+}
+
+// GetItemOp is implemented by values supporting `a[key]`.
+type GetItemOp interface {
+	GetItem(key any) (any, error)
+}
+
+// CallOp is implemented by callable values, they receive the positional and
+// the keyword arguments.
+type CallOp interface {
+	Call(args []any, kwargs map[string]any) (any, error)
+}
+
+// BoolOp is implemented by values defining their truth value.
+type BoolOp interface {
+	Bool() (bool, error)
+}
+
+// leftMethod returns the method of the left operand implementing the operator.
+func leftMethod(op string, v any) func(any) (any, error) {
+	switch op {
+	case "add":
+		if o, ok := v.(AddOp); ok {
+			return o.Add
+		}
+	case "sub":
+		if o, ok := v.(SubOp); ok {
+			return o.Sub
+		}
+	case "mul":
+		if o, ok := v.(MulOp); ok {
+			return o.Mul
+		}
+	case "div":
+		if o, ok := v.(DivOp); ok {
+			return o.Div
+		}
+	case "floordiv":
+		if o, ok := v.(FloorDivOp); ok {
+			return o.FloorDiv
+		}
+	case "mod":
+		if o, ok := v.(ModOp); ok {
+			return o.Mod
+		}
+	case "pow":
+		if o, ok := v.(PowOp); ok {
+			return o.Pow
+		}
+	case "eq":
+		if o, ok := v.(EqOp); ok {
+			return o.Eq
+		}
+	case "ne":
+		if o, ok := v.(NeOp); ok {
+			return o.Ne
+		}
+	case "lt":
+		if o, ok := v.(LtOp); ok {
+			return o.Lt
+		}
+	case "lteq":
+		if o, ok := v.(LeOp); ok {
+			return o.Le
+		}
+	case "gt":
+		if o, ok := v.(GtOp); ok {
+			return o.Gt
+		}
+	case "gteq":
+		if o, ok := v.(GeOp); ok {
+			return o.Ge
+		}
+	}
+	return nil
+}
+
+// reflectedMethod returns the reflected method of the right operand
+// implementing the operator. Comparisons are reflected by swapping the
+// operands, e.g. `a < b` is `b > a`.
+func reflectedMethod(op string, v any) func(any) (any, error) {
+	switch op {
+	case "add":
+		if o, ok := v.(RAddOp); ok {
+			return o.RAdd
+		}
+	case "sub":
+		if o, ok := v.(RSubOp); ok {
+			return o.RSub
+		}
+	case "mul":
+		if o, ok := v.(RMulOp); ok {
+			return o.RMul
+		}
+	case "div":
+		if o, ok := v.(RDivOp); ok {
+			return o.RDiv
+		}
+	case "floordiv":
+		if o, ok := v.(RFloorDivOp); ok {
+			return o.RFloorDiv
+		}
+	case "mod":
+		if o, ok := v.(RModOp); ok {
+			return o.RMod
+		}
+	case "pow":
+		if o, ok := v.(RPowOp); ok {
+			return o.RPow
+		}
+	case "eq":
+		if o, ok := v.(EqOp); ok {
+			return o.Eq
+		}
+	case "ne":
+		if o, ok := v.(NeOp); ok {
+			return o.Ne
+		}
+	case "lt":
+		if o, ok := v.(GtOp); ok {
+			return o.Gt
+		}
+	case "lteq":
+		if o, ok := v.(GeOp); ok {
+			return o.Ge
+		}
+	case "gt":
+		if o, ok := v.(LtOp); ok {
+			return o.Lt
+		}
+	case "gteq":
+		if o, ok := v.(LeOp); ok {
+			return o.Le
+		}
+	}
+	return nil
+}
+
+// dispatchBinary applies the operator protocol to the operands. It reports
+// false if neither operand implements the operator for the other one.
+func dispatchBinary(op string, a, b any) (any, bool, error) {
+	if method := leftMethod(op, a); method != nil {
+		res, err := method(b)
+		if err != nil || res != NotImplemented {
+			return res, true, err
+		}
+	}
+	if reflected := reflectedMethod(op, b); reflected != nil {
+		res, err := reflected(a)
+		if err != nil || res != NotImplemented {
+			return res, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// dispatchCompare applies the operator protocol to the comparison operands
+// and returns the truth value of the result.
+func dispatchCompare(op string, a, b any) (bool, bool, error) {
+	res, ok, err := dispatchBinary(op, a, b)
+	if !ok || err != nil {
+		return false, ok, err
+	}
+	truth, err := Bool(res)
+	return truth, true, err
+}
@@ -0,0 +1,957 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gojinja/gojinja/src/errors"
+)
+
+// Slice is the value of a slice expression like `[1:-1]` used as an item key.
+type Slice struct {
+	Start, Stop, Step *int
+}
+
+func typeError(format string, args ...any) error {
+	return errors.NewTypeError(fmt.Sprintf(format, args...))
+}
+
+// TypeName returns the name of the type of the value like it is shown in error messages.
+func TypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "NoneType"
+	case bool:
+		return "bool"
+	case string:
+		return "str"
+	}
+	if _, ok := ToBigInt(v); ok {
+		return "int"
+	}
+	if _, ok := toFloat(v); ok {
+		return "float"
+	}
+	t := reflect.TypeOf(DerefList(v))
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map:
+		return "dict"
+	}
+	if t.Kind() == reflect.Pointer && t.Elem().Name() != "" {
+		t = t.Elem()
+	}
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return t.String()
+}
+
+// ToInt converts any integer value to int64.
+func ToInt(v any) (int64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint()), true
+	}
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func toStr(v any) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.String {
+		return rv.String(), true
+	}
+	return "", false
+}
+
+// indirect dereferences pointers and interfaces until it reaches a non-pointer value.
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// DerefList returns the slice a non-nil pointer to a slice points to and any
+// other value unchanged. Pointers to slices are lists that can be modified in
+// place, list literals evaluate to them.
+func DerefList(v any) any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Slice {
+		return rv.Elem().Interface()
+	}
+	return v
+}
+
+// ToString converts the value to a string like python's `str` does.
+func ToString(v any) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "None", nil
+	case string:
+		return val, nil
+	case Markup:
+		return string(val), nil
+	case bool:
+		if val {
+			return "True", nil
+		}
+		return "False", nil
+	case interface{ String_() (string, error) }:
+		return val.String_()
+	case fmt.Stringer:
+		return val.String(), nil
+	case error:
+		return val.Error(), nil
+	}
+	if i, ok := ToInt(v); ok {
+		return strconv.FormatInt(i, 10), nil
+	}
+	if f, ok := toFloat(v); ok {
+		return formatFloat(f), nil
+	}
+	if s, ok := toStr(v); ok {
+		return s, nil
+	}
+	switch reflect.TypeOf(DerefList(v)).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return Repr(v), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// formatFloat formats the float like python's `repr` does.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	exp := 0
+	if f != 0 {
+		exp = int(math.Floor(math.Log10(math.Abs(f))))
+	}
+	if exp < -4 || exp >= 16 {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// Repr returns the python-like representation of the value.
+func Repr(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "None"
+	case string:
+		return reprString(val)
+	case Markup:
+		return fmt.Sprintf("Markup(%s)", reprString(string(val)))
+	case interface{ Repr() string }:
+		return val.Repr()
+	}
+	if s, ok := toStr(v); ok {
+		return reprString(s)
+	}
+	rv := reflect.ValueOf(DerefList(v))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return "[]"
+		}
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = Repr(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := sortedMapKeys(rv)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = Repr(k.Interface()) + ": " + Repr(rv.MapIndex(k).Interface())
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	s, err := ToString(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return s
+}
+
+func reprString(s string) string {
+	quote := "'"
+	if strings.Contains(s, "'") && !strings.Contains(s, `"`) {
+		quote = `"`
+	}
+	var b strings.Builder
+	b.WriteString(quote)
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case string(r) == quote:
+			b.WriteString(`\` + quote)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < ' ' || r == 0x7f:
+			b.WriteString(fmt.Sprintf(`\x%02x`, r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(quote)
+	return b.String()
+}
+
+// sortedMapKeys returns the keys of the map in a stable order.
+func sortedMapKeys(rv reflect.Value) []reflect.Value {
+	keys := rv.MapKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := keys[i].Interface(), keys[j].Interface()
+		if less, err := Compare("lt", a, b); err == nil {
+			return less
+		}
+		return fmt.Sprint(a) < fmt.Sprint(b)
+	})
+	return keys
+}
+
+// Bool returns the truth value of the value like python's `bool` does.
+func Bool(v any) (bool, error) {
+	switch val := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return val, nil
+	case BoolOp:
+		return val.Bool()
+	}
+	switch n := toNumber(v); n.kind {
+	case intNumber:
+		return n.i != 0, nil
+	case bigNumber:
+		return n.b.Sign() != 0, nil
+	case floatNumber:
+		return n.f != 0, nil
+	}
+	rv := reflect.ValueOf(DerefList(v))
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return rv.Len() != 0, nil
+	case reflect.Pointer, reflect.Interface, reflect.Func:
+		return !rv.IsNil(), nil
+	}
+	return true, nil
+}
+
+// Len returns the length of the value like python's `len` does.
+func Len(v any) (int, error) {
+	if l, ok := v.(LenOp); ok {
+		return l.Len()
+	}
+	if s, ok := toStr(v); ok {
+		return len([]rune(s)), nil
+	}
+	rv := indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return rv.Len(), nil
+	}
+	return 0, typeError("object of type '%s' has no len()", TypeName(v))
+}
+
+// Iterate returns the items the value iterates over. Maps are iterated over their
+// keys in sorted order and strings over their characters. Channels, iterator
+// functions and `Iterator` values are consumed until they are exhausted.
+func Iterate(v any) ([]any, error) {
+	if it, ok := v.(IterOp); ok {
+		return it.Iter()
+	}
+	if s, ok := toStr(v); ok {
+		res := make([]any, 0, len(s))
+		for _, r := range s {
+			res = append(res, string(r))
+		}
+		return res, nil
+	}
+	rv := indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := make([]any, rv.Len())
+		for i := range res {
+			res[i] = rv.Index(i).Interface()
+		}
+		return res, nil
+	case reflect.Map:
+		keys := sortedMapKeys(rv)
+		res := make([]any, len(keys))
+		for i, k := range keys {
+			res[i] = k.Interface()
+		}
+		return res, nil
+	case reflect.Chan:
+		var res []any
+		for {
+			item, ok := rv.Recv()
+			if !ok {
+				return res, nil
+			}
+			res = append(res, item.Interface())
+		}
+	case reflect.Func:
+		if isSeq(rv.Type()) && !rv.IsNil() {
+			return drain(&seqIterator{fn: rv})
+		}
+	}
+	if it, ok := v.(Iterator); ok {
+		return drain(it)
+	}
+	return nil, typeError("'%s' object is not iterable", TypeName(v))
+}
+
+// Equal compares two values like python's `==` does, errors of the operator
+// protocol count as unequal. See `Eq`.
+func Equal(a, b any) bool {
+	eq, _ := Eq(a, b)
+	return eq
+}
+
+// Eq compares two values like python's `==` does. Values implementing `EqOp`
+// are compared with it.
+func Eq(a, b any) (bool, error) {
+	if eq, ok, err := dispatchCompare("eq", a, b); ok {
+		return eq, err
+	}
+	return equal(a, b), nil
+}
+
+// Ne compares two values like python's `!=` does. Values implementing `NeOp`
+// are compared with it, otherwise the result of `Eq` is negated.
+func Ne(a, b any) (bool, error) {
+	if ne, ok, err := dispatchCompare("ne", a, b); ok {
+		return ne, err
+	}
+	eq, err := Eq(a, b)
+	return !eq, err
+}
+
+func equal(a, b any) bool {
+	if na := toNumber(a); na.isNumber() {
+		cmp, ordered := compareNumbers(na, toNumber(b))
+		return toNumber(b).isNumber() && ordered && cmp == 0
+	}
+	if sa, ok := toStr(a); ok {
+		sb, ok := toStr(b)
+		return ok && sa == sb
+	}
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	a, b = DerefList(a), DerefList(b)
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch ra.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rb.Kind() != reflect.Slice && rb.Kind() != reflect.Array {
+			return false
+		}
+		if ra.Len() != rb.Len() {
+			return false
+		}
+		for i := 0; i < ra.Len(); i++ {
+			if !Equal(ra.Index(i).Interface(), rb.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if rb.Kind() != reflect.Map || ra.Len() != rb.Len() {
+			return false
+		}
+		for _, k := range ra.MapKeys() {
+			other, ok := mapIndex(rb, k.Interface())
+			if !ok || !Equal(ra.MapIndex(k).Interface(), other) {
+				return false
+			}
+		}
+		return true
+	}
+	if ra.Type().Comparable() && rb.Type().Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare compares two values with one of the ordering operators `lt`, `lteq`,
+// `gt` or `gteq`. Values implementing `LtOp`, `LeOp`, `GtOp` or `GeOp` are
+// compared with them.
+func Compare(op string, a, b any) (bool, error) {
+	if res, ok, err := dispatchCompare(op, a, b); ok {
+		return res, err
+	}
+	cmp, ordered, err := compareValues(op, a, b)
+	if err != nil || !ordered {
+		return false, err
+	}
+	switch op {
+	case "lt":
+		return cmp < 0, nil
+	case "lteq":
+		return cmp <= 0, nil
+	case "gt":
+		return cmp > 0, nil
+	case "gteq":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparison operator %q", op)
+}
+
+// compareValues orders numbers, strings and lists lexicographically. It
+// reports false if the values are unordered, like NaN is to every number.
+func compareValues(op string, a, b any) (int, bool, error) {
+	if na := toNumber(a); na.isNumber() {
+		nb := toNumber(b)
+		if !nb.isNumber() {
+			return 0, false, unorderable(op, a, b)
+		}
+		cmp, ordered := compareNumbers(na, nb)
+		return cmp, ordered, nil
+	}
+	if sa, ok := toStr(a); ok {
+		sb, ok := toStr(b)
+		if !ok {
+			return 0, false, unorderable(op, a, b)
+		}
+		return strings.Compare(sa, sb), true, nil
+	}
+	if isList(a) && isList(b) {
+		ra, rb := reflect.ValueOf(DerefList(a)), reflect.ValueOf(DerefList(b))
+		for i := 0; i < ra.Len() && i < rb.Len(); i++ {
+			x, y := ra.Index(i).Interface(), rb.Index(i).Interface()
+			eq, err := Eq(x, y)
+			if err != nil {
+				return 0, false, err
+			}
+			if eq {
+				continue
+			}
+			if less, err := Compare("lt", x, y); err != nil || less {
+				return -1, true, err
+			}
+			if greater, err := Compare("gt", x, y); err != nil || greater {
+				return 1, true, err
+			}
+			return 0, false, nil
+		}
+		return ra.Len() - rb.Len(), true, nil
+	}
+	return 0, false, unorderable(op, a, b)
+}
+
+var operatorSymbols = map[string]string{
+	"lt": "<", "lteq": "<=", "gt": ">", "gteq": ">=",
+	"add": "+", "sub": "-", "mul": "*", "div": "/", "floordiv": "//", "mod": "%", "pow": "**",
+}
+
+func unorderable(op string, a, b any) error {
+	return typeError("'%s' not supported between instances of '%s' and '%s'", operatorSymbols[op], TypeName(a), TypeName(b))
+}
+
+// Contains reports whether the item is in the container like python's `in`
+// does. Containers implementing `ContainsOp` are asked with it.
+func Contains(container any, item any) (bool, error) {
+	if c, ok := container.(ContainsOp); ok {
+		return c.Contains(item)
+	}
+	if s, ok := toStr(container); ok {
+		sub, ok := toStr(item)
+		if !ok {
+			return false, typeError("'in <string>' requires string as left operand, not %s", TypeName(item))
+		}
+		return strings.Contains(s, sub), nil
+	}
+	rv := indirect(reflect.ValueOf(container))
+	if rv.Kind() == reflect.Map {
+		_, ok := mapIndex(rv, item)
+		return ok, nil
+	}
+	items, err := Iterate(container)
+	if err != nil {
+		return false, typeError("argument of type '%s' is not iterable", TypeName(container))
+	}
+	for _, el := range items {
+		if Equal(el, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mapIndex looks up the key in the map converting it to the key type of the map if necessary.
+func mapIndex(m reflect.Value, key any) (any, bool) {
+	keyType := m.Type().Key()
+	var k reflect.Value
+	if key == nil {
+		switch keyType.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			k = reflect.Zero(keyType)
+		default:
+			return nil, false
+		}
+	} else {
+		k = reflect.ValueOf(key)
+		if !k.Type().AssignableTo(keyType) {
+			if !k.Type().ConvertibleTo(keyType) || k.Kind() != keyType.Kind() && !sameNumberKind(k.Kind(), keyType.Kind()) && !intToFloat(k, keyType) {
+				return nil, false
+			}
+			k = k.Convert(keyType)
+		}
+		if !k.Type().Comparable() {
+			return nil, false
+		}
+	}
+	v := m.MapIndex(k)
+	if !v.IsValid() {
+		if keyType.Kind() == reflect.Interface && isNumber(key) {
+			// numbers of other types are equal keys, e.g. int(1) and int64(1)
+			iter := m.MapRange()
+			for iter.Next() {
+				if mk := iter.Key().Interface(); isNumber(mk) && Equal(mk, key) {
+					return iter.Value().Interface(), true
+				}
+			}
+		}
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+func isNumber(v any) bool {
+	if _, ok := ToInt(v); ok {
+		return true
+	}
+	_, ok := toFloat(v)
+	return ok
+}
+
+// intToFloat reports whether the integer key is used with a map of float keys.
+func intToFloat(k reflect.Value, keyType reflect.Type) bool {
+	_, isInt := ToInt(k.Interface())
+	return isInt && (keyType.Kind() == reflect.Float32 || keyType.Kind() == reflect.Float64)
+}
+
+func sameNumberKind(a, b reflect.Kind) bool {
+	isInt := func(k reflect.Kind) bool {
+		return k >= reflect.Int && k <= reflect.Uintptr
+	}
+	return isInt(a) && isInt(b)
+}
+
+// arithmetic applies the operator protocol to the operands and falls back to
+// the arithmetic of numbers.
+func arithmetic(op string, a, b any) (any, error) {
+	if res, ok, err := dispatchBinary(op, a, b); ok {
+		return res, err
+	}
+	return numeric(op, a, b)
+}
+
+func numeric(op string, a, b any) (any, error) {
+	if res, ok, err := numberOp(op, a, b); ok {
+		return res, err
+	}
+	return nil, typeError("unsupported operand type(s) for %s: '%s' and '%s'", operatorSymbols[op], TypeName(a), TypeName(b))
+}
+
+var errZeroDivision = errors.NewZeroDivisionError("division by zero")
+
+// Add returns the sum of numbers or the concatenation of strings or lists.
+// Values implementing `AddOp` or `RAddOp` are added with them.
+func Add(a, b any) (any, error) {
+	if res, ok, err := dispatchBinary("add", a, b); ok {
+		return res, err
+	}
+	if sa, ok := toStr(a); ok {
+		if sb, ok := toStr(b); ok {
+			return sa + sb, nil
+		}
+	}
+	if isList(a) && isList(b) {
+		ra, rb := reflect.ValueOf(DerefList(a)), reflect.ValueOf(DerefList(b))
+		res := make([]any, 0, ra.Len()+rb.Len())
+		for _, rv := range []reflect.Value{ra, rb} {
+			for i := 0; i < rv.Len(); i++ {
+				res = append(res, rv.Index(i).Interface())
+			}
+		}
+		return res, nil
+	}
+	return numeric("add", a, b)
+}
+
+func Sub(a, b any) (any, error) {
+	return arithmetic("sub", a, b)
+}
+
+// Mul returns the product of numbers or the string or list repeated the
+// number of times.
+func Mul(a, b any) (any, error) {
+	if res, ok, err := dispatchBinary("mul", a, b); ok {
+		return res, err
+	}
+	if res, ok, err := repeat(a, b); ok {
+		return res, err
+	}
+	if res, ok, err := repeat(b, a); ok {
+		return res, err
+	}
+	return numeric("mul", a, b)
+}
+
+// repeat repeats the string or list seq n times, it reports false if seq
+// isn't a sequence or n isn't an integer.
+func repeat(seq, n any) (any, bool, error) {
+	if !isList(seq) {
+		if _, ok := toStr(seq); !ok {
+			return nil, false, nil
+		}
+	}
+	count := toNumber(n)
+	switch count.kind {
+	case intNumber:
+	case bigNumber:
+		return nil, true, typeError("cannot fit 'int' into an index-sized integer")
+	default:
+		return nil, false, nil
+	}
+	times := int(count.i)
+	if times < 0 {
+		times = 0
+	}
+	if s, ok := toStr(seq); ok {
+		if times > 0 && len(s) > math.MaxInt32/times {
+			return nil, true, errors.NewTemplateRuntimeError("repeated string is too long")
+		}
+		res := strings.Repeat(s, times)
+		if m, isMarkup := seq.(Markup); isMarkup {
+			return Markup(strings.Repeat(string(m), times)), true, nil
+		}
+		return res, true, nil
+	}
+	rv := reflect.ValueOf(DerefList(seq))
+	if times > 0 && rv.Len() > math.MaxInt32/times {
+		return nil, true, errors.NewTemplateRuntimeError("repeated list is too long")
+	}
+	res := make([]any, 0, rv.Len()*times)
+	for ; times > 0; times-- {
+		for i := 0; i < rv.Len(); i++ {
+			res = append(res, rv.Index(i).Interface())
+		}
+	}
+	return res, true, nil
+}
+
+// isList reports whether the value is a slice, a pointer to a slice or an
+// array.
+func isList(v any) bool {
+	if v == nil {
+		return false
+	}
+	kind := reflect.TypeOf(DerefList(v)).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// Div divides the numbers, the result is always a float.
+func Div(a, b any) (any, error) {
+	return arithmetic("div", a, b)
+}
+
+// FloorDiv divides the numbers rounding towards negative infinity.
+func FloorDiv(a, b any) (any, error) {
+	return arithmetic("floordiv", a, b)
+}
+
+// Mod returns the remainder of the floored division, it has the sign of the
+// divisor.
+func Mod(a, b any) (any, error) {
+	return arithmetic("mod", a, b)
+}
+
+// Pow raises a to the power of b, negative integer powers are floats.
+func Pow(a, b any) (any, error) {
+	return arithmetic("pow", a, b)
+}
+
+func Neg(a any) (any, error) {
+	if n, ok := a.(NegOp); ok {
+		return n.Neg()
+	}
+	if n := toNumber(a); n.isNumber() {
+		return negate(n), nil
+	}
+	return nil, typeError("bad operand type for unary -: '%s'", TypeName(a))
+}
+
+func Pos(a any) (any, error) {
+	if p, ok := a.(PosOp); ok {
+		return p.Pos()
+	}
+	switch n := toNumber(a); n.kind {
+	case intNumber:
+		return n.i, nil
+	case bigNumber:
+		return n.b, nil
+	case floatNumber:
+		return n.f, nil
+	}
+	return nil, typeError("bad operand type for unary +: '%s'", TypeName(a))
+}
+
+// GetItem subscribes the object with the key. Missing items are reported by returning false.
+func GetItem(obj any, key any) (any, bool, error) {
+	if s, ok := key.(Slice); ok {
+		return sliceValue(obj, s)
+	}
+	if g, ok := obj.(GetItemOp); ok {
+		v, err := g.GetItem(key)
+		return v, err == nil, err
+	}
+	rv := indirect(reflect.ValueOf(obj))
+	switch rv.Kind() {
+	case reflect.Map:
+		v, ok := mapIndex(rv, key)
+		return v, ok, nil
+	case reflect.Slice, reflect.Array, reflect.String:
+		idx, ok := ToInt(key)
+		if !ok {
+			return nil, false, nil
+		}
+		if rv.Kind() == reflect.String {
+			runes := []rune(rv.String())
+			if idx < 0 {
+				idx += int64(len(runes))
+			}
+			if idx < 0 || idx >= int64(len(runes)) {
+				return nil, false, nil
+			}
+			return string(runes[idx]), true, nil
+		}
+		if idx < 0 {
+			idx += int64(rv.Len())
+		}
+		if idx < 0 || idx >= int64(rv.Len()) {
+			return nil, false, nil
+		}
+		return rv.Index(int(idx)).Interface(), true, nil
+	}
+	return nil, false, nil
+}
+
+func sliceValue(obj any, s Slice) (any, bool, error) {
+	rv := indirect(reflect.ValueOf(obj))
+	var items []any
+	isString := false
+	switch rv.Kind() {
+	case reflect.String:
+		isString = true
+		for _, r := range rv.String() {
+			items = append(items, string(r))
+		}
+	case reflect.Slice, reflect.Array:
+		items = make([]any, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+	default:
+		return nil, false, nil
+	}
+
+	step := 1
+	if s.Step != nil {
+		step = *s.Step
+	}
+	if step == 0 {
+		return nil, false, typeError("slice step cannot be zero")
+	}
+	n := len(items)
+	clamp := func(p *int, def int) int {
+		if p == nil {
+			return def
+		}
+		i := *p
+		if i < 0 {
+			i += n
+		}
+		lower, upper := 0, n
+		if step < 0 {
+			lower, upper = -1, n-1
+		}
+		if i < lower {
+			return lower
+		}
+		if i > upper {
+			return upper
+		}
+		return i
+	}
+	var res []any
+	if step > 0 {
+		for i := clamp(s.Start, 0); i < clamp(s.Stop, n); i += step {
+			res = append(res, items[i])
+		}
+	} else {
+		for i := clamp(s.Start, n-1); i > clamp(s.Stop, -1); i += step {
+			res = append(res, items[i])
+		}
+	}
+
+	if isString {
+		var b strings.Builder
+		for _, r := range res {
+			b.WriteString(r.(string))
+		}
+		return b.String(), true, nil
+	}
+	if res == nil {
+		res = []any{}
+	}
+	return res, true, nil
+}
+
+// Call calls the value with the arguments. Functions of the type
+// `func([]any, map[string]any) (any, error)` and values implementing `CallOp`
+// receive the keyword arguments, other functions are called with the
+// positional arguments converted to the types of their parameters. Panics of
+// these functions are returned as errors.
+func Call(fn any, args []any, kwargs map[string]any) (any, error) {
+	switch f := fn.(type) {
+	case func([]any, map[string]any) (any, error):
+		return f(args, kwargs)
+	case CallOp:
+		return f.Call(args, kwargs)
+	}
+
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, typeError("'%s' object is not callable", TypeName(fn))
+	}
+	if len(kwargs) > 0 {
+		return nil, typeError("%s() got unexpected keyword arguments", TypeName(fn))
+	}
+
+	t := rv.Type()
+	numIn := t.NumIn()
+	if (!t.IsVariadic() && len(args) != numIn) || (t.IsVariadic() && len(args) < numIn-1) {
+		return nil, typeError("function takes %d arguments but %d were given", numIn, len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			pt = t.In(numIn - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+		v, err := convertArg(arg, pt)
+		if err != nil {
+			return nil, err
+		}
+		in[i] = v
+	}
+
+	out, err := callFunc(rv, in)
+	if err != nil {
+		return nil, err
+	}
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	if len(out) > 0 && t.Out(len(out)-1) == errorType {
+		if err := out[len(out)-1].Interface(); err != nil {
+			return nil, err.(error)
+		}
+		out = out[:len(out)-1]
+	}
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out[0].Interface(), nil
+	default:
+		res := make([]any, len(out))
+		for i, o := range out {
+			res[i] = o.Interface()
+		}
+		return res, nil
+	}
+}
+
+// callFunc calls the function, panics are returned as a `TemplateRuntimeError`.
+func callFunc(fn reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.NewTemplateRuntimeError(fmt.Sprintf("call of %s panicked: %v", fn.Type(), p))
+		}
+	}()
+	return fn.Call(in), nil
+}
+
+func convertArg(arg any, t reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, typeError("cannot use None as argument of type %s", t)
+	}
+	v := reflect.ValueOf(arg)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if list := reflect.ValueOf(DerefList(arg)); list.Type().AssignableTo(t) {
+		return list, nil
+	}
+	isNum := toNumber(arg).isNumber()
+	isNumType := t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64
+	if (isNum && isNumType || v.Kind() == t.Kind()) && v.Type().ConvertibleTo(t) {
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, typeError("cannot use %s as argument of type %s", TypeName(arg), t)
+}
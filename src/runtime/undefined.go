@@ -2,200 +2,256 @@ package runtime
 
 import (
 	"fmt"
+	"hash/fnv"
+	"reflect"
+
 	"github.com/gojinja/gojinja/src/errors"
 	"github.com/gojinja/gojinja/src/utils"
-	"log"
-	"reflect"
-	"strconv"
 )
 
+// IUndefined is implemented by the values of missing variables, attributes and
+// items. Custom undefined types usually embed one of the types below and
+// override the operators they handle differently.
+type IUndefined interface {
+	Undefined()
+}
+
+// UndefinedConstructor creates the undefined value of a missing variable
+// (obj is `utils.Missing`), attribute or item. The name is the name of the
+// variable or attribute or the key of the item, nil if unknown. The hint replaces the default
+// message of the error returned by failing operations, the error is created
+// with exc (`errors.NewUndefinedError` if nil).
+type UndefinedConstructor func(hint *string, obj any, name any, exc func(msg string) error) IUndefined
+
+// BaseUndefined is the default undefined value, Jinja's `Undefined`. It's
+// printed and iterated over like an empty string and is false, the other
+// operations fail with an `errors.UndefinedError`.
 type BaseUndefined struct {
-	hint   *string
-	obj    any
-	name   *string
-	exc    func(msg string) error
-	logger *log.Logger
+	hint *string
+	obj  any
+	name any
+	exc  func(msg string) error
 }
 
+// StrictUndefined also fails when it's printed, iterated over, compared or
+// used as a bool. Only the `defined` and `undefined` tests work with it.
 type StrictUndefined struct {
 	BaseUndefined
 }
 
+// DebugUndefined is printed as a message describing what is undefined.
 type DebugUndefined struct {
 	BaseUndefined
 }
 
+// ChainableUndefined returns itself for all attributes and items, so that
+// lookups on undefined values don't fail.
 type ChainableUndefined struct {
 	BaseUndefined
 }
 
 func (BaseUndefined) Undefined() {}
 
-type IUndefined interface {
-	Undefined()
-}
+var (
+	_ IUndefined = BaseUndefined{}
+	_ IUndefined = StrictUndefined{}
+	_ IUndefined = ChainableUndefined{}
+	_ IUndefined = DebugUndefined{}
 
-var _ IUndefined = BaseUndefined{}
-var _ IUndefined = StrictUndefined{}
-var _ IUndefined = ChainableUndefined{}
-var _ IUndefined = DebugUndefined{}
+	_ UndefinedConstructor = NewUndefined
+	_ UndefinedConstructor = NewStrictUndefined
+	_ UndefinedConstructor = NewChainableUndefined
+	_ UndefinedConstructor = NewDebugUndefined
+)
 
-func NewUndefined(hint *string, obj any, name *string, exc func(msg string) error, logger *log.Logger) BaseUndefined {
+func newBaseUndefined(hint *string, obj any, name any, exc func(msg string) error) BaseUndefined {
 	if exc == nil {
-		exc = errors.TemplateError
+		exc = errors.NewUndefinedError
 	}
-	return BaseUndefined{hint, obj, name, exc, logger}
+	return BaseUndefined{hint, obj, name, exc}
+}
+
+func NewUndefined(hint *string, obj any, name any, exc func(msg string) error) IUndefined {
+	return newBaseUndefined(hint, obj, name, exc)
 }
 
-func NewStrictUndefined(hint *string, obj any, name *string, exc func(msg string) error, logger *log.Logger) StrictUndefined {
-	return StrictUndefined{NewUndefined(hint, obj, name, exc, logger)}
+func NewStrictUndefined(hint *string, obj any, name any, exc func(msg string) error) IUndefined {
+	return StrictUndefined{newBaseUndefined(hint, obj, name, exc)}
 }
 
-func NewChainableUndefined(hint *string, obj any, name *string, exc func(msg string) error, logger *log.Logger) ChainableUndefined {
-	return ChainableUndefined{NewUndefined(hint, obj, name, exc, logger)}
+func NewChainableUndefined(hint *string, obj any, name any, exc func(msg string) error) IUndefined {
+	return ChainableUndefined{newBaseUndefined(hint, obj, name, exc)}
 }
 
-func NewDebugUndefined(hint *string, obj any, name *string, exc func(msg string) error, logger *log.Logger) StrictUndefined {
-	return StrictUndefined{NewUndefined(hint, obj, name, exc, logger)}
+func NewDebugUndefined(hint *string, obj any, name any, exc func(msg string) error) IUndefined {
+	return DebugUndefined{newBaseUndefined(hint, obj, name, exc)}
 }
 
-func (u BaseUndefined) undefinedMessage() string {
+// Message returns the message of the error returned by failing operations.
+func (u BaseUndefined) Message() string {
 	if u.hint != nil {
 		return *u.hint
 	}
 	if _, ok := u.obj.(utils.Missing); ok {
-		return fmt.Sprintf("'%s' is undefined", *u.name)
+		return fmt.Sprintf("%s is undefined", Repr(u.name))
 	}
-	// Following if is a rewrite of python code below. I don't undeestand neither the message nor the logic, as undefined_name ought to be Optional[string].
-
-	//if not isinstance(self._undefined_name, str):
-	//return (
-	//	f"{object_type_repr(self._undefined_obj)} has no"
-	//f" element {self._undefined_name!r}"
-	//)
-	if u.name == nil {
-		return fmt.Sprintf("'%s' has no element 'None'", objectTypeRepr(u.obj))
+	if _, ok := u.name.(string); !ok {
+		return fmt.Sprintf("%s has no element %s", ObjectTypeRepr(u.obj), Repr(u.name))
 	}
-	return fmt.Sprintf("'%s' has no attribute '%s'", objectTypeRepr(u.obj), *u.name)
+	return fmt.Sprintf("%s has no attribute %s", Repr(ObjectTypeRepr(u.obj)), Repr(u.name))
 }
 
-func (u BaseUndefined) logMessage() {
-	if u.logger != nil {
-		u.logger.Printf("Template variable warning: %s", u.undefinedMessage())
+// undefinedName returns the name of the variable or attribute or the
+// representation of the key of the item, empty if it's unknown.
+func undefinedName(name any) string {
+	switch n := name.(type) {
+	case nil:
+		return ""
+	case string:
+		return n
 	}
+	return Repr(name)
 }
 
-func (u BaseUndefined) failWithUndefinedError() error {
-	err := u.exc(u.undefinedMessage())
-	if u.logger != nil {
-		log.Printf("Template variable error: %v", err)
+// Fail returns the error of failing operations.
+func (u BaseUndefined) Fail() error {
+	exc := u.exc
+	if exc == nil {
+		exc = errors.NewUndefinedError
 	}
-	return err
+	return exc(u.Message())
+}
+
+func (u BaseUndefined) GetAttr(string) (any, error) {
+	return nil, u.Fail()
+}
+
+func (u BaseUndefined) GetItem(any) (any, error) {
+	return nil, u.Fail()
+}
+
+func (u BaseUndefined) Call([]any, map[string]any) (any, error) {
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Add(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) RAdd(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Sub(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) RSub(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Mul(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) RMul(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Div(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) RDiv(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) FloorDiv(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) RFloorDiv(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Mod(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) RMod(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
-func (u BaseUndefined) Eq(a any) (any, error) {
-	return reflect.TypeOf(a).Name() == reflect.TypeOf(u).Name(), nil
+func (u BaseUndefined) Pow(any) (any, error) {
+	return nil, u.Fail()
 }
 
-func (u BaseUndefined) Ne(a any) (any, error) {
-	eq, err := u.Eq(a)
-	return !eq.(bool), err
+func (u BaseUndefined) RPow(any) (any, error) {
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Lt(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Le(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Gt(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
 func (u BaseUndefined) Ge(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+	return nil, u.Fail()
 }
 
-func (u BaseUndefined) Pow(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+func (u BaseUndefined) Pos() (any, error) {
+	return nil, u.Fail()
 }
 
-func (u BaseUndefined) RPow(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+func (u BaseUndefined) Neg() (any, error) {
+	return nil, u.Fail()
 }
 
-func (u BaseUndefined) Pos() (any, error) {
-	return nil, u.failWithUndefinedError()
+func (u BaseUndefined) Int() (int64, error) {
+	return 0, u.Fail()
 }
 
-func (u BaseUndefined) Neg() (any, error) {
-	return nil, u.failWithUndefinedError()
+func (u BaseUndefined) Float() (float64, error) {
+	return 0, u.Fail()
 }
 
-func (u BaseUndefined) Bool() (bool, error) {
-	u.logMessage()
-	return false, nil
+func (u BaseUndefined) Complex() (complex128, error) {
+	return 0, u.Fail()
 }
 
-func (BaseUndefined) Repr() string {
-	return "BaseUndefined"
+// Eq reports whether the other value is an undefined value of the same type.
+func (u BaseUndefined) Eq(other any) (any, error) {
+	return sameType(u, other), nil
+}
+
+func (u BaseUndefined) Ne(other any) (any, error) {
+	return !sameType(u, other), nil
+}
+
+// Hash returns the same hash for all undefined values of the type.
+func (u BaseUndefined) Hash() (int64, error) {
+	return typeHash(u), nil
+}
+
+func (u BaseUndefined) Bool() (bool, error) {
+	return false, nil
 }
 
 func (u BaseUndefined) String_() (string, error) {
-	u.logMessage()
 	return "", nil
 }
 
+func (BaseUndefined) Repr() string {
+	return "Undefined"
+}
+
 func (u BaseUndefined) Len() (int, error) {
 	return 0, nil
 }
@@ -204,96 +260,145 @@ func (u BaseUndefined) Iter() ([]any, error) {
 	return nil, nil
 }
 
-func (u BaseUndefined) Call(...any) (any, error) {
-	return nil, u.failWithUndefinedError()
+var (
+	_ AddOp       = BaseUndefined{}
+	_ RAddOp      = BaseUndefined{}
+	_ SubOp       = BaseUndefined{}
+	_ RSubOp      = BaseUndefined{}
+	_ MulOp       = BaseUndefined{}
+	_ RMulOp      = BaseUndefined{}
+	_ DivOp       = BaseUndefined{}
+	_ RDivOp      = BaseUndefined{}
+	_ FloorDivOp  = BaseUndefined{}
+	_ RFloorDivOp = BaseUndefined{}
+	_ ModOp       = BaseUndefined{}
+	_ RModOp      = BaseUndefined{}
+	_ PowOp       = BaseUndefined{}
+	_ RPowOp      = BaseUndefined{}
+	_ NegOp       = BaseUndefined{}
+	_ PosOp       = BaseUndefined{}
+	_ EqOp        = BaseUndefined{}
+	_ NeOp        = BaseUndefined{}
+	_ LtOp        = BaseUndefined{}
+	_ LeOp        = BaseUndefined{}
+	_ GtOp        = BaseUndefined{}
+	_ GeOp        = BaseUndefined{}
+	_ LenOp       = BaseUndefined{}
+	_ IterOp      = BaseUndefined{}
+	_ GetItemOp   = BaseUndefined{}
+	_ CallOp      = BaseUndefined{}
+	_ BoolOp      = BaseUndefined{}
+)
+
+func sameType(a, b any) bool {
+	return b != nil && reflect.TypeOf(a) == reflect.TypeOf(b)
 }
 
-func (u BaseUndefined) GetItem(any) (any, error) {
-	return nil, u.failWithUndefinedError()
+func typeHash(v any) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(reflect.TypeOf(v).String()))
+	return int64(h.Sum64())
 }
 
-func (u BaseUndefined) Int(any) (int64, error) {
-	return 0, u.failWithUndefinedError()
+// The types embedding `BaseUndefined` define their own `Eq`, `Ne` and `Hash`
+// as the embedded value doesn't know the type of the outer one.
+
+func (u ChainableUndefined) Eq(other any) (any, error) {
+	return sameType(u, other), nil
 }
 
-func (u BaseUndefined) Float(any) (int64, error) {
-	return 0, u.failWithUndefinedError()
+func (u ChainableUndefined) Ne(other any) (any, error) {
+	return !sameType(u, other), nil
 }
 
-func (u BaseUndefined) Complex(any) (int64, error) {
-	return 0, u.failWithUndefinedError()
+func (u ChainableUndefined) Hash() (int64, error) {
+	return typeHash(u), nil
 }
 
-func (u BaseUndefined) Hash() (int64, error) {
-	h, _ := strconv.ParseInt(fmt.Sprintf("%p", &u), 0, 64)
-	return h, nil
+func (u ChainableUndefined) HTML() (string, error) {
+	return u.String_()
 }
 
-func (su StrictUndefined) String_() (string, error) {
-	su.logMessage()
-	return "", su.failWithUndefinedError()
+func (u ChainableUndefined) GetAttr(string) (any, error) {
+	return u, nil
 }
 
-func (su StrictUndefined) Bool() (bool, error) {
-	su.logMessage()
-	return false, su.failWithUndefinedError()
+func (u ChainableUndefined) GetItem(any) (any, error) {
+	return u, nil
 }
 
-func (su StrictUndefined) Eq() (any, error) {
-	return nil, su.failWithUndefinedError()
+func (u DebugUndefined) Eq(other any) (any, error) {
+	return sameType(u, other), nil
 }
 
-func (su StrictUndefined) Ne() (any, error) {
-	return nil, su.failWithUndefinedError()
+func (u DebugUndefined) Ne(other any) (any, error) {
+	return !sameType(u, other), nil
 }
 
-func (su StrictUndefined) Hash() (int64, error) {
-	return 0, su.failWithUndefinedError()
+func (u DebugUndefined) Hash() (int64, error) {
+	return typeHash(u), nil
 }
 
-func (su StrictUndefined) Len() (int64, error) {
-	return 0, su.failWithUndefinedError()
+func (u DebugUndefined) String_() (string, error) {
+	var msg string
+	if u.hint != nil {
+		msg = fmt.Sprintf("undefined value printed: %s", *u.hint)
+	} else if _, ok := u.obj.(utils.Missing); ok && u.name != nil {
+		msg = undefinedName(u.name)
+	} else {
+		msg = fmt.Sprintf("no such element: %s[%s]", ObjectTypeRepr(u.obj), Repr(u.name))
+	}
+	return fmt.Sprintf("{{ %s }}", msg), nil
 }
 
-func (su StrictUndefined) Iter() ([]any, error) {
-	return nil, su.failWithUndefinedError()
+func (u StrictUndefined) String_() (string, error) {
+	return "", u.Fail()
 }
 
-func (su StrictUndefined) Contains(any) (bool, error) {
-	return false, su.failWithUndefinedError()
+func (u StrictUndefined) Bool() (bool, error) {
+	return false, u.Fail()
 }
 
-func objectTypeRepr(a any) string {
-	return reflect.TypeOf(a).Name()
+func (u StrictUndefined) Eq(any) (any, error) {
+	return nil, u.Fail()
 }
 
-func (du DebugUndefined) String_() (string, error) {
-	du.logMessage()
-	name := "None"
-	if du.name != nil {
-		name = *du.name
-	}
+func (u StrictUndefined) Ne(any) (any, error) {
+	return nil, u.Fail()
+}
 
-	var msg string
-	if du.hint != nil {
-		msg = fmt.Sprintf("undefined value printed: %s", *du.hint)
-	} else if _, ok := du.obj.(utils.Missing); ok {
-		msg = name
-	} else {
-		msg = fmt.Sprintf("no such element: '%s' [%s]", objectTypeRepr(du.obj), name)
-	}
+func (u StrictUndefined) Hash() (int64, error) {
+	return 0, u.Fail()
+}
 
-	return fmt.Sprintf("{{ %s }}", msg), nil
+func (u StrictUndefined) Len() (int, error) {
+	return 0, u.Fail()
 }
 
-func (cu ChainableUndefined) HTML() (string, error) {
-	return cu.String_()
+func (u StrictUndefined) Iter() ([]any, error) {
+	return nil, u.Fail()
 }
 
-func (cu ChainableUndefined) GetAttr() (any, error) {
-	return cu, nil
+func (u StrictUndefined) Contains(any) (bool, error) {
+	return false, u.Fail()
 }
 
-func (cu ChainableUndefined) GetItem() (any, error) {
-	return cu, nil
+var (
+	_ EqOp       = StrictUndefined{}
+	_ NeOp       = StrictUndefined{}
+	_ LenOp      = StrictUndefined{}
+	_ IterOp     = StrictUndefined{}
+	_ BoolOp     = StrictUndefined{}
+	_ ContainsOp = StrictUndefined{}
+	_ GetItemOp  = ChainableUndefined{}
+	_ Escaped    = ChainableUndefined{}
+)
+
+// ObjectTypeRepr returns the description of the type of the value used in
+// the messages of undefined values, e.g. "dict object" or "None".
+func ObjectTypeRepr(a any) string {
+	if a == nil {
+		return "None"
+	}
+	return TypeName(a) + " object"
 }